@@ -0,0 +1,336 @@
+package anvil
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestWriteReadChunks(t *testing.T) {
+	name := tempRegion(t)
+	r, err := Open(name, libdeflate.DefaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for x := 0; x < 32; x += 3 {
+		for z := 0; z < 32; z += 5 {
+			if err := r.WriteChunk(x, -32+z, chunkData(x, z)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if r.Exists(1, 0) || !r.Exists(3, 5) || !r.Exists(3-32, 5+64) {
+		t.Error("Exists reports wrong chunks")
+	}
+	if r.Timestamp(3, 5).IsZero() || !r.Timestamp(1, 0).IsZero() {
+		t.Error("Timestamp reports wrong times")
+	}
+	if _, err := r.ReadChunk(1, 0); err != ErrChunkNotFound {
+		t.Errorf("expected ErrChunkNotFound, got %v", err)
+	}
+	r.Close()
+
+	// reopen and read all chunks
+	r, err = Open(name, libdeflate.DefaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	checkChunks(r, func(x, z int) []byte {
+		if x%3 != 0 || z%5 != 0 {
+			return nil
+		}
+		return chunkData(x, z)
+	}, t)
+}
+
+func TestStdFormat(t *testing.T) {
+	name := tempRegion(t)
+	r, _ := Open(name, libdeflate.DefaultCompressionLevel)
+	r.WriteChunk(2, 1, chunkData(2, 1))
+	r.Close()
+
+	// the chunk is stored as Minecraft would store it, decodable by compress/zlib
+	file, _ := ioutil.ReadFile(name)
+	loc := binary.BigEndian.Uint32(file[4*(2+1*32):])
+	offset := int(loc>>8) * SectorSize
+	length := int(binary.BigEndian.Uint32(file[offset:]))
+	if file[offset+4] != byte(CompressionZlib) || len(file)%SectorSize != 0 {
+		t.Fatal("invalid chunk layout")
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(file[offset+5 : offset+4+length]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(zr)
+	slicesEqual(chunkData(2, 1), out, t)
+}
+
+func TestCompressionTypes(t *testing.T) {
+	r, _ := Open(tempRegion(t), libdeflate.DefaultCompressionLevel)
+	defer r.Close()
+	for i, typ := range []Compression{CompressionGzip, CompressionNone, CompressionZlib} {
+		r.SetCompression(typ)
+		if err := r.WriteChunk(i, 0, chunkData(i, 0)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		out, err := r.ReadChunk(i, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(chunkData(i, 0), out, t)
+	}
+
+	r.SetCompression(4) // LZ4 isn't supported
+	if err := r.WriteChunk(0, 0, shortString); err != errorCompression {
+		t.Errorf("expected errorCompression, got %v", err)
+	}
+	if err := r.WriteChunk(0, 0, nil); err != errorEmptyChunk {
+		t.Errorf("expected errorEmptyChunk, got %v", err)
+	}
+}
+
+func TestSectorAllocation(t *testing.T) {
+	name := tempRegion(t)
+	r, _ := Open(name, libdeflate.DefaultCompressionLevel)
+	defer r.Close()
+
+	big := randomData(3 * SectorSize)
+	r.WriteChunk(0, 0, big)
+	r.WriteChunk(1, 0, shortString)
+	offset0, count0 := r.location(0)
+	if offset0 != headerSectors || count0 != 4 {
+		t.Fatalf("chunk 0 at sector %d with %d sectors", offset0, count0)
+	}
+
+	// a smaller chunk is rewritten in place
+	r.WriteChunk(0, 0, shortString)
+	if offset, count := r.location(0); offset != offset0 || count != 1 {
+		t.Errorf("smaller chunk moved to sector %d with %d sectors", offset, count)
+	}
+	// the freed sectors are reused
+	r.WriteChunk(2, 0, randomData(SectorSize))
+	if offset, _ := r.location(2); offset != offset0+1 {
+		t.Errorf("chunk written to sector %d, expected %d", offset, offset0+1)
+	}
+	// a larger chunk is moved to the end, extending the free sectors there
+	r.WriteChunk(1, 0, big)
+	if offset, _ := r.location(1); offset != offset0+3 {
+		t.Errorf("larger chunk written to sector %d, expected %d", offset, offset0+3)
+	}
+
+	if err := r.DeleteChunk(2, 0); err != nil {
+		t.Fatal(err)
+	}
+	if r.Exists(2, 0) {
+		t.Error("deleted chunk still exists")
+	}
+
+	if err := r.Defragment(); err != nil {
+		t.Fatal(err)
+	}
+	fi, _ := os.Stat(name)
+	if fi.Size() != (headerSectors+1+4)*SectorSize {
+		t.Errorf("defragmented size %d", fi.Size())
+	}
+	checkChunks(r, func(x, z int) []byte {
+		switch {
+		case x == 0 && z == 0:
+			return shortString
+		case x == 1 && z == 0:
+			return big
+		}
+		return nil
+	}, t)
+}
+
+func TestOversizedChunk(t *testing.T) {
+	name := tempRegion(t)
+	r, _ := Open(name, libdeflate.DefaultCompressionLevel)
+	defer r.Close()
+
+	huge := randomData(maxSectorCount*SectorSize + 1)
+	if err := r.WriteChunk(3, 4, huge); err != nil {
+		t.Fatal(err)
+	}
+	external := filepath.Join(filepath.Dir(name), "c.3.-28.mcc")
+	if _, err := os.Stat(external); err != nil {
+		t.Fatal(err)
+	}
+	out, err := r.ReadChunk(3, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(huge, out, t)
+
+	r.WriteChunk(3, 4, shortString)
+	if _, err := os.Stat(external); !os.IsNotExist(err) {
+		t.Error("external chunk file was not removed")
+	}
+
+	// oversized chunks need the region coordinates
+	other, _ := Open(filepath.Join(filepath.Dir(name), "region.mca"), libdeflate.DefaultCompressionLevel)
+	defer other.Close()
+	if err := other.WriteChunk(0, 0, huge); err != errorExternal {
+		t.Errorf("expected errorExternal, got %v", err)
+	}
+}
+
+func TestCorruptRegion(t *testing.T) {
+	name := tempRegion(t)
+	ioutil.WriteFile(name, make([]byte, 100), 0644)
+	if _, err := Open(name, libdeflate.DefaultCompressionLevel); err != errorCorrupt {
+		t.Errorf("expected errorCorrupt, got %v", err)
+	}
+
+	// locations beyond the end of the file are treated as missing chunks
+	hdr := make([]byte, headerSectors*SectorSize)
+	binary.BigEndian.PutUint32(hdr, 10<<8|1)
+	ioutil.WriteFile(name, hdr, 0644)
+	r, err := Open(name, libdeflate.DefaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if r.Exists(0, 0) {
+		t.Error("invalid chunk exists")
+	}
+
+	if _, err := Open(name, 13); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestRecompress(t *testing.T) {
+	name := tempRegion(t)
+	r, _ := Open(name, libdeflate.MinCompressionLevel)
+	for i := 0; i < 100; i++ {
+		r.WriteChunk(i%32, i/32, chunkData(i, 0))
+	}
+	r.DeleteChunk(5, 0)
+	before, _ := os.Stat(name)
+	stamp := r.Timestamp(1, 0)
+
+	if err := r.Recompress(13, 0); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if err := r.Recompress(libdeflate.MaxCompressionLevel, 4); err != nil {
+		t.Fatal(err)
+	}
+	after, _ := os.Stat(name)
+	if after.Size() >= before.Size() {
+		t.Errorf("recompressed size %d, before %d", after.Size(), before.Size())
+	}
+	if !r.Timestamp(1, 0).Equal(stamp) {
+		t.Error("timestamp was not kept")
+	}
+
+	expected := func(x, z int) []byte {
+		i := x + 32*z
+		if i >= 100 || i == 5 {
+			return nil
+		}
+		return chunkData(i, 0)
+	}
+	checkChunks(r, expected, t)
+	r.Close()
+
+	r, _ = Open(name, libdeflate.DefaultCompressionLevel)
+	defer r.Close()
+	checkChunks(r, expected, t)
+}
+
+func TestHighlyCompressibleChunk(t *testing.T) {
+	// chunks of air compress far better than the default decompression factor
+	data := make([]byte, 1<<20)
+	r, _ := Open(tempRegion(t), libdeflate.DefaultCompressionLevel)
+	defer r.Close()
+	if err := r.WriteChunk(0, 0, data); err != nil {
+		t.Fatal(err)
+	}
+	out, err := r.ReadChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(data, out, t)
+
+	if err := r.Recompress(libdeflate.MaxCompressionLevel, 2); err != nil {
+		t.Fatal(err)
+	}
+	out, err = r.ReadChunk(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(data, out, t)
+}
+
+func tempRegion(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "anvil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return filepath.Join(dir, "r.0.-1.mca")
+}
+
+// chunkData returns compressible data of varying size resembling NBT.
+func chunkData(x, z int) []byte {
+	buf := &bytes.Buffer{}
+	for i := 0; i < 20+x*7+z*13; i++ {
+		buf.WriteString("\x0a\x00\x08sections")
+		binary.Write(buf, binary.BigEndian, int32(x*1000+z*10+i))
+		buf.Write(shortString[:i%len(shortString)])
+	}
+	return buf.Bytes()
+}
+
+func randomData(n int) []byte {
+	b := make([]byte, n)
+	rand.New(rand.NewSource(int64(n))).Read(b)
+	return b
+}
+
+func checkChunks(r *Region, expected func(x, z int) []byte, t *testing.T) {
+	for x := 0; x < 32; x++ {
+		for z := 0; z < 32; z++ {
+			data := expected(x, z)
+			out, err := r.ReadChunk(x, z)
+			if data == nil {
+				if err != ErrChunkNotFound {
+					t.Fatalf("chunk %d, %d: expected ErrChunkNotFound, got %v", x, z, err)
+				}
+				continue
+			}
+			if err != nil {
+				t.Fatalf("chunk %d, %d: %v", x, z, err)
+			}
+			slicesEqual(data, out, t)
+		}
+	}
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
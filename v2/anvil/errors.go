@@ -0,0 +1,14 @@
+package anvil
+
+import "errors"
+
+// ErrChunkNotFound is returned if a chunk is not present in the region.
+var ErrChunkNotFound = errors.New("libdeflate: anvil: chunk not found")
+
+var (
+	errorCorrupt      = errors.New("libdeflate: anvil: corrupt region file")
+	errorCompression  = errors.New("libdeflate: anvil: unsupported chunk compression")
+	errorExternal     = errors.New("libdeflate: anvil: oversized chunks require the region coordinates in the file name")
+	errorEmptyChunk   = errors.New("libdeflate: anvil: empty chunk")
+	errorInvalidLevel = errors.New("libdeflate: anvil: invalid compression level")
+)
@@ -0,0 +1,154 @@
+package anvil
+
+import (
+	"runtime"
+	"sort"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// Defragment moves all chunks to the beginning of the file without gaps, keeping their order in the file,
+// and truncates the free sectors at its end. The chunks are moved without being decompressed.
+func (r *Region) Defragment() error {
+	var chunks []int
+	for i := range r.locations {
+		if r.locations[i] != 0 {
+			chunks = append(chunks, i)
+		}
+	}
+	sort.Slice(chunks, func(a, b int) bool {
+		return r.locations[chunks[a]] < r.locations[chunks[b]]
+	})
+
+	// chunks only move towards the beginning of the file, so no chunk is overwritten before it has been moved
+	next := headerSectors
+	var buf []byte
+	for _, i := range chunks {
+		offset, count := r.location(i)
+		if offset != next {
+			if cap(buf) < count*SectorSize {
+				buf = make([]byte, count*SectorSize)
+			}
+			buf = buf[:count*SectorSize]
+			if _, err := r.f.ReadAt(buf, int64(offset)*SectorSize); err != nil {
+				return corruptOnEOF(err)
+			}
+			if _, err := r.f.WriteAt(buf, int64(next)*SectorSize); err != nil {
+				return err
+			}
+			if err := r.setLocation(i, next, count, r.timestamps[i]); err != nil {
+				return err
+			}
+		}
+		next += count
+	}
+	r.used = r.used[:next]
+	r.mark(headerSectors, next-headerSectors, true)
+	return r.truncate(next)
+}
+
+// Recompress decompresses all chunks and compresses them again at the given level with the compression type
+// set by SetCompression, on the given number of workers. If workers <= 0, GOMAXPROCS workers are used.
+// The chunks are written without gaps afterwards, so the region is defragmented as well. Timestamps are kept.
+//
+// All chunks are held in memory during recompression and nothing is written before all of them have been recompressed,
+// so if a chunk can't be read or recompressed, the file is unchanged. The chunks are then rewritten in place, overwriting
+// sectors the header on disk still refers to: if writing fails or the process dies while writing, the region is left corrupt.
+// Copy the file beforehand if it must survive such failures.
+// Errors if an invalid compression level was passed.
+func (r *Region) Recompress(level, workers int) error {
+	if level < libdeflate.MinCompressionLevel || level > libdeflate.MaxCompressionLevel {
+		return errorInvalidLevel
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	var jobs []*recompressJob
+	for i := range r.locations {
+		if r.locations[i] == 0 {
+			continue
+		}
+		typ, comp, err := r.readRaw(i)
+		if err != nil {
+			return err
+		}
+		jobs = append(jobs, &recompressJob{i: i, typ: typ, comp: comp})
+	}
+
+	queue := make(chan *recompressJob)
+	errs := make(chan error, workers)
+	for w := 0; w < workers; w++ {
+		go func() {
+			errs <- recompressWorker(queue, level, r.compression)
+		}()
+	}
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	var err error
+	for w := 0; w < workers; w++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	// lay out all chunks anew from the beginning of the file
+	for i := range r.locations {
+		r.locations[i] = 0
+	}
+	r.used = r.used[:headerSectors]
+	for _, j := range jobs {
+		if err := r.writeBlob(j.i, j.blob, r.timestamps[j.i]); err != nil {
+			return err
+		}
+	}
+	return r.truncate(len(r.used))
+}
+
+type recompressJob struct {
+	i    int
+	typ  Compression
+	comp []byte
+	blob []byte // the recompressed chunk including its header
+}
+
+// recompressWorker recompresses the jobs received from queue with its own Compressor and Decompressor.
+// After an error, the remaining jobs are drained without being processed.
+func recompressWorker(queue <-chan *recompressJob, level int, typ Compression) error {
+	c, err := libdeflate.NewCompressorLevel(level)
+	if err != nil {
+		for range queue {
+		}
+		return err
+	}
+	defer c.Close()
+	dc, err := libdeflate.NewDecompressorWithExtendedDecompression(libdeflate.MaxPossibleDecompressionFactor)
+	if err != nil {
+		for range queue {
+		}
+		return err
+	}
+	defer dc.Close()
+
+	for j := range queue {
+		if err != nil {
+			continue
+		}
+		var data []byte
+		if data, err = decompress(dc, j.typ, j.comp); err == nil {
+			j.blob, err = compress(c, typ, data)
+		}
+	}
+	return err
+}
+
+// truncate drops all sectors from sector end on and truncates the file accordingly.
+func (r *Region) truncate(end int) error {
+	r.used = r.used[:end]
+	return r.f.Truncate(int64(end) * SectorSize)
+}
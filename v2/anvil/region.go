@@ -0,0 +1,387 @@
+// Package anvil reads and writes Minecraft region files (.mca) of the Anvil format, compressing and
+// decompressing their chunks with libdeflate.
+//
+// A region file holds up to 32x32 chunks. It starts with a header of two sectors of 4 KiB: the location table
+// holding the offset and number of sectors of every chunk, followed by the timestamps of the last modifications.
+// Every chunk is stored in whole sectors as its length, its compression type and the compressed data.
+// Chunks that do not fit into 255 sectors are stored in an external c.<x>.<z>.mcc file next to the region file.
+package anvil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// SectorSize is the size of a sector of a region file, in which chunks are allocated.
+const SectorSize = 4096
+
+const (
+	headerSectors   = 2
+	chunksPerRegion = 32 * 32
+	maxSectorCount  = 255 // the sector count of a location is a single byte
+	chunkHeaderSize = 5   // length and compression type
+	externalFlag    = 0x80
+)
+
+// Compression is the compression type of a chunk.
+type Compression byte
+
+// Compression types of chunks, as used by Minecraft.
+const (
+	CompressionGzip Compression = 1
+	CompressionZlib Compression = 2
+	CompressionNone Compression = 3
+)
+
+// Region is an open region file, providing random access to its chunks.
+// Chunks are written to the first free sectors that fit them, so a Region may become fragmented by rewritten chunks.
+// See Defragment and Recompress.
+//
+// A single Region must not be used across multiple threads concurrently.
+// Always Close() the Region to close the file and free the c memory of the underlying Compressor and Decompressor.
+type Region struct {
+	f           *os.File
+	dir         string
+	rx, rz      int
+	hasCoords   bool // whether rx and rz were parsed from the file name
+	locations   [chunksPerRegion]uint32
+	timestamps  [chunksPerRegion]uint32
+	used        []bool // allocated sectors, including the header
+	c           libdeflate.Compressor
+	dc          libdeflate.Decompressor
+	compression Compression
+}
+
+// Open opens the region file name for reading and writing, creating it if it does not exist.
+// Chunks are written zlib compressed at the given level, which is what Minecraft writes by default (see SetCompression).
+// The region coordinates are parsed from file names like r.<x>.<z>.mca, which is required for oversized chunks.
+// Errors if the file can't be opened, if the header is corrupt, if out of memory or if an invalid compression level was passed.
+func Open(name string, level int) (*Region, error) {
+	c, err := libdeflate.NewCompressorLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	dc, err := libdeflate.NewDecompressorWithExtendedDecompression(libdeflate.MaxPossibleDecompressionFactor)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		c.Close()
+		dc.Close()
+		return nil, err
+	}
+
+	r := &Region{f: f, dir: filepath.Dir(name), c: c, dc: dc, compression: CompressionZlib}
+	_, err = fmt.Sscanf(filepath.Base(name), "r.%d.%d.mca", &r.rx, &r.rz)
+	r.hasCoords = err == nil
+	if err := r.readHeader(); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// readHeader reads the location and timestamp tables and marks the allocated sectors, or writes an empty header.
+func (r *Region) readHeader() error {
+	fi, err := r.f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		r.used = []bool{true, true}
+		_, err := r.f.WriteAt(make([]byte, headerSectors*SectorSize), 0)
+		return err
+	}
+	if fi.Size() < headerSectors*SectorSize {
+		return errorCorrupt
+	}
+
+	hdr := make([]byte, headerSectors*SectorSize)
+	if _, err := r.f.ReadAt(hdr, 0); err != nil {
+		return err
+	}
+	r.used = make([]bool, (fi.Size()+SectorSize-1)/SectorSize)
+	r.used[0], r.used[1] = true, true
+	for i := range r.locations {
+		r.locations[i] = binary.BigEndian.Uint32(hdr[4*i:])
+		r.timestamps[i] = binary.BigEndian.Uint32(hdr[SectorSize+4*i:])
+
+		offset, count := r.location(i)
+		if offset < headerSectors || offset+count > len(r.used) {
+			// invalid entries are treated as missing chunks, like Minecraft does
+			r.locations[i] = 0
+			continue
+		}
+		r.mark(offset, count, true)
+	}
+	return nil
+}
+
+// SetCompression sets the compression type of chunks written by WriteChunk, which defaults to CompressionZlib.
+func (r *Region) SetCompression(c Compression) {
+	r.compression = c
+}
+
+// Exists reports whether the chunk at the given chunk coordinates is present.
+// Coordinates may be absolute or relative to the region, as only their lowest 5 bits are used.
+func (r *Region) Exists(x, z int) bool {
+	return r.locations[index(x, z)] != 0
+}
+
+// Timestamp returns the time the chunk at the given coordinates was last written, or the zero time if it is absent.
+func (r *Region) Timestamp(x, z int) time.Time {
+	i := index(x, z)
+	if r.locations[i] == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(r.timestamps[i]), 0)
+}
+
+// ReadChunk returns the decompressed data of the chunk at the given chunk coordinates, which usually is NBT data.
+// Coordinates may be absolute or relative to the region, as only their lowest 5 bits are used.
+// Errors with ErrChunkNotFound if the chunk is not present.
+func (r *Region) ReadChunk(x, z int) ([]byte, error) {
+	i := index(x, z)
+	typ, comp, err := r.readRaw(i)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(r.dc, typ, comp)
+}
+
+// WriteChunk compresses data and stores it as the chunk at the given chunk coordinates, replacing any previous chunk.
+// The chunk is rewritten in place if its sectors suffice, and otherwise moved to the first free sectors that fit it.
+// Errors if data is empty.
+func (r *Region) WriteChunk(x, z int, data []byte) error {
+	blob, err := compress(r.c, r.compression, data)
+	if err != nil {
+		return err
+	}
+	return r.writeBlob(index(x, z), blob, uint32(time.Now().Unix()))
+}
+
+// DeleteChunk removes the chunk at the given chunk coordinates and frees its sectors.
+// Deleting an absent chunk is a no-op.
+func (r *Region) DeleteChunk(x, z int) error {
+	i := index(x, z)
+	if r.locations[i] == 0 {
+		return nil
+	}
+	offset, count := r.location(i)
+	r.mark(offset, count, false)
+	if err := r.setLocation(i, 0, 0, 0); err != nil {
+		return err
+	}
+	return r.removeExternal(i)
+}
+
+// Close closes the region file and frees the Compressor and Decompressor. The Region must not be used afterwards.
+func (r *Region) Close() error {
+	err := r.f.Close()
+	r.c.Close()
+	r.dc.Close()
+	return err
+}
+
+// readRaw returns the compression type and the compressed data of chunk i, reading external chunks from their file.
+func (r *Region) readRaw(i int) (Compression, []byte, error) {
+	if r.locations[i] == 0 {
+		return 0, nil, ErrChunkNotFound
+	}
+	offset, count := r.location(i)
+
+	var hdr [chunkHeaderSize]byte
+	if _, err := r.f.ReadAt(hdr[:], int64(offset)*SectorSize); err != nil {
+		return 0, nil, corruptOnEOF(err)
+	}
+	length := int(binary.BigEndian.Uint32(hdr[:4]))
+	typ := hdr[4]
+	if length < 1 || 4+length > count*SectorSize {
+		return 0, nil, errorCorrupt
+	}
+
+	if typ&externalFlag != 0 {
+		comp, err := ioutil.ReadFile(r.externalName(i))
+		return Compression(typ &^ externalFlag), comp, err
+	}
+	comp := make([]byte, length-1)
+	if _, err := r.f.ReadAt(comp, int64(offset)*SectorSize+chunkHeaderSize); err != nil {
+		return 0, nil, corruptOnEOF(err)
+	}
+	return Compression(typ), comp, nil
+}
+
+// writeBlob stores the chunk blob, consisting of the chunk header and the compressed data, as chunk i.
+// Blobs exceeding maxSectorCount sectors are written to an external file.
+func (r *Region) writeBlob(i int, blob []byte, timestamp uint32) error {
+	count := (len(blob) + SectorSize - 1) / SectorSize
+	if count > maxSectorCount {
+		if !r.hasCoords {
+			return errorExternal
+		}
+		if err := ioutil.WriteFile(r.externalName(i), blob[chunkHeaderSize:], 0644); err != nil {
+			return err
+		}
+		blob = []byte{0, 0, 0, 1, blob[4] | externalFlag}
+		count = 1
+	} else if err := r.removeExternal(i); err != nil {
+		return err
+	}
+
+	offset, oldCount := r.location(i)
+	if r.locations[i] != 0 && count <= oldCount {
+		r.mark(offset+count, oldCount-count, false)
+	} else {
+		if r.locations[i] != 0 {
+			r.mark(offset, oldCount, false)
+		}
+		offset = r.allocate(count)
+	}
+
+	padded := make([]byte, count*SectorSize)
+	copy(padded, blob)
+	if _, err := r.f.WriteAt(padded, int64(offset)*SectorSize); err != nil {
+		return err
+	}
+	return r.setLocation(i, offset, count, timestamp)
+}
+
+// allocate marks and returns the first run of count free sectors, growing the file if there is none.
+func (r *Region) allocate(count int) int {
+	run := 0
+	for s := headerSectors; s < len(r.used); s++ {
+		if r.used[s] {
+			run = 0
+			continue
+		}
+		if run++; run == count {
+			r.mark(s-count+1, count, true)
+			return s - count + 1
+		}
+	}
+
+	// extend a free run at the end of the file, if any
+	offset := len(r.used) - run
+	for len(r.used) < offset+count {
+		r.used = append(r.used, false)
+	}
+	r.mark(offset, count, true)
+	return offset
+}
+
+func (r *Region) mark(offset, count int, used bool) {
+	for s := offset; s < offset+count; s++ {
+		r.used[s] = used
+	}
+}
+
+// location returns the offset and number of sectors of chunk i.
+func (r *Region) location(i int) (offset, count int) {
+	return int(r.locations[i] >> 8), int(r.locations[i] & 0xff)
+}
+
+// setLocation updates the location and timestamp of chunk i in memory and in the file.
+func (r *Region) setLocation(i, offset, count int, timestamp uint32) error {
+	r.locations[i] = uint32(offset)<<8 | uint32(count)
+	r.timestamps[i] = timestamp
+
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], r.locations[i])
+	if _, err := r.f.WriteAt(b[:], int64(4*i)); err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(b[:], timestamp)
+	_, err := r.f.WriteAt(b[:], int64(SectorSize+4*i))
+	return err
+}
+
+// externalName returns the name of the file an oversized chunk i is stored in.
+func (r *Region) externalName(i int) string {
+	x, z := r.rx*32+i%32, r.rz*32+i/32
+	return filepath.Join(r.dir, "c."+strconv.Itoa(x)+"."+strconv.Itoa(z)+".mcc")
+}
+
+// removeExternal removes the external file of chunk i, if it exists.
+func (r *Region) removeExternal(i int) error {
+	if !r.hasCoords {
+		return nil
+	}
+	if err := os.Remove(r.externalName(i)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// compress compresses data with c and returns it with the chunk header.
+func compress(c libdeflate.Compressor, typ Compression, data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errorEmptyChunk
+	}
+
+	var m libdeflate.Mode
+	switch typ {
+	case CompressionGzip:
+		m = libdeflate.ModeGzip
+	case CompressionZlib:
+		m = libdeflate.ModeZlib
+	case CompressionNone:
+		blob := make([]byte, chunkHeaderSize+len(data))
+		copy(blob[chunkHeaderSize:], data)
+		return finishBlob(blob, typ), nil
+	default:
+		return nil, errorCompression
+	}
+
+	blob := make([]byte, chunkHeaderSize+c.WorstCaseCompressedSize(len(data), m))
+	n, _, err := c.Compress(data, blob[chunkHeaderSize:], m)
+	if err != nil {
+		return nil, err
+	}
+	return finishBlob(blob[:chunkHeaderSize+n], typ), nil
+}
+
+// finishBlob writes the chunk header in front of the compressed data of blob.
+func finishBlob(blob []byte, typ Compression) []byte {
+	binary.BigEndian.PutUint32(blob, uint32(len(blob)-4))
+	blob[4] = byte(typ)
+	return blob
+}
+
+// decompress decompresses the data of a chunk of the given compression type with dc.
+func decompress(dc libdeflate.Decompressor, typ Compression, comp []byte) ([]byte, error) {
+	switch typ {
+	case CompressionGzip:
+		_, out, err := dc.Decompress(comp, nil, libdeflate.ModeGzip)
+		return out, err
+	case CompressionZlib:
+		_, out, err := dc.Decompress(comp, nil, libdeflate.ModeZlib)
+		return out, err
+	case CompressionNone:
+		return comp, nil
+	default:
+		return nil, errorCompression
+	}
+}
+
+// index returns the index of the chunk at the given coordinates in the header tables.
+func index(x, z int) int {
+	return x&31 + (z&31)*32
+}
+
+// corruptOnEOF reports a chunk extending beyond the end of the file as corrupt.
+func corruptOnEOF(err error) error {
+	if err == io.EOF {
+		return errorCorrupt
+	}
+	return err
+}
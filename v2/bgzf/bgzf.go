@@ -0,0 +1,268 @@
+// Package bgzf reads and writes BGZF (blocked gzip) files as used by BAM, VCF and tabix, with libdeflate.
+//
+// A BGZF file is a multi-member gzip file whose members, called blocks, are at most 64 KiB large.
+// Every block stores its size in a "BC" subfield of the gzip extra field, so blocks can be located without
+// decompressing them, and the gzip trailer states the exact size of the decompressed data of the block.
+// The Writer compresses blocks in parallel and the Reader decompresses them in parallel into buffers of the exact size.
+//
+// Positions within a BGZF file are virtual offsets combining the offset of a block in the file
+// with an offset into the decompressed data of the block. A .gzi Index maps offsets of the decompressed data
+// to virtual offsets.
+package bgzf
+
+import (
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+const (
+	// MaxBlockSize is the maximum size of a compressed block, including the gzip header and trailer.
+	MaxBlockSize = 1 << 16
+	// BlockDataSize is the amount of data the Writer compresses into a block,
+	// leaving room for incompressible data to fit into MaxBlockSize.
+	BlockDataSize = 0xff00
+
+	// blockHeaderSize is the size of the gzip header of a block without further extra subfields.
+	blockHeaderSize = 18
+	trailerSize     = 8
+)
+
+// EOFMarker is the empty block terminating a BGZF file.
+var EOFMarker = []byte{
+	0x1f, 0x8b, 0x08, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x06, 0x00, 0x42, 0x43, 0x02, 0x00,
+	0x1b, 0x00, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+}
+
+// VirtualOffset is a position within a BGZF file: the offset of a block in the file in the upper 48 bits
+// and the offset into the decompressed data of the block in the lower 16 bits.
+type VirtualOffset uint64
+
+// NewVirtualOffset returns the VirtualOffset of the byte at dataOffset within the decompressed data
+// of the block starting at blockOffset.
+func NewVirtualOffset(blockOffset int64, dataOffset int) VirtualOffset {
+	return VirtualOffset(blockOffset)<<16 | VirtualOffset(dataOffset&0xffff)
+}
+
+// BlockOffset returns the offset of the block in the file.
+func (v VirtualOffset) BlockOffset() int64 {
+	return int64(v >> 16)
+}
+
+// DataOffset returns the offset into the decompressed data of the block.
+func (v VirtualOffset) DataOffset() int {
+	return int(v & 0xffff)
+}
+
+// Decompress decompresses a whole BGZF file on the given number of workers and returns the decompressed data.
+// The blocks are located by their headers first, so the decompressed data of every block is written
+// directly into its place in the result. If workers <= 0, GOMAXPROCS workers are used.
+// Errors if a block is malformed or its checksum does not match.
+func Decompress(data []byte, workers int) ([]byte, error) {
+	type block struct {
+		raw    []byte
+		offset int
+	}
+	var blocks []block
+	size := 0
+	for pos := 0; pos < len(data); {
+		n, err := blockSize(data[pos:])
+		if err != nil {
+			return nil, err
+		}
+		if pos+n > len(data) {
+			return nil, errorTruncated
+		}
+		raw := data[pos : pos+n]
+		isize, err := blockISize(raw)
+		if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block{raw: raw, offset: size})
+		size += isize
+		pos += n
+	}
+	if len(blocks) == 0 {
+		return []byte{}, nil
+	}
+
+	dcs, err := newDecompressors(workers, len(blocks))
+	if err != nil {
+		return nil, err
+	}
+	defer closeDecompressors(dcs)
+
+	out := make([]byte, size)
+	err = parallel(dcs, len(blocks), func(dc libdeflate.Decompressor, i int) error {
+		b := blocks[i]
+		isize, _ := blockISize(b.raw)
+		return decompressBlock(dc, b.raw, out[b.offset:b.offset+isize])
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// blockSize parses the header of the block at the beginning of in and returns the size of the block.
+// Errors with io.ErrUnexpectedEOF if in ends within the header.
+func blockSize(in []byte) (int, error) {
+	if len(in) < 12 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	xlen, err := extraLen(in)
+	if err != nil {
+		return 0, err
+	}
+	if len(in) < 12+xlen {
+		return 0, io.ErrUnexpectedEOF
+	}
+	h := libdeflate.GzipHeader{Extra: in[12 : 12+xlen]}
+	fields, err := h.ExtraFields()
+	if err != nil {
+		return 0, errorBlock
+	}
+	for _, f := range fields {
+		if f.ID == [2]byte{'B', 'C'} && len(f.Data) == 2 {
+			n := int(binary.LittleEndian.Uint16(f.Data)) + 1
+			if n < 12+xlen+trailerSize {
+				return 0, errorBlock
+			}
+			return n, nil
+		}
+	}
+	return 0, errorBlock
+}
+
+// extraLen verifies the fixed 12 byte header of a block and returns the length of its extra field.
+// Errors if the header is not a gzip header with an extra field that leaves room for the trailer within MaxBlockSize.
+func extraLen(hdr []byte) (int, error) {
+	if hdr[0] != 0x1f || hdr[1] != 0x8b || hdr[2] != 8 || hdr[3]&4 == 0 {
+		return 0, errorBlock
+	}
+	xlen := int(binary.LittleEndian.Uint16(hdr[10:]))
+	if 12+xlen+trailerSize > MaxBlockSize {
+		return 0, errorBlock
+	}
+	return xlen, nil
+}
+
+// readBlock reads the next block from r into buf, which is grown if needed, and returns the block.
+// Returns io.EOF if r is at its end.
+func readBlock(r io.Reader, buf []byte) ([]byte, error) {
+	if cap(buf) < MaxBlockSize {
+		buf = make([]byte, MaxBlockSize)
+	}
+	buf = buf[:12]
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			err = errorTruncated
+		}
+		return nil, err
+	}
+	xlen, err := extraLen(buf)
+	if err != nil {
+		return nil, err
+	}
+	buf = buf[:12+xlen]
+	if _, err := io.ReadFull(r, buf[12:]); err != nil {
+		return nil, errorTruncated
+	}
+
+	n, err := blockSize(buf)
+	if err != nil {
+		return nil, err
+	}
+	pos := len(buf)
+	buf = buf[:n]
+	if _, err := io.ReadFull(r, buf[pos:]); err != nil {
+		return nil, errorTruncated
+	}
+	return buf, nil
+}
+
+// blockISize returns the size of the decompressed data of a block as stated in its trailer.
+// Errors if the size exceeds the maximum size of a block.
+func blockISize(block []byte) (int, error) {
+	isize := binary.LittleEndian.Uint32(block[len(block)-4:])
+	if isize > MaxBlockSize {
+		return 0, errorBlock
+	}
+	return int(isize), nil
+}
+
+// decompressBlock decompresses a block into out, which has the size stated in the trailer of the block.
+// The header and trailer are verified.
+func decompressBlock(dc libdeflate.Decompressor, block, out []byte) error {
+	n, _, _, _, err := dc.DecompressGzipWithHeader(block, out)
+	if err != nil {
+		return err
+	}
+	if n != len(block) {
+		return errorBlock
+	}
+	return nil
+}
+
+// newDecompressors returns a Decompressor for each of the given number of workers, but at most n.
+// If workers <= 0, GOMAXPROCS workers are used.
+func newDecompressors(workers, n int) ([]libdeflate.Decompressor, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	if workers > n {
+		workers = n
+	}
+	dcs := make([]libdeflate.Decompressor, 0, workers)
+	for i := 0; i < workers; i++ {
+		dc, err := libdeflate.NewDecompressor()
+		if err != nil {
+			closeDecompressors(dcs)
+			return nil, err
+		}
+		dcs = append(dcs, dc)
+	}
+	return dcs, nil
+}
+
+func closeDecompressors(dcs []libdeflate.Decompressor) {
+	for _, dc := range dcs {
+		dc.Close()
+	}
+}
+
+// parallel runs job for the indices 0 to n-1 on one worker per Decompressor and returns the first error.
+func parallel(dcs []libdeflate.Decompressor, n int, job func(dc libdeflate.Decompressor, i int) error) error {
+	jobs := make(chan int, n)
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for _, dc := range dcs {
+		wg.Add(1)
+		go func(dc libdeflate.Decompressor) {
+			defer wg.Done()
+			for i := range jobs {
+				if err := job(dc, i); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+			}
+		}(dc)
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,353 @@
+package bgzf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestWriteRead(t *testing.T) {
+	data := testData(5*BlockDataSize + 1234)
+	for _, workers := range []int{1, 3, 0} {
+		file := compress(data, workers, t)
+		if !bytes.HasSuffix(file, EOFMarker) {
+			t.Error("missing EOF marker")
+		}
+
+		r, err := NewReader(bytes.NewReader(file), workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Close()
+		slicesEqual(data, out, t)
+
+		out, err = Decompress(file, workers)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(data, out, t)
+	}
+}
+
+func TestBlockLayout(t *testing.T) {
+	// incompressible data must still fit into blocks
+	data := make([]byte, 3*BlockDataSize)
+	rand.New(rand.NewSource(1)).Read(data)
+	file := compress(data, 2, t)
+
+	blocks := 0
+	for pos := 0; pos < len(file); blocks++ {
+		if !bytes.Equal(file[pos:pos+4], []byte{0x1f, 0x8b, 0x08, 0x04}) || string(file[pos+12:pos+14]) != "BC" {
+			t.Fatalf("invalid block header at %d", pos)
+		}
+		size := int(binary.LittleEndian.Uint16(file[pos+16:])) + 1
+		if size > MaxBlockSize {
+			t.Fatalf("block of %d bytes", size)
+		}
+		pos += size
+	}
+	if blocks != 4 {
+		t.Errorf("%d blocks, expected 4", blocks)
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	file := compress(nil, 2, t)
+	slicesEqual(EOFMarker, file, t)
+
+	out, err := Decompress(file, 0)
+	if err != nil || len(out) != 0 {
+		t.Errorf("decompressed %d bytes: %v", len(out), err)
+	}
+	r, _ := NewReader(bytes.NewReader(file), 0)
+	defer r.Close()
+	if n, err := r.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("expected EOF, got %d, %v", n, err)
+	}
+}
+
+func TestWriterTellSeek(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf, libdeflate.DefaultCompressionLevel, 4)
+	var offsets []VirtualOffset
+	var records [][]byte
+	for i := 0; i < 3000; i++ {
+		if i%500 == 0 {
+			v, err := w.Tell()
+			if err != nil {
+				t.Fatal(err)
+			}
+			offsets = append(offsets, v)
+			records = append(records, []byte(record(i)))
+		}
+		w.Write([]byte(record(i)))
+		if i == 1000 {
+			w.Flush()
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(shortString); err != errorWriterClosed {
+		t.Errorf("expected errorWriterClosed, got %v", err)
+	}
+
+	r, _ := NewReader(bytes.NewReader(buf.Bytes()), 2)
+	defer r.Close()
+	for i := len(offsets) - 1; i >= 0; i-- {
+		if err := r.Seek(offsets[i]); err != nil {
+			t.Fatal(err)
+		}
+		if r.Tell() != offsets[i] && offsets[i].DataOffset() != 0 {
+			t.Errorf("Tell returned %x after seeking to %x", r.Tell(), offsets[i])
+		}
+		out := make([]byte, len(records[i]))
+		if _, err := io.ReadFull(r, out); err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(records[i], out, t)
+	}
+
+	// Tell reports offsets that Seek accepts while reading
+	r.Seek(0)
+	io.CopyN(ioutil.Discard, r, BlockDataSize)
+	v := r.Tell()
+	next := make([]byte, 100)
+	io.ReadFull(r, next)
+	r.Seek(v)
+	out := make([]byte, 100)
+	io.ReadFull(r, out)
+	slicesEqual(next, out, t)
+
+	if err := r.Seek(NewVirtualOffset(0, 0xffff)); err != errorInvalidOffset {
+		t.Errorf("expected errorInvalidOffset, got %v", err)
+	}
+	if err := r.Seek(NewVirtualOffset(3, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Read(out); err != errorBlock {
+		t.Errorf("expected errorBlock, got %v", err)
+	}
+
+	unseekable, _ := NewReader(bytes.NewBuffer(buf.Bytes()), 1)
+	defer unseekable.Close()
+	if err := unseekable.Seek(0); err != errorNotSeekable {
+		t.Errorf("expected errorNotSeekable, got %v", err)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	data := testData(7*BlockDataSize + 99)
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf, libdeflate.DefaultCompressionLevel, 3)
+	w.Write(data)
+	w.Close()
+	file := buf.Bytes()
+
+	idx, err := BuildIndex(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Entries) != 8 {
+		t.Fatalf("%d index entries", len(idx.Entries))
+	}
+	if written := w.Index(); len(written.Entries) != len(idx.Entries) || written.Entries[7] != idx.Entries[7] {
+		t.Error("index of the writer differs")
+	}
+
+	// .gzi round trip, which omits the first block
+	gzi := &bytes.Buffer{}
+	if n, err := idx.WriteTo(gzi); err != nil || n != 8+7*16 {
+		t.Fatalf("wrote %d bytes: %v", n, err)
+	}
+	if binary.LittleEndian.Uint64(gzi.Bytes()) != 7 ||
+		binary.LittleEndian.Uint64(gzi.Bytes()[16:]) != uint64(BlockDataSize) {
+		t.Error("invalid .gzi layout")
+	}
+	loaded, err := ReadIndex(gzi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.Entries) != len(idx.Entries) || loaded.Entries[3] != idx.Entries[3] {
+		t.Error("loaded index differs")
+	}
+
+	r, _ := NewReader(bytes.NewReader(file), 2)
+	defer r.Close()
+	for _, offset := range []int64{0, 1, BlockDataSize - 1, BlockDataSize, 3*BlockDataSize + 77, int64(len(data)) - 10} {
+		v, err := loaded.VirtualOffset(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := r.Seek(v); err != nil {
+			t.Fatal(err)
+		}
+		out := make([]byte, 10)
+		if _, err := io.ReadFull(r, out); err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(data[offset:offset+10], out, t)
+	}
+	if _, err := loaded.VirtualOffset(-1); err != errorInvalidOffset {
+		t.Errorf("expected errorInvalidOffset, got %v", err)
+	}
+	if _, err := loaded.VirtualOffset(int64(len(data)) + 1<<16); err != errorInvalidOffset {
+		t.Errorf("expected errorInvalidOffset, got %v", err)
+	}
+
+	if _, err := ReadIndex(bytes.NewReader([]byte{2, 0, 0, 0, 0, 0, 0, 0, 1})); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	file := compress(testData(2*BlockDataSize), 1, t)
+
+	// a plain gzip member lacks the BC subfield
+	if _, err := Decompress(gzipCompress(shortString), 1); err != errorBlock {
+		t.Errorf("expected errorBlock, got %v", err)
+	}
+	if _, err := Decompress(file[:len(file)-40], 1); err != errorTruncated {
+		t.Errorf("expected errorTruncated, got %v", err)
+	}
+	r, _ := NewReader(bytes.NewReader(file[:len(file)-40]), 1)
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err != errorTruncated {
+		t.Errorf("expected errorTruncated, got %v", err)
+	}
+	if _, err := BuildIndex(bytes.NewReader(file[:100])); err != errorTruncated {
+		t.Errorf("expected errorTruncated, got %v", err)
+	}
+
+	// an extra field longer than a block is rejected before it is read
+	huge := append([]byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff, 0xff, 0xff}, make([]byte, 100)...)
+	if _, err := BuildIndex(bytes.NewReader(huge)); err != errorBlock {
+		t.Errorf("expected errorBlock, got %v", err)
+	}
+	r, _ = NewReader(bytes.NewReader(huge), 1)
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err != errorBlock {
+		t.Errorf("expected errorBlock, got %v", err)
+	}
+	if _, err := Decompress(huge, 1); err != errorBlock {
+		t.Errorf("expected errorBlock, got %v", err)
+	}
+
+	// a flipped bit in the data fails the checksum
+	corrupt := append([]byte{}, file...)
+	corrupt[len(corrupt)-len(EOFMarker)-12] ^= 1
+	if _, err := Decompress(corrupt, 2); err == nil {
+		t.Error("expected error for corrupt block")
+	}
+}
+
+func TestInvalidLevel(t *testing.T) {
+	if _, err := NewWriter(ioutil.Discard, 13, 2); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestStdGzipInterop(t *testing.T) {
+	data := testData(4*BlockDataSize + 5)
+	file := compress(data, 0, t)
+
+	// BGZF files are multi-member gzip files
+	zr, err := gzip.NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(data, out, t)
+
+	// blocks written by other implementations, with the BC subfield among other subfields
+	hdr := libdeflate.GzipHeader{OS: libdeflate.GzipOSUnix}
+	hdr.AddExtraField([2]byte{'X', 'Y'}, []byte("other"))
+	hdr.AddExtraField([2]byte{'B', 'C'}, []byte{0, 0})
+	c, _ := libdeflate.NewCompressor()
+	defer c.Close()
+	_, block, err := c.CompressGzipWithHeader(shortString, nil, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(block[12+9+4:], uint16(len(block)-1))
+	foreign := append(append(append([]byte{}, block...), block...), EOFMarker...)
+
+	out, err = Decompress(foreign, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(append(append([]byte{}, shortString...), shortString...), out, t)
+}
+
+func compress(data []byte, workers int, t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, libdeflate.DefaultCompressionLevel, workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// odd write sizes to cross block boundaries
+	for len(data) > 0 {
+		n := 10007
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testData(n int) []byte {
+	buf := &bytes.Buffer{}
+	for i := 0; buf.Len() < n; i++ {
+		buf.WriteString(record(i))
+	}
+	return buf.Bytes()[:n]
+}
+
+func record(i int) string {
+	return "chr1\t" + string(rune('0'+i%10)) + "\tread" + string(rune('a'+i%26)) + "\tACGTTGCA\t" + string(shortString[:i%len(shortString)])
+}
+
+func gzipCompress(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,14 @@
+package bgzf
+
+import "errors"
+
+var (
+	errorBlock         = errors.New("libdeflate: bgzf: invalid block header")
+	errorTruncated     = errors.New("libdeflate: bgzf: truncated block")
+	errorBlockTooLarge = errors.New("libdeflate: bgzf: compressed block exceeds 64 KiB")
+	errorNotSeekable   = errors.New("libdeflate: bgzf: underlying reader is not seekable")
+	errorInvalidOffset = errors.New("libdeflate: bgzf: virtual offset beyond block data")
+	errorIndex         = errors.New("libdeflate: bgzf: malformed index")
+	errorWriterClosed  = errors.New("libdeflate: bgzf: writer already closed")
+	errorReaderClosed  = errors.New("libdeflate: bgzf: reader already closed")
+)
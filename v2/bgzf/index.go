@@ -0,0 +1,125 @@
+package bgzf
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// IndexEntry is the start of a block: its offset in the file and the offset of its data in the decompressed data.
+type IndexEntry struct {
+	CompressedOffset   int64
+	UncompressedOffset int64
+}
+
+// Index maps offsets of the decompressed data of a BGZF file to VirtualOffsets, like the .gzi files of
+// bgzip and samtools. It holds an entry for every block holding data, in order.
+type Index struct {
+	Entries []IndexEntry
+}
+
+// BuildIndex builds the Index of the BGZF file read from r. The blocks are not decompressed,
+// as the size of their data is stated in their trailer.
+func BuildIndex(r io.Reader) (*Index, error) {
+	idx := &Index{}
+	var buf []byte
+	var offset, uoffset int64
+	for {
+		raw, err := readBlock(r, buf)
+		if err == io.EOF {
+			return idx, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		isize, err := blockISize(raw)
+		if err != nil {
+			return nil, err
+		}
+		if isize > 0 {
+			idx.add(offset, uoffset)
+		}
+		offset += int64(len(raw))
+		uoffset += int64(isize)
+		buf = raw
+	}
+}
+
+// ReadIndex reads an Index in the .gzi format: the number of entries followed by the entries as pairs
+// of compressed and uncompressed offset, all little-endian uint64. The first block is implied.
+func ReadIndex(r io.Reader) (*Index, error) {
+	var n uint64
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return nil, err
+	}
+	if n > 1<<40 {
+		return nil, errorIndex
+	}
+
+	idx := &Index{Entries: []IndexEntry{{}}}
+	var pair [16]byte
+	for i := uint64(0); i < n; i++ {
+		if _, err := io.ReadFull(r, pair[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		e := IndexEntry{
+			CompressedOffset:   int64(binary.LittleEndian.Uint64(pair[:])),
+			UncompressedOffset: int64(binary.LittleEndian.Uint64(pair[8:])),
+		}
+		last := idx.Entries[len(idx.Entries)-1]
+		if e.CompressedOffset <= last.CompressedOffset || e.UncompressedOffset <= last.UncompressedOffset {
+			return nil, errorIndex
+		}
+		idx.Entries = append(idx.Entries, e)
+	}
+	return idx, nil
+}
+
+// WriteTo writes the Index to w in the .gzi format, see ReadIndex.
+func (idx *Index) WriteTo(w io.Writer) (int64, error) {
+	entries := idx.Entries
+	if len(entries) > 0 && entries[0] == (IndexEntry{}) {
+		entries = entries[1:]
+	}
+
+	buf := make([]byte, 8+16*len(entries))
+	binary.LittleEndian.PutUint64(buf, uint64(len(entries)))
+	for i, e := range entries {
+		binary.LittleEndian.PutUint64(buf[8+16*i:], uint64(e.CompressedOffset))
+		binary.LittleEndian.PutUint64(buf[16+16*i:], uint64(e.UncompressedOffset))
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// VirtualOffset returns the VirtualOffset of the byte at offset within the decompressed data, to Seek a Reader to.
+// Errors if offset is negative or lies beyond the data of the last block.
+func (idx *Index) VirtualOffset(offset int64) (VirtualOffset, error) {
+	if offset < 0 {
+		return 0, errorInvalidOffset
+	}
+	// the last entry starting at or before offset
+	i := sort.Search(len(idx.Entries), func(i int) bool {
+		return idx.Entries[i].UncompressedOffset > offset
+	}) - 1
+	if i < 0 {
+		if offset == 0 {
+			return 0, nil
+		}
+		return 0, errorInvalidOffset
+	}
+
+	e := idx.Entries[i]
+	within := offset - e.UncompressedOffset
+	if within > 0xffff {
+		return 0, errorInvalidOffset
+	}
+	return NewVirtualOffset(e.CompressedOffset, int(within)), nil
+}
+
+func (idx *Index) add(offset, uoffset int64) {
+	idx.Entries = append(idx.Entries, IndexEntry{CompressedOffset: offset, UncompressedOffset: uoffset})
+}
@@ -0,0 +1,180 @@
+package bgzf
+
+import (
+	"io"
+	"math"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// Reader decompresses a BGZF file. It reads ahead one block per worker and decompresses these blocks in parallel,
+// each into a buffer of the size stated in the gzip trailer of the block.
+// Always Close() the Reader to free the c memory of its Decompressors.
+type Reader struct {
+	r   io.Reader
+	dcs []libdeflate.Decompressor
+
+	raw   [][]byte // one buffer per worker for the compressed blocks
+	data  [][]byte // one buffer per worker for the decompressed blocks
+	queue []block  // decompressed blocks read ahead
+
+	cur    block
+	pos    int   // position within cur.data
+	offset int64 // offset of the next block to read from r
+
+	closed bool
+	err    error
+}
+
+// block is a decompressed block starting at offset and ending before end in the file.
+type block struct {
+	offset, end int64
+	data        []byte
+}
+
+// NewReader returns a new Reader decompressing the BGZF file read from r on the given number of workers.
+// If workers <= 0, GOMAXPROCS workers are used. Seek requires r to implement io.Seeker.
+// Errors if out of memory.
+func NewReader(r io.Reader, workers int) (*Reader, error) {
+	dcs, err := newDecompressors(workers, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+	return &Reader{
+		r:    r,
+		dcs:  dcs,
+		raw:  make([][]byte, len(dcs)),
+		data: make([][]byte, len(dcs)),
+	}, nil
+}
+
+// Read reads decompressed data into p and returns the number of bytes read.
+// At the end of the file, Read returns 0, io.EOF.
+func (br *Reader) Read(p []byte) (int, error) {
+	if br.closed {
+		return 0, errorReaderClosed
+	}
+	for br.pos == len(br.cur.data) {
+		if err := br.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, br.cur.data[br.pos:])
+	br.pos += n
+	return n, nil
+}
+
+// Tell returns the VirtualOffset of the next byte Read returns.
+func (br *Reader) Tell() VirtualOffset {
+	if br.pos == len(br.cur.data) && br.pos > 0 {
+		// the data offset can't represent the end of a block of 64 KiB
+		return NewVirtualOffset(br.cur.end, 0)
+	}
+	return NewVirtualOffset(br.cur.offset, br.pos)
+}
+
+// Seek moves the Reader to the VirtualOffset v, e.g. taken from a BAM or tabix index or an Index.
+// Errors if the underlying reader does not implement io.Seeker or if v points beyond the data of its block.
+func (br *Reader) Seek(v VirtualOffset) error {
+	if br.closed {
+		return errorReaderClosed
+	}
+	s, ok := br.r.(io.Seeker)
+	if !ok {
+		return errorNotSeekable
+	}
+	if _, err := s.Seek(v.BlockOffset(), io.SeekStart); err != nil {
+		return err
+	}
+
+	br.offset = v.BlockOffset()
+	br.cur = block{offset: br.offset, end: br.offset}
+	br.pos = 0
+	br.queue = br.queue[:0]
+	br.err = nil
+	if v.DataOffset() == 0 {
+		return nil
+	}
+
+	if err := br.next(); err != nil {
+		if err == io.EOF {
+			err = errorInvalidOffset
+		}
+		return err
+	}
+	if v.DataOffset() > len(br.cur.data) {
+		return errorInvalidOffset
+	}
+	br.pos = v.DataOffset()
+	return nil
+}
+
+// Close closes the Reader and frees its Decompressors. It does not close the underlying reader.
+func (br *Reader) Close() error {
+	if br.closed {
+		return nil
+	}
+	closeDecompressors(br.dcs)
+	br.closed = true
+	return nil
+}
+
+// next makes the next block the current one, reading ahead if no more blocks are queued.
+func (br *Reader) next() error {
+	if len(br.queue) == 0 {
+		if br.err != nil {
+			return br.err
+		}
+		if br.err = br.fill(); br.err != nil && len(br.queue) == 0 {
+			return br.err
+		}
+	}
+	br.cur = br.queue[0]
+	br.queue = br.queue[1:]
+	br.pos = 0
+	return nil
+}
+
+// fill reads one block per worker and decompresses them in parallel.
+// The blocks read before an error are queued.
+func (br *Reader) fill() error {
+	var readErr error
+	n := 0
+	for ; n < len(br.dcs); n++ {
+		raw, err := readBlock(br.r, br.raw[n])
+		if err != nil {
+			readErr = err
+			break
+		}
+		br.raw[n] = raw
+	}
+
+	blocks := make([]block, n)
+	err := parallel(br.dcs[:n], n, func(dc libdeflate.Decompressor, i int) error {
+		raw := br.raw[i]
+		isize, err := blockISize(raw)
+		if err != nil {
+			return err
+		}
+		if cap(br.data[i]) < isize {
+			br.data[i] = make([]byte, MaxBlockSize)
+		}
+		data := br.data[i][:isize]
+		if err := decompressBlock(dc, raw, data); err != nil {
+			return err
+		}
+		blocks[i].data = data
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for i := range blocks {
+		blocks[i].offset = br.offset
+		br.offset += int64(len(br.raw[i]))
+		blocks[i].end = br.offset
+	}
+	br.queue = append(br.queue[:0], blocks...)
+	return readErr
+}
@@ -0,0 +1,211 @@
+package bgzf
+
+import (
+	"encoding/binary"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// bcField is the extra field of a block header. The block size is filled in after compressing.
+var bcField = []byte{'B', 'C', 2, 0, 0, 0}
+
+// Writer compresses data into a BGZF file. The data is split into blocks of BlockDataSize bytes,
+// and one block per worker is compressed in parallel.
+// Always Close() the Writer to write the remaining data and the EOF marker and to free the c memory of its Compressors.
+type Writer struct {
+	w     io.Writer
+	comps []libdeflate.Compressor
+	buf   []byte // data not compressed yet, up to one block per worker
+	out   [][]byte
+
+	offset  int64 // offset of the next block in the file
+	uoffset int64 // offset of the data of the next block in the decompressed data
+	index   Index
+
+	closed bool
+	err    error
+}
+
+// NewWriter returns a new Writer compressing data at the given level on the given number of workers, writing to w.
+// If workers <= 0, GOMAXPROCS workers are used.
+// Errors if out of memory or if an invalid compression level was passed.
+func NewWriter(w io.Writer, level, workers int) (*Writer, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	comps := make([]libdeflate.Compressor, 0, workers)
+	for i := 0; i < workers; i++ {
+		c, err := libdeflate.NewCompressorLevel(level)
+		if err != nil {
+			for _, c := range comps {
+				c.Close()
+			}
+			return nil, err
+		}
+		comps = append(comps, c)
+	}
+	return &Writer{
+		w:     w,
+		comps: comps,
+		buf:   make([]byte, 0, workers*BlockDataSize),
+		out:   make([][]byte, workers),
+	}, nil
+}
+
+// Write buffers p and compresses it once there is a full block for every worker.
+func (bw *Writer) Write(p []byte) (int, error) {
+	if bw.closed {
+		return 0, errorWriterClosed
+	}
+	if bw.err != nil {
+		return 0, bw.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := cap(bw.buf) - len(bw.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		bw.buf = append(bw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(bw.buf) == cap(bw.buf) {
+			if err := bw.writeBlocks(len(bw.buf)); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush compresses and writes all buffered data, ending the current block even if it is not full.
+func (bw *Writer) Flush() error {
+	if bw.closed {
+		return errorWriterClosed
+	}
+	if bw.err != nil {
+		return bw.err
+	}
+	return bw.writeBlocks(len(bw.buf))
+}
+
+// Tell returns the VirtualOffset of the next byte written, e.g. to index the records of a file.
+// To know the offset of the block, the buffered full blocks are written first,
+// so calling Tell often reduces the parallelism of the Writer.
+func (bw *Writer) Tell() (VirtualOffset, error) {
+	if bw.closed {
+		return 0, errorWriterClosed
+	}
+	if bw.err != nil {
+		return 0, bw.err
+	}
+	if len(bw.buf) >= BlockDataSize {
+		if err := bw.writeBlocks(len(bw.buf) / BlockDataSize * BlockDataSize); err != nil {
+			return 0, err
+		}
+	}
+	return NewVirtualOffset(bw.offset, len(bw.buf)), nil
+}
+
+// Index returns the Index of the blocks written so far, which is complete after Close.
+func (bw *Writer) Index() *Index {
+	return &Index{Entries: append([]IndexEntry{}, bw.index.Entries...)}
+}
+
+// Close writes the remaining data and the EOF marker and frees the Compressors.
+// It does not close the underlying writer. Returns the first error that occurred while writing.
+func (bw *Writer) Close() error {
+	if bw.closed {
+		return bw.err
+	}
+	if bw.err == nil {
+		if bw.writeBlocks(len(bw.buf)) == nil {
+			if _, err := bw.w.Write(EOFMarker); err != nil {
+				bw.err = err
+			}
+		}
+	}
+	for _, c := range bw.comps {
+		c.Close()
+	}
+	bw.closed = true
+	return bw.err
+}
+
+// writeBlocks compresses the first n buffered bytes into blocks in parallel and writes them in order.
+func (bw *Writer) writeBlocks(n int) error {
+	blocks := (n + BlockDataSize - 1) / BlockDataSize
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := 0; i < blocks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			end := (i + 1) * BlockDataSize
+			if end > n {
+				end = n
+			}
+			out, err := compressBlock(bw.comps[i], bw.buf[i*BlockDataSize:end], bw.out[i])
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			bw.out[i] = out
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		bw.err = firstErr
+		return firstErr
+	}
+
+	for i := 0; i < blocks; i++ {
+		if _, err := bw.w.Write(bw.out[i]); err != nil {
+			bw.err = err
+			return err
+		}
+		bw.index.add(bw.offset, bw.uoffset)
+		bw.offset += int64(len(bw.out[i]))
+		size := n - i*BlockDataSize
+		if size > BlockDataSize {
+			size = BlockDataSize
+		}
+		bw.uoffset += int64(size)
+	}
+
+	bw.buf = bw.buf[:copy(bw.buf, bw.buf[n:])]
+	return nil
+}
+
+// compressBlock compresses data into a block, reusing the buffer out if it is large enough.
+func compressBlock(c libdeflate.Compressor, data, out []byte) ([]byte, error) {
+	size := blockHeaderSize + c.WorstCaseCompressedSize(len(data), libdeflate.ModeDEFLATE) + trailerSize
+	if cap(out) < size {
+		out = make([]byte, size)
+	}
+	n, out, err := c.CompressGzipWithHeader(data, out[:size], libdeflate.GzipHeader{
+		Extra: bcField,
+		OS:    libdeflate.GzipOSUnknown,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxBlockSize {
+		return nil, errorBlockTooLarge
+	}
+	binary.LittleEndian.PutUint16(out[blockHeaderSize-2:], uint16(n-1))
+	return out, nil
+}
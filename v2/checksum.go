@@ -21,3 +21,87 @@ A new crc32-checksum requires 0 as initial value. This value is also returned if
 func Crc32(crc32 uint32, in []byte) uint32 {
 	return native.Crc32(crc32, in)
 }
+
+/*
+Crc32Combine returns the crc32 checksum of the concatenation of two byte sequences,
+given the checksum crc1 of the first sequence and the checksum crc2 and the length len2 of the second one.
+The sequences themselves are not needed, so checksums of separately compressed parts can be merged cheaply.
+*/
+func Crc32Combine(crc1, crc2 uint32, len2 int64) uint32 {
+	return multModP(x2nModP(len2, 3), crc1) ^ crc2
+}
+
+/*
+Adler32Combine returns the adler32 checksum of the concatenation of two byte sequences,
+given the checksum adler1 of the first sequence and the checksum adler2 and the length len2 of the second one.
+*/
+func Adler32Combine(adler1, adler2 uint32, len2 int64) uint32 {
+	const base = 65521
+	rem := uint32(len2 % base)
+	sum1 := adler1 & 0xffff
+	sum2 := rem * sum1 % base
+	sum1 += adler2&0xffff + base - 1
+	sum2 += adler1>>16 + adler2>>16 + base - rem
+	if sum1 >= base {
+		sum1 -= base
+	}
+	if sum1 >= base {
+		sum1 -= base
+	}
+	if sum2 >= base<<1 {
+		sum2 -= base << 1
+	}
+	if sum2 >= base {
+		sum2 -= base
+	}
+	return sum2<<16 | sum1
+}
+
+// crc32Poly is the reflected CRC-32 polynomial. The polynomials below are reflected as well:
+// the coefficient of x^0 is the most significant bit.
+const crc32Poly = 0xedb88320
+
+// x2nTable holds x^(2^n) modulo the CRC-32 polynomial for n = 0..31.
+var x2nTable = func() (table [32]uint32) {
+	p := uint32(1) << 30 // x^1
+	table[0] = p
+	for n := 1; n < len(table); n++ {
+		p = multModP(p, p)
+		table[n] = p
+	}
+	return table
+}()
+
+// multModP returns a*b modulo the CRC-32 polynomial, as in zlib's crc32_combine.
+func multModP(a, b uint32) uint32 {
+	m := uint32(1) << 31
+	p := uint32(0)
+	for {
+		if a&m != 0 {
+			p ^= b
+			if a&(m-1) == 0 {
+				break
+			}
+		}
+		m >>= 1
+		if b&1 != 0 {
+			b = b>>1 ^ crc32Poly
+		} else {
+			b >>= 1
+		}
+	}
+	return p
+}
+
+// x2nModP returns x^(n*2^k) modulo the CRC-32 polynomial.
+func x2nModP(n int64, k uint) uint32 {
+	p := uint32(1) << 31 // x^0
+	for n != 0 {
+		if n&1 != 0 {
+			p = multModP(x2nTable[k&31], p)
+		}
+		n >>= 1
+		k++
+	}
+	return p
+}
@@ -0,0 +1,27 @@
+package libdeflate
+
+import (
+	"hash/adler32"
+	"hash/crc32"
+	"testing"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestChecksumCombine(t *testing.T) {
+	data := rsyncTestData(200000)
+	for _, split := range []int{0, 1, 4096, 65521, 100000, len(data)} {
+		a, b := data[:split], data[split:]
+
+		crc := Crc32Combine(crc32.ChecksumIEEE(a), crc32.ChecksumIEEE(b), int64(len(b)))
+		if expected := crc32.ChecksumIEEE(data); crc != expected {
+			t.Errorf("split %d: crc32 %08x, expected %08x", split, crc, expected)
+		}
+		adler := Adler32Combine(adler32.Checksum(a), adler32.Checksum(b), int64(len(b)))
+		if expected := adler32.Checksum(data); adler != expected {
+			t.Errorf("split %d: adler32 %08x, expected %08x", split, adler, expected)
+		}
+	}
+}
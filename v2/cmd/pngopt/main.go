@@ -0,0 +1,109 @@
+// Command pngopt losslessly reduces the size of PNG files by recompressing their image data with libdeflate
+// at compression levels beyond those of zlib, trying several filter strategies.
+//
+// Usage:
+//
+//	pngopt [flags] file...
+//
+// Files are overwritten only if the optimized file is smaller, unless -n is given.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/4kills/go-libdeflate/v2/png"
+)
+
+func main() {
+	levels := flag.String("levels", "10,11,12", "comma-separated compression levels to try")
+	strip := flag.Bool("strip", false, "remove ancillary chunks except for tRNS")
+	keep := flag.String("keep", "", "comma-separated ancillary chunk types to keep with -strip, e.g. iCCP,pHYs")
+	workers := flag.Int("workers", 0, "number of concurrent compressions (default GOMAXPROCS)")
+	dryRun := flag.Bool("n", false, "report the savings without writing any file")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: pngopt [flags] file...\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	opts := png.OptimizeOptions{Strip: *strip, Workers: *workers}
+	for _, s := range strings.Split(*levels, ",") {
+		level, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "pngopt: invalid level %q\n", s)
+			os.Exit(2)
+		}
+		opts.Levels = append(opts.Levels, level)
+	}
+	if *keep != "" {
+		opts.Keep = strings.Split(*keep, ",")
+	}
+
+	failed := false
+	for _, name := range flag.Args() {
+		if err := optimize(name, opts, *dryRun); err != nil {
+			fmt.Fprintf(os.Stderr, "pngopt: %s: %v\n", name, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func optimize(name string, opts png.OptimizeOptions, dryRun bool) error {
+	in, err := ioutil.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	out, err := png.OptimizePNG(bytes.NewReader(in), opts)
+	if err != nil {
+		return err
+	}
+
+	if len(out) >= len(in) {
+		fmt.Printf("%s: %d bytes, already optimal\n", name, len(in))
+		return nil
+	}
+	fmt.Printf("%s: %d -> %d bytes (%.1f%%)\n", name, len(in), len(out), 100*float64(len(in)-len(out))/float64(len(in)))
+	if dryRun {
+		return nil
+	}
+	return replaceFile(name, out)
+}
+
+// replaceFile atomically replaces the content of the file name by writing to a temporary file and renaming it.
+func replaceFile(name string, data []byte) error {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".pngopt-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), fi.Mode()); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
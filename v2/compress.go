@@ -4,8 +4,8 @@ package libdeflate
 // of bytes written to out, out (sliced to written) or an error if the out buffer was too short.
 // If you pass nil for out, this function will allocate a fitting buffer and return it (not preferred though).
 //
-// IF YOU WANT TO COMPRESS MORE THAN ONCE, PLEASE REFER TO NewCompressor(),
-// as this function creates a new Compressor (alloc 32KiB) which is then closed at the end of the function.
+// This function takes a Compressor from an internal CompressorPool shared by all callers, so repeated calls
+// don't allocate a new Compressor every time. For full control, refer to NewCompressor() or NewCompressorPool().
 //
 // See Compress for further information.
 func CompressZlib(in, out []byte) (int, []byte, error) {
@@ -16,8 +16,8 @@ func CompressZlib(in, out []byte) (int, []byte, error) {
 // of bytes written to out, out (sliced to written) or an error if the out buffer was too short.
 // If you pass nil for out, this function will allocate a fitting buffer and return it (not preferred though).
 //
-// IF YOU WANT TO COMPRESS MORE THAN ONCE, PLEASE REFER TO NewCompressorLevel(),
-// as this function creates a new Compressor (alloc 32KiB) which is then closed at the end of the function.
+// This function takes a Compressor from an internal CompressorPool shared by all callers, so repeated calls
+// don't allocate a new Compressor every time. For full control, refer to NewCompressorLevel() or NewCompressorPool().
 //
 // See CompressLevel for further information.
 func CompressZlibLevel(in, out []byte, level int) (int, []byte, error) {
@@ -30,8 +30,8 @@ func CompressZlibLevel(in, out []byte, level int) (int, []byte, error) {
 //
 // m specifies which compression format should be used (e.g. ModeZlib). Uses default compression level.
 //
-// IF YOU WANT TO COMPRESS MORE THAN ONCE, PLEASE REFER TO NewCompressor(),
-// as this function creates a new Compressor (alloc 32KiB) which is then closed at the end of the function.
+// This function takes a Compressor from an internal CompressorPool shared by all callers, so repeated calls
+// don't allocate a new Compressor every time. For full control, refer to NewCompressor() or NewCompressorPool().
 //
 // Notice that for extremely small or already highly compressed data,
 // the compressed data could be larger than uncompressed.
@@ -47,18 +47,18 @@ func Compress(in, out []byte, m Mode) (int, []byte, error) {
 // m specifies which compression format should be used (e.g. ModeZlib).
 // Level defines the compression level.
 //
-// IF YOU WANT TO COMPRESS MORE THAN ONCE, PLEASE REFER TO NewCompressorLevel(),
-// as this function creates a new Compressor (alloc 32KiB) which is then closed at the end of the function.
+// This function takes a Compressor from an internal CompressorPool shared by all callers, so repeated calls
+// don't allocate a new Compressor every time. For full control, refer to NewCompressorLevel() or NewCompressorPool().
 //
 // Notice that for extremely small or already highly compressed data,
 // the compressed data could be larger than uncompressed.
 // If out == nil: For a too large discrepancy (len(out) > 1000 + 2 * len(in)) Compress will error
 func CompressLevel(in, out []byte, m Mode, level int) (int, []byte, error) {
-	c, err := NewCompressorLevel(level)
+	c, err := defaultCompressorPool.Get(level)
 	if err != nil {
 		return 0, out, err
 	}
-	defer c.Close()
+	defer defaultCompressorPool.Put(c)
 
 	return c.Compress(in, out, m)
 }
@@ -0,0 +1,114 @@
+package libdeflate
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/4kills/go-libdeflate/v2/internal/bitstream"
+)
+
+// Concat joins the independently compressed streams parts of mode m into a single stream of the same mode,
+// which decompresses to the concatenation of the decompressed data of the parts.
+// Unlike multi-member gzip or back-to-back zlib streams, the result can be read by any decompressor,
+// including ones that stop after the first stream.
+//
+// The parts are joined at the bitstream level without decompressing or recompressing them:
+// The final block of every part but the last one is turned into a non-final block followed by an empty stored block,
+// which restores the byte alignment for the next part. The checksums of the parts are merged with Crc32Combine
+// or Adler32Combine, so they are not verified. The header of the result is the header of the first part.
+//
+// Errors if no parts, an invalid mode or a part that is not a single complete stream of mode m was passed.
+func Concat(m Mode, parts ...[]byte) ([]byte, error) {
+	if m != ModeDEFLATE && m != ModeZlib && m != ModeGzip {
+		return nil, errorInvalidModeCompressor
+	}
+	if len(parts) == 0 {
+		return nil, errorNoInput
+	}
+
+	var (
+		out   []byte
+		check uint32 // CRC32 or Adler32 of the data so far
+		size  int64
+	)
+	if m == ModeZlib {
+		check = 1
+	}
+	for i, part := range parts {
+		hdrLen, s, err := walkStream(part, m)
+		if err != nil {
+			return nil, err
+		}
+		payload := part[hdrLen:]
+		trailer := payload[(s.End+7)/8:]
+
+		if i == 0 {
+			out = append(out, part[:hdrLen]...)
+		}
+		if i < len(parts)-1 {
+			out = bitstream.AppendUnfinished(out, payload, s)
+		} else {
+			out = append(out, payload[:len(payload)-len(trailer)]...)
+		}
+
+		switch m {
+		case ModeGzip:
+			if binary.LittleEndian.Uint32(trailer[4:]) != uint32(s.Size) {
+				return nil, errorGzipChecksum
+			}
+			check = Crc32Combine(check, binary.LittleEndian.Uint32(trailer), s.Size)
+		case ModeZlib:
+			check = Adler32Combine(check, binary.BigEndian.Uint32(trailer), s.Size)
+		}
+		size += s.Size
+	}
+
+	switch m {
+	case ModeGzip:
+		var trailer [gzipTrailerSize]byte
+		binary.LittleEndian.PutUint32(trailer[:], check)
+		binary.LittleEndian.PutUint32(trailer[4:], uint32(size))
+		out = append(out, trailer[:]...)
+	case ModeZlib:
+		var trailer [zlibTrailerSize]byte
+		binary.BigEndian.PutUint32(trailer[:], check)
+		out = append(out, trailer[:]...)
+	}
+	return out, nil
+}
+
+// walkStream walks the single stream of mode m in and returns the length of its header and its DEFLATE stream.
+// Errors if the header or the DEFLATE stream are invalid or if in does not end with the trailer of the stream.
+func walkStream(in []byte, m Mode) (int, bitstream.Stream, error) {
+	var hdrLen, trailerLen int
+	switch m {
+	case ModeGzip:
+		_, n, err := ParseGzipHeader(in)
+		if err != nil {
+			return 0, bitstream.Stream{}, err
+		}
+		hdrLen, trailerLen = n, gzipTrailerSize
+	case ModeZlib:
+		if len(in) < len(defaultZlibHeader) {
+			return 0, bitstream.Stream{}, io.ErrUnexpectedEOF
+		}
+		if detected, err := DetectMode(in); err != nil {
+			return 0, bitstream.Stream{}, err
+		} else if detected != ModeZlib {
+			return 0, bitstream.Stream{}, errorZlibHeader
+		}
+		hdrLen, trailerLen = len(defaultZlibHeader), zlibTrailerSize
+	}
+
+	s, err := bitstream.Walk(in[hdrLen:])
+	if err != nil {
+		return 0, s, err
+	}
+	switch end := hdrLen + (s.End+7)/8 + trailerLen; {
+	case len(in) < end:
+		return 0, s, io.ErrUnexpectedEOF
+	case len(in) > end:
+		return 0, s, ErrTrailingGarbage
+	}
+	return hdrLen, s, nil
+}
@@ -0,0 +1,161 @@
+package libdeflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestConcat(t *testing.T) {
+	random := make([]byte, 70000)
+	rand.New(rand.NewSource(1)).Read(random)
+	inputs := [][]byte{shortString, random, rsyncTestData(300000), {'a'}, bytes.Repeat(shortString, 1000)}
+
+	for _, m := range []Mode{ModeDEFLATE, ModeZlib, ModeGzip} {
+		for level := MinCompressionLevel; level <= MaxCompressionLevel; level += 3 {
+			var parts [][]byte
+			var expected []byte
+			for _, in := range inputs {
+				parts = append(parts, compressLevel(in, m, level, t))
+				expected = append(expected, in...)
+			}
+
+			out, err := Concat(m, parts...)
+			if err != nil {
+				t.Fatalf("%v level %d: %v", m, level, err)
+			}
+			// a single stream, whose checksum is verified by libdeflate
+			n, data, err := DecompressWithOptions(out, nil, m, DecompressorOptions{MaxDecompressionFactor: MaxPossibleDecompressionFactor})
+			if err != nil {
+				t.Fatalf("%v level %d: %v", m, level, err)
+			}
+			if n != len(out) {
+				t.Errorf("%v level %d: consumed %d of %d bytes", m, level, n, len(out))
+			}
+			slicesEqual(expected, data, t)
+		}
+	}
+}
+
+func TestConcatSinglePart(t *testing.T) {
+	comp := compressLevel(shortString, ModeGzip, DefaultCompressionLevel, t)
+	out, err := Concat(ModeGzip, comp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(comp, out, t)
+
+	// empty members contribute nothing
+	out, err = Concat(ModeGzip, emptyGzipMember, comp, emptyGzipMember)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, data, err := Decompress(out, nil, ModeGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(shortString, data, t)
+}
+
+func TestConcatErrors(t *testing.T) {
+	comp := compressLevel(shortString, ModeZlib, DefaultCompressionLevel, t)
+
+	if _, err := Concat(ModeZlib); err != errorNoInput {
+		t.Errorf("expected errorNoInput, got %v", err)
+	}
+	if _, err := Concat(ModeAuto, comp); err != errorInvalidModeCompressor {
+		t.Errorf("expected errorInvalidModeCompressor, got %v", err)
+	}
+	if _, err := Concat(ModeZlib, comp, append(comp, 0)); err != ErrTrailingGarbage {
+		t.Errorf("expected ErrTrailingGarbage, got %v", err)
+	}
+	if _, err := Concat(ModeZlib, comp[:len(comp)-1], comp); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if _, err := Concat(ModeZlib, comp[2:]); err != errorZlibHeader {
+		t.Errorf("expected errorZlibHeader, got %v", err)
+	}
+	if _, err := Concat(ModeGzip, comp); err != errorGzipHeader {
+		t.Errorf("expected errorGzipHeader, got %v", err)
+	}
+
+	gz := compressLevel(shortString, ModeGzip, DefaultCompressionLevel, t)
+	gz[len(gz)-1] ^= 0xff
+	if _, err := Concat(ModeGzip, gz, gz); err != errorGzipChecksum {
+		t.Errorf("expected errorGzipChecksum, got %v", err)
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestConcatStdLib(t *testing.T) {
+	in := [][]byte{shortString, rsyncTestData(100000), []byte("end")}
+	expected := bytes.Join(in, nil)
+
+	var zlibParts, gzipParts [][]byte
+	for _, data := range in {
+		buf := &bytes.Buffer{}
+		zw := zlib.NewWriter(buf)
+		zw.Write(data)
+		zw.Close()
+		zlibParts = append(zlibParts, buf.Bytes())
+		gzipParts = append(gzipParts, compressLevel(data, ModeGzip, DefaultCompressionLevel, t))
+	}
+
+	out, err := Concat(ModeZlib, zlibParts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(expected, data, t)
+
+	out, err = Concat(ModeGzip, gzipParts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gr, err := gzip.NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gr.Multistream(false)
+	if data, err = ioutil.ReadAll(gr); err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(expected, data, t)
+
+	// streams written by compress/flate end with an empty final block after the data
+	var flateParts [][]byte
+	for _, data := range in {
+		buf := &bytes.Buffer{}
+		fw, _ := flate.NewWriter(buf, flate.BestSpeed)
+		fw.Write(data)
+		fw.Close()
+		flateParts = append(flateParts, buf.Bytes())
+	}
+	out, err = Concat(ModeDEFLATE, flateParts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err = ioutil.ReadAll(flate.NewReader(bytes.NewReader(out))); err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(expected, data, t)
+}
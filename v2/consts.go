@@ -9,3 +9,8 @@ const (
 	MaxCompressionLevel        = native.MaxCompressionLevel
 	DefaultCompressionLevel    = native.DefaultCompressionLevel
 )
+
+// MaxPossibleDecompressionFactor is the largest factor by which DEFLATE data can grow when decompressed.
+// A Decompressor created by NewDecompressorWithExtendedDecompression(MaxPossibleDecompressionFactor)
+// never rejects valid data because of its decompression factor.
+const MaxPossibleDecompressionFactor = 1032
@@ -9,8 +9,8 @@ package libdeflate
 // If you pass a buffer to out, the size of this buffer must exactly match the length of the decompressed data.
 // If you pass nil to out, this function will allocate a sufficient buffer and return it.
 //
-// IF YOU WANT TO DECOMPRESS MORE THAN ONCE, PLEASE REFER TO NewDecompressor(),
-// as this function creates a new Decompressor (alloc 32KiB) which is then closed at the end of the function.
+// This function takes a Decompressor from an internal DecompressorPool shared by all callers, so repeated calls
+// don't allocate a new Decompressor every time. For full control, refer to NewDecompressor() or NewDecompressorPool().
 //
 // If error != nil, the data in out is undefined.
 func DecompressZlib(in, out []byte) (int, []byte, error) {
@@ -27,12 +27,30 @@ func DecompressZlib(in, out []byte) (int, []byte, error) {
 // If you pass a buffer to out, the size of this buffer must exactly match the length of the decompressed data.
 // If you pass nil to out, this function will allocate a sufficient buffer and return it.
 //
-// IF YOU WANT TO DECOMPRESS MORE THAN ONCE, PLEASE REFER TO NewDecompressor(),
-// as this function creates a new Decompressor (alloc 32KiB) which is then closed at the end of the function.
+// This function takes a Decompressor from an internal DecompressorPool shared by all callers, so repeated calls
+// don't allocate a new Decompressor every time. For full control, refer to NewDecompressor() or NewDecompressorPool().
 //
 // If error != nil, the data in out is undefined.
 func Decompress(in, out []byte, m Mode) (int, []byte, error) {
-	dc, err := NewDecompressor()
+	dc, err := defaultDecompressorPool.Get()
+	if err != nil {
+		return 0, out, err
+	}
+	defer defaultDecompressorPool.Put(dc)
+
+	return dc.Decompress(in, out, m)
+}
+
+// DecompressWithOptions decompresses the given data from in to out like Decompress, but with a Decompressor
+// configured by opts, e.g. to limit the size of the decompressed data with opts.MaxOutputBytes.
+// Returns the number of consumed bytes c from 'in' and 'out' or an error if something went wrong.
+//
+// IF YOU WANT TO DECOMPRESS MORE THAN ONCE, PLEASE REFER TO NewDecompressorWithOptions(),
+// as this function creates a new Decompressor (alloc 32KiB) which is then closed at the end of the function.
+//
+// If error != nil, the data in out is undefined.
+func DecompressWithOptions(in, out []byte, m Mode, opts DecompressorOptions) (int, []byte, error) {
+	dc, err := NewDecompressorWithOptions(opts)
 	if err != nil {
 		return 0, out, err
 	}
@@ -40,3 +58,20 @@ func Decompress(in, out []byte, m Mode) (int, []byte, error) {
 
 	return dc.Decompress(in, out, m)
 }
+
+// DecompressAll decompresses all back-to-back compressed streams of mode m within in, such as all members
+// of a concatenated gzip file, and returns the concatenation of their decompressed data.
+//
+// This function takes a Decompressor from an internal DecompressorPool shared by all callers, so repeated calls
+// don't allocate a new Decompressor every time. For full control, refer to NewDecompressor() or NewDecompressorPool().
+//
+// See Decompressor.DecompressAll for further information.
+func DecompressAll(in []byte, m Mode) ([]byte, error) {
+	dc, err := defaultDecompressorPool.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer defaultDecompressorPool.Put(dc)
+
+	return dc.DecompressAll(in, m)
+}
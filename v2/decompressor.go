@@ -49,6 +49,30 @@ func NewDecompressorWithExtendedDecompression(maxDecompressionFactor int) (Decom
 	return Decompressor{dc}, err
 }
 
+// DecompressorOptions configures a Decompressor created by NewDecompressorWithOptions.
+type DecompressorOptions struct {
+	// MaxDecompressionFactor customizes how much larger your output than your input may be.
+	// See NewDecompressorWithExtendedDecompression. If 0, the default factor is used.
+	MaxDecompressionFactor int
+
+	// MaxOutputBytes is a hard limit of the size of the decompressed data, independent of the size of the input.
+	// Decompressing more data errors with ErrOutputLimitExceeded. If 0, there is no limit.
+	// Use this to defend against decompression bombs from untrusted sources.
+	MaxOutputBytes int64
+}
+
+// NewDecompressorWithOptions returns a new Decompressor used to decompress data at any compression level and with any Mode,
+// configured by opts.
+// Errors if out of memory. Allocates 32KiB.
+func NewDecompressorWithOptions(opts DecompressorOptions) (Decompressor, error) {
+	factor := opts.MaxDecompressionFactor
+	if factor <= 0 {
+		factor = native.DefaultMaxDecompressionFactor
+	}
+	dc, err := native.NewDecompressorWithLimits(factor, opts.MaxOutputBytes)
+	return Decompressor{dc}, err
+}
+
 // DecompressZlib decompresses the given zlib data from in to out and returns the number of consumed bytes c
 // from 'in' and 'out' or an error if something went wrong.
 //
@@ -66,12 +90,16 @@ func (dc Decompressor) DecompressZlib(in, out []byte) (int, []byte, error) {
 // Decompress decompresses the given data from in to out and returns the number of consumed bytes c from 'in' and 'out'
 // or an error if something went wrong.
 // Mode m specifies the format (e.g. zlib) of the data within in.
+// If m is ModeAuto, the format is detected as described in DecompressAuto.
 //
 // c is the number of bytes that were read before the BFINAL flag was
 // encountered, which indicates the end of the compressed data.
+// Only the first member of concatenated data is decompressed, see DecompressAll and Members for all members.
 //
 // If you pass a buffer to out, the size of this buffer must exactly match the length of the decompressed data.
 // If you pass nil to out, this function will allocate a sufficient buffer and return it.
+// For gzip data, the buffer is allocated once with the size stored in the trailer at the end of in, which is only
+// kept if the member ends there. Otherwise, its size is guessed based on the ratios previously observed by this Decompressor.
 //
 // If error != nil, the data in out is undefined.
 func (dc Decompressor) Decompress(in, out []byte, m Mode) (int, []byte, error) {
@@ -81,7 +109,105 @@ func (dc Decompressor) Decompress(in, out []byte, m Mode) (int, []byte, error) {
 	case ModeDEFLATE:
 		return dc.dc.Decompress(in, out, native.DecompressDEFLATE)
 	case ModeGzip:
+		if out == nil {
+			c, out, err := dc.dc.DecompressExpectedSize(in, gzipISize(in), native.DecompressGzip)
+			if err == nil && c != len(in) {
+				// the buffer was sized from the ISIZE of a later member
+				out = trimCap(out)
+			}
+			return c, out, err
+		}
 		return dc.dc.Decompress(in, out, native.DecompressGzip)
+	case ModeAuto:
+		c, out, _, err := dc.DecompressAuto(in, out)
+		return c, out, err
+	default:
+		panic(errorInvalidModeDecompressor)
+	}
+}
+
+// DecompressAuto decompresses the given data from in to out like Decompress, but detects the format of the data
+// and returns it along with the number of consumed bytes c from 'in' and 'out' or an error if something went wrong.
+//
+// The format is detected by DetectMode. As raw DEFLATE data may look like a zlib header by chance,
+// data that fails to decompress as zlib is decompressed as raw DEFLATE again.
+// Errors with ErrZlibDictionary if the data is zlib with a preset dictionary.
+//
+// If error != nil, the data in out is undefined.
+func (dc Decompressor) DecompressAuto(in, out []byte) (int, []byte, Mode, error) {
+	m, err := DetectMode(in)
+	if err != nil {
+		return 0, out, m, err
+	}
+
+	c, res, err := dc.Decompress(in, out, m)
+	if err != nil && m == ModeZlib && err != ErrOutputLimitExceeded {
+		if c, res, err := dc.Decompress(in, out, ModeDEFLATE); err == nil {
+			return c, res, ModeDEFLATE, nil
+		}
+	}
+	return c, res, m, err
+}
+
+// DecompressSizeHint decompresses the given data from in and returns the number of consumed bytes c from 'in'
+// and the decompressed data or an error if something went wrong.
+// Mode m specifies the format (e.g. zlib) of the data within in.
+//
+// Unlike Decompress with out == nil, the output buffer starts at sizeHint bytes and is doubled until the
+// decompressed data fits. Use this if you roughly know the size of the decompressed data.
+// If sizeHint <= 0, len(in) is used as initial size. If m is ModeAuto, the format is detected by DetectMode.
+// Like Decompress, this errors if the output would exceed the maximum decompression factor of this Decompressor.
+//
+// If error != nil, the returned data is undefined.
+func (dc Decompressor) DecompressSizeHint(in []byte, sizeHint int, m Mode) (int, []byte, error) {
+	if m == ModeAuto {
+		var err error
+		if m, err = DetectMode(in); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	switch m {
+	case ModeZlib:
+		return dc.dc.DecompressSizeHint(in, sizeHint, native.DecompressZlib)
+	case ModeDEFLATE:
+		return dc.dc.DecompressSizeHint(in, sizeHint, native.DecompressDEFLATE)
+	case ModeGzip:
+		return dc.dc.DecompressSizeHint(in, sizeHint, native.DecompressGzip)
+	default:
+		panic(errorInvalidModeDecompressor)
+	}
+}
+
+// DecodesAtLeast reports whether the data in of mode m decompresses to at least size bytes before it ends
+// or turns out to be invalid. The decompressed data is discarded.
+//
+// After a failed decompression, this tells truncated data from data that is corrupt earlier on:
+// the decompressed data is at least about as large as the compressed data it was decompressed from,
+// so data that fails before decompressing to len(in)/2 bytes fails before its end, and more input would not help.
+func (dc Decompressor) DecodesAtLeast(in []byte, size int, m Mode) bool {
+	switch m {
+	case ModeZlib:
+		return dc.dc.DecodesAtLeast(in, size, native.DecompressZlib)
+	case ModeDEFLATE:
+		return dc.dc.DecodesAtLeast(in, size, native.DecompressDEFLATE)
+	case ModeGzip:
+		return dc.dc.DecodesAtLeast(in, size, native.DecompressGzip)
+	default:
+		panic(errorInvalidModeDecompressor)
+	}
+}
+
+// decompressExpectedSize decompresses the given data from in, first trying an output buffer of exactly size bytes.
+// See native.Decompressor.DecompressExpectedSize.
+func (dc Decompressor) decompressExpectedSize(in []byte, size int, m Mode) (int, []byte, error) {
+	switch m {
+	case ModeZlib:
+		return dc.dc.DecompressExpectedSize(in, size, native.DecompressZlib)
+	case ModeDEFLATE:
+		return dc.dc.DecompressExpectedSize(in, size, native.DecompressDEFLATE)
+	case ModeGzip:
+		return dc.dc.DecompressExpectedSize(in, size, native.DecompressGzip)
 	default:
 		panic(errorInvalidModeDecompressor)
 	}
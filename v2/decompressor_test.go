@@ -6,6 +6,7 @@ import (
 	"compress/gzip"
 	"compress/zlib"
 	"encoding/hex"
+	"math/rand"
 	"strings"
 	"testing"
 )
@@ -133,3 +134,96 @@ func TestDecompressWithDeadlyCode(t *testing.T) {
 		return
 	}
 }
+
+func TestDecompressGzipISize(t *testing.T) {
+	in := bytes.Repeat(shortString, 3)
+	_, comp, _ := Compress(in, nil, ModeGzip)
+
+	dc, _ := NewDecompressor()
+	defer dc.Close()
+
+	c, out, err := dc.Decompress(comp, nil, ModeGzip)
+	if err != nil || c != len(comp) {
+		t.Error(err)
+	}
+	slicesEqual(in, out, t)
+	if cap(out) != len(in) {
+		t.Errorf("expected exact allocation of %d bytes, got %d", len(in), cap(out))
+	}
+
+	// trailing data invalidates the ISIZE guess
+	c, out, err = dc.Decompress(append(comp, comp...), nil, ModeGzip)
+	if err != nil || c != len(comp) {
+		t.Error(err)
+	}
+	slicesEqual(in, out, t)
+
+	// a small member followed by a large one does not keep a buffer of the size of the large one
+	large := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(large)
+	_, largeComp, _ := Compress(large, nil, ModeGzip)
+	multi := append(append([]byte{}, comp...), largeComp...)
+	c, out, err = dc.Decompress(multi, nil, ModeGzip)
+	if err != nil || c != len(comp) {
+		t.Fatal(err)
+	}
+	slicesEqual(in, out, t)
+	if cap(out) != len(in) {
+		t.Errorf("expected a buffer of %d bytes, got %d", len(in), cap(out))
+	}
+
+	n, out, _, _, err := dc.DecompressGzipWithHeader(multi, nil)
+	if err != nil || n != len(comp) {
+		t.Fatal(err)
+	}
+	slicesEqual(in, out, t)
+	if cap(out) != len(in) {
+		t.Errorf("expected a buffer of %d bytes, got %d", len(in), cap(out))
+	}
+}
+
+func TestDecodesAtLeast(t *testing.T) {
+	_, comp, _ := Compress(bytes.Repeat(shortString, 100), nil, ModeZlib)
+	dc, _ := NewDecompressor()
+	defer dc.Close()
+
+	if !dc.DecodesAtLeast(comp, 50*len(shortString), ModeZlib) || !dc.DecodesAtLeast(comp, 100*len(shortString), ModeZlib) {
+		t.Error("complete data decodes to its full size")
+	}
+	if dc.DecodesAtLeast(comp, 100*len(shortString)+1, ModeZlib) {
+		t.Error("complete data decodes beyond its size")
+	}
+	// truncated data decodes up to its end
+	if !dc.DecodesAtLeast(comp[:len(comp)/2], len(shortString), ModeZlib) {
+		t.Error("truncated data does not decode")
+	}
+	invalid := append([]byte{}, comp...)
+	invalid[2] |= 6 // reserved block type
+	if dc.DecodesAtLeast(invalid, 1, ModeZlib) {
+		t.Error("invalid data decodes")
+	}
+}
+
+func TestDecompressorMaxOutputBytes(t *testing.T) {
+	in := make([]byte, 1<<20)
+	_, comp, _ := Compress(in, nil, ModeZlib)
+
+	dc, _ := NewDecompressorWithOptions(DecompressorOptions{MaxDecompressionFactor: MaxPossibleDecompressionFactor, MaxOutputBytes: 1 << 16})
+	defer dc.Close()
+
+	if _, _, err := dc.Decompress(comp, nil, ModeZlib); err != ErrOutputLimitExceeded {
+		t.Error(err)
+	}
+	if _, _, err := dc.DecompressSizeHint(comp, 0, ModeZlib); err != ErrOutputLimitExceeded {
+		t.Error(err)
+	}
+	if _, _, err := dc.Decompress(comp, make([]byte, len(in)), ModeZlib); err != ErrOutputLimitExceeded {
+		t.Error(err)
+	}
+
+	_, out, err := DecompressWithOptions(comp, nil, ModeZlib, DecompressorOptions{MaxDecompressionFactor: MaxPossibleDecompressionFactor, MaxOutputBytes: 1 << 20})
+	if err != nil {
+		t.Error(err)
+	}
+	slicesEqual(in, out, t)
+}
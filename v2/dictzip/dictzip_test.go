@@ -0,0 +1,282 @@
+package dictzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestWriteReadAt(t *testing.T) {
+	data := testData(3*DefaultChunkSize + 1000)
+	for _, level := range []int{libdeflate.MinCompressionLevel, 1, libdeflate.DefaultCompressionLevel, libdeflate.MaxCompressionLevel} {
+		file := compress(data, level, DefaultChunkSize, t)
+		r, err := NewReader(bytes.NewReader(file), int64(len(file)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Size() != int64(len(data)) || r.ChunkSize() != DefaultChunkSize {
+			t.Fatalf("size %d, chunk size %d", r.Size(), r.ChunkSize())
+		}
+		checkRanges(r, data, t)
+	}
+}
+
+func TestChunkTable(t *testing.T) {
+	data := testData(10*1000 + 1)
+	buf := &bytes.Buffer{}
+	w, _ := NewWriterSize(buf, libdeflate.DefaultCompressionLevel, 1000)
+	w.Header.Name = "dict.txt"
+	w.Header.AddExtraField([2]byte{'X', 'Y'}, []byte("other"))
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file := buf.Bytes()
+
+	h, n, err := libdeflate.ParseGzipHeader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Name != "dict.txt" {
+		t.Errorf("name %q", h.Name)
+	}
+	fields, _ := h.ExtraFields()
+	if len(fields) != 2 || fields[0].ID != [2]byte{'R', 'A'} || string(fields[1].Data) != "other" {
+		t.Fatalf("extra fields %v", fields)
+	}
+	ra := fields[0].Data
+	if binary.LittleEndian.Uint16(ra) != 1 || binary.LittleEndian.Uint16(ra[2:]) != 1000 || binary.LittleEndian.Uint16(ra[4:]) != 11 {
+		t.Fatalf("RA header %v", ra[:6])
+	}
+
+	// every chunk but the last decompresses on its own and ends with a sync marker
+	offset := n
+	for i := 0; i < 11; i++ {
+		size := int(binary.LittleEndian.Uint16(ra[6+2*i:]))
+		chunk := file[offset : offset+size]
+		if i < 10 && !bytes.HasSuffix(chunk, []byte{0x00, 0x00, 0xff, 0xff}) {
+			t.Errorf("chunk %d lacks sync marker", i)
+		}
+		out, _ := ioutil.ReadAll(flate.NewReader(bytes.NewReader(chunk)))
+		end := (i + 1) * 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		slicesEqual(data[i*1000:end], out, t)
+		offset += size
+	}
+	if offset+8 != len(file) {
+		t.Errorf("chunks end at %d of %d", offset, len(file))
+	}
+}
+
+func TestEmpty(t *testing.T) {
+	file := compress(nil, libdeflate.DefaultCompressionLevel, DefaultChunkSize, t)
+	zr, err := gzip.NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := ioutil.ReadAll(zr); err != nil || len(out) != 0 {
+		t.Errorf("read %d bytes: %v", len(out), err)
+	}
+
+	r, err := NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := r.ReadAt(make([]byte, 1), 0); n != 0 || err != io.EOF {
+		t.Errorf("expected EOF, got %d, %v", n, err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	if _, err := NewWriterSize(ioutil.Discard, libdeflate.DefaultCompressionLevel, DefaultChunkSize+1); err != errorInvalidChunkSize {
+		t.Errorf("expected errorInvalidChunkSize, got %v", err)
+	}
+	if _, err := NewWriter(ioutil.Discard, 13); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	w, _ := NewWriter(ioutil.Discard, libdeflate.DefaultCompressionLevel)
+	w.Close()
+	if _, err := w.Write(shortString); err != errorWriterClosed {
+		t.Errorf("expected errorWriterClosed, got %v", err)
+	}
+
+	// plain gzip files lack the RA subfield
+	plain := gzipCompress(shortString)
+	if _, err := NewReader(bytes.NewReader(plain), int64(len(plain))); err != errorHeader {
+		t.Errorf("expected errorHeader, got %v", err)
+	}
+
+	file := compress(testData(5000), libdeflate.DefaultCompressionLevel, 1000, t)
+	if _, err := NewReader(bytes.NewReader(file), int64(len(file)-100)); err != errorCorrupt {
+		t.Errorf("expected errorCorrupt, got %v", err)
+	}
+	r, _ := NewReader(bytes.NewReader(file), int64(len(file)))
+	if _, err := r.ReadAt(make([]byte, 1), -1); err != errorNegativeOffset {
+		t.Errorf("expected errorNegativeOffset, got %v", err)
+	}
+
+	corrupt := append([]byte{}, file...)
+	corrupt[len(corrupt)-30] ^= 0xff
+	r, _ = NewReader(bytes.NewReader(corrupt), int64(len(corrupt)))
+	if _, err := r.ReadAt(make([]byte, 10), 4990); err == nil {
+		t.Error("expected error for corrupt chunk")
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestStdGzipInterop(t *testing.T) {
+	data := make([]byte, 2*DefaultChunkSize+17)
+	rand.New(rand.NewSource(1)).Read(data[:DefaultChunkSize])
+	copy(data[DefaultChunkSize:], testData(DefaultChunkSize+17))
+
+	// the chunks form a single gzip member
+	file := compress(data, libdeflate.DefaultCompressionLevel, DefaultChunkSize, t)
+	zr, err := gzip.NewReader(bytes.NewReader(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr.Multistream(false)
+	out, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(data, out, t)
+}
+
+func TestReadDictzipFiles(t *testing.T) {
+	// files written like dictzip does with zlib: chunks ending with a flush, without a final block
+	data := testData(5*4000 + 123)
+	const chunkSize = 4000
+	comp := &bytes.Buffer{}
+	var sizes []uint16
+	for pos := 0; pos < len(data); pos += chunkSize {
+		end := pos + chunkSize
+		start := comp.Len()
+		fw, _ := flate.NewWriter(comp, flate.BestCompression)
+		if end >= len(data) {
+			fw.Write(data[pos:])
+			fw.Close()
+		} else {
+			fw.Write(data[pos:end])
+			fw.Flush()
+		}
+		sizes = append(sizes, uint16(comp.Len()-start))
+	}
+
+	ra := make([]byte, 6+2*len(sizes))
+	binary.LittleEndian.PutUint16(ra, 1)
+	binary.LittleEndian.PutUint16(ra[2:], chunkSize)
+	binary.LittleEndian.PutUint16(ra[4:], uint16(len(sizes)))
+	for i, s := range sizes {
+		binary.LittleEndian.PutUint16(ra[6+2*i:], s)
+	}
+	h := libdeflate.GzipHeader{OS: libdeflate.GzipOSUnix, Comment: "dictzip"}
+	h.AddExtraField([2]byte{'R', 'A'}, ra)
+	file, _ := h.MarshalBinary()
+	file = append(file, comp.Bytes()...)
+	file = append(file, make([]byte, 8)...)
+	binary.LittleEndian.PutUint32(file[len(file)-8:], crc32.ChecksumIEEE(data))
+	binary.LittleEndian.PutUint32(file[len(file)-4:], uint32(len(data)))
+
+	r, err := NewReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Header.Comment != "dictzip" || r.Size() != int64(len(data)) {
+		t.Fatalf("comment %q, size %d", r.Header.Comment, r.Size())
+	}
+	checkRanges(r, data, t)
+}
+
+func checkRanges(r *Reader, data []byte, t *testing.T) {
+	chunk := int64(r.ChunkSize())
+	size := int64(len(data))
+	for _, rng := range [][2]int64{
+		{0, 10}, {chunk - 5, 10}, {chunk, chunk}, {chunk / 2, 2 * chunk}, {size - 7, 7}, {0, size},
+	} {
+		off, n := rng[0], rng[1]
+		out := make([]byte, n)
+		if c, err := r.ReadAt(out, off); err != nil || c != int(n) {
+			t.Fatalf("ReadAt(%d, %d): %d, %v", n, off, c, err)
+		}
+		slicesEqual(data[off:off+n], out, t)
+	}
+
+	// reads beyond the end are short
+	out := make([]byte, 20)
+	if n, err := r.ReadAt(out, size-5); n != 5 || err != io.EOF {
+		t.Errorf("expected 5 bytes and EOF, got %d, %v", n, err)
+	}
+	if n, err := r.ReadAt(out, size+5); n != 0 || err != io.EOF {
+		t.Errorf("expected EOF, got %d, %v", n, err)
+	}
+}
+
+func compress(data []byte, level, chunkSize int, t *testing.T) []byte {
+	buf := &bytes.Buffer{}
+	w, err := NewWriterSize(buf, level, chunkSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// odd write sizes to cross chunk boundaries
+	for len(data) > 0 {
+		n := 7919
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func testData(n int) []byte {
+	buf := &bytes.Buffer{}
+	for i := 0; buf.Len() < n; i++ {
+		buf.WriteString("headword")
+		buf.WriteByte(byte('a' + i%26))
+		buf.WriteByte(byte('a' + i/26%26))
+		buf.WriteString("\n    definition of the word, see also ")
+		buf.Write(shortString[:i%len(shortString)])
+	}
+	return buf.Bytes()[:n]
+}
+
+func gzipCompress(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := gzip.NewWriter(buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
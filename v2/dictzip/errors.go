@@ -0,0 +1,13 @@
+package dictzip
+
+import "errors"
+
+var (
+	errorHeader           = errors.New("libdeflate: dictzip: missing or invalid RA extra field")
+	errorCorrupt          = errors.New("libdeflate: dictzip: chunk table does not match file")
+	errorInvalidChunkSize = errors.New("libdeflate: dictzip: chunk size out of range")
+	errorTooManyChunks    = errors.New("libdeflate: dictzip: too many chunks for the RA extra field")
+	errorChunkTooLarge    = errors.New("libdeflate: dictzip: compressed chunk exceeds 65535 bytes")
+	errorNegativeOffset   = errors.New("libdeflate: dictzip: negative offset")
+	errorWriterClosed     = errors.New("libdeflate: dictzip: writer already closed")
+)
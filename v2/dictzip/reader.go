@@ -0,0 +1,175 @@
+package dictzip
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// tail is appended to a chunk before decompressing it: an empty final stored block, as libdeflate requires
+// the data to end with a final block. Chunks end with an empty stored block on a byte boundary, except for
+// the last chunk, which ends with the final block, so the appended block is ignored.
+var tail = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+
+// decompressors are shared by all Readers.
+var decompressors, _ = libdeflate.NewDecompressorPool(libdeflate.PoolOptions{}, libdeflate.DecompressorOptions{})
+
+// Reader gives random access to the decompressed data of a dictzip file. It is safe for concurrent use.
+// As only the requested chunks are decompressed, the CRC32 of the whole file is not verified.
+type Reader struct {
+	// Header is the gzip header of the file, including the RA subfield in its extra field.
+	Header libdeflate.GzipHeader
+
+	r         io.ReaderAt
+	chunkSize int
+	offsets   []int64 // offset of every chunk in the file, and the end of the last chunk
+	size      int64
+
+	mu     sync.Mutex
+	cached int // index of the chunk in cache, or -1
+	cache  []byte
+}
+
+// NewReader returns a new Reader reading the dictzip file r of the given size.
+// Errors if r is not a gzip file with a valid RA subfield or if the chunk table does not match the file.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	h, n, err := readHeader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	z := &Reader{Header: h, r: r, cached: -1}
+
+	var ra []byte
+	fields, err := h.ExtraFields()
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range fields {
+		if f.ID == [2]byte{'R', 'A'} {
+			ra = f.Data
+			break
+		}
+	}
+	if len(ra) < 6 || binary.LittleEndian.Uint16(ra) != raVersion {
+		return nil, errorHeader
+	}
+	z.chunkSize = int(binary.LittleEndian.Uint16(ra[2:]))
+	count := int(binary.LittleEndian.Uint16(ra[4:]))
+	if z.chunkSize == 0 || len(ra) != 6+2*count {
+		return nil, errorHeader
+	}
+
+	z.offsets = make([]int64, count+1)
+	z.offsets[0] = int64(n)
+	for i := 0; i < count; i++ {
+		z.offsets[i+1] = z.offsets[i] + int64(binary.LittleEndian.Uint16(ra[6+2*i:]))
+	}
+	if z.offsets[count]+8 > size {
+		return nil, errorCorrupt
+	}
+	if count == 0 {
+		return z, nil
+	}
+
+	// ISIZE is the size modulo 2^32, which determines the size of the last chunk
+	var isize [4]byte
+	if _, err := r.ReadAt(isize[:], size-4); err != nil && err != io.EOF {
+		return nil, err
+	}
+	full := int64(count-1) * int64(z.chunkSize)
+	last := int64(uint32(binary.LittleEndian.Uint32(isize[:]) - uint32(full)))
+	if last == 0 || last > int64(z.chunkSize) {
+		return nil, errorCorrupt
+	}
+	z.size = full + last
+	return z, nil
+}
+
+// readHeader reads and parses the gzip header of r, reading more of r if the header is longer than expected.
+func readHeader(r io.ReaderAt, size int64) (libdeflate.GzipHeader, int, error) {
+	for n := int64(1 << 12); ; n *= 4 {
+		if n > size {
+			n = size
+		}
+		buf := make([]byte, n)
+		if _, err := r.ReadAt(buf, 0); err != nil && err != io.EOF {
+			return libdeflate.GzipHeader{}, 0, err
+		}
+		h, hlen, err := libdeflate.ParseGzipHeader(buf)
+		if err != io.ErrUnexpectedEOF || n == size {
+			return h, hlen, err
+		}
+	}
+}
+
+// Size returns the size of the decompressed data.
+func (z *Reader) Size() int64 {
+	return z.size
+}
+
+// ChunkSize returns the size of the decompressed data of each chunk except the last one.
+func (z *Reader) ChunkSize() int {
+	return z.chunkSize
+}
+
+// ReadAt reads len(p) bytes of decompressed data starting at off into p, decompressing only the chunks
+// covering them. If fewer bytes are read, the error is io.EOF.
+func (z *Reader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errorNegativeOffset
+	}
+	n := 0
+	for n < len(p) && off < z.size {
+		i := int(off / int64(z.chunkSize))
+		data, err := z.chunk(i)
+		if err != nil {
+			return n, err
+		}
+		c := copy(p[n:], data[off-int64(i)*int64(z.chunkSize):])
+		n += c
+		off += int64(c)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// chunk returns the decompressed data of chunk i, which must not be modified.
+// The most recently decompressed chunk is cached, as reads of adjacent ranges often share a chunk.
+func (z *Reader) chunk(i int) ([]byte, error) {
+	z.mu.Lock()
+	if z.cached == i {
+		data := z.cache
+		z.mu.Unlock()
+		return data, nil
+	}
+	z.mu.Unlock()
+
+	size := z.chunkSize
+	if i == len(z.offsets)-2 {
+		size = int(z.size - int64(i)*int64(z.chunkSize))
+	}
+	in := make([]byte, z.offsets[i+1]-z.offsets[i]+int64(len(tail)))
+	if n, err := z.r.ReadAt(in[:len(in)-len(tail)], z.offsets[i]); n < len(in)-len(tail) {
+		return nil, err
+	}
+	copy(in[len(in)-len(tail):], tail)
+
+	dc, err := decompressors.Get()
+	if err != nil {
+		return nil, err
+	}
+	_, data, err := dc.Decompress(in, make([]byte, size), libdeflate.ModeDEFLATE)
+	decompressors.Put(dc)
+	if err != nil {
+		return nil, err
+	}
+
+	z.mu.Lock()
+	z.cached, z.cache = i, data
+	z.mu.Unlock()
+	return data, nil
+}
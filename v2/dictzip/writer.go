@@ -0,0 +1,183 @@
+// Package dictzip reads and writes dictzip files (.dict.dz) with libdeflate: gzip files with random access,
+// as served by dictd.
+//
+// A dictzip file is a single gzip member whose data is split into chunks of a fixed size, each compressed
+// independently of the previous ones. The "RA" subfield of the gzip extra field lists the compressed length
+// of every chunk, so a Reader only decompresses the chunks covering the requested range.
+// Since the chunks are part of one DEFLATE stream, a dictzip file is also a regular gzip file.
+package dictzip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/4kills/go-libdeflate/v2"
+	"github.com/4kills/go-libdeflate/v2/internal/bitstream"
+)
+
+// DefaultChunkSize is the chunk size of dictzip, the largest whose compressed chunks always fit the 16 bit lengths
+// of the chunk table.
+const DefaultChunkSize = 58315
+
+// raVersion is the version of the RA subfield.
+const raVersion = 1
+
+// maxChunks is the number of chunks fitting into an extra field holding only the RA subfield.
+const maxChunks = (0xffff - 4 - 6) / 2
+
+// emptyStream is a final fixed Huffman block without data, the DEFLATE stream of an empty file.
+var emptyStream = []byte{0x03, 0x00}
+
+// Writer compresses data into a dictzip file. Every chunk is compressed as raw DEFLATE stream on its own.
+// Except for the last one, the final block of every chunk is turned into a non-final block followed by
+// an empty stored block, so the chunks form a single DEFLATE stream ending every chunk on a byte boundary.
+//
+// As the chunk table precedes the data in the header, the compressed data is buffered until the Writer is closed.
+// Always Close() the Writer to write the file and to free the c memory of its Compressor.
+type Writer struct {
+	// Header is written on Close. The RA subfield is prepended to its extra field.
+	Header libdeflate.GzipHeader
+
+	w         io.Writer
+	c         libdeflate.Compressor
+	chunkSize int
+
+	buf    []byte // data not compressed yet
+	comp   bytes.Buffer
+	sizes  []uint16
+	crc    uint32
+	length int64
+
+	closed bool
+	err    error
+}
+
+// NewWriter returns a new Writer compressing chunks of DefaultChunkSize bytes at the given level, writing to w.
+// Errors if out of memory or if an invalid compression level was passed.
+func NewWriter(w io.Writer, level int) (*Writer, error) {
+	return NewWriterSize(w, level, DefaultChunkSize)
+}
+
+// NewWriterSize is like NewWriter, but compresses chunks of chunkSize bytes, which must be
+// between 1 and DefaultChunkSize.
+func NewWriterSize(w io.Writer, level, chunkSize int) (*Writer, error) {
+	if chunkSize <= 0 || chunkSize > DefaultChunkSize {
+		return nil, errorInvalidChunkSize
+	}
+	c, err := libdeflate.NewCompressorLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{
+		Header:    libdeflate.GzipHeader{OS: libdeflate.GzipOSUnknown},
+		w:         w,
+		c:         c,
+		chunkSize: chunkSize,
+	}, nil
+}
+
+// Write compresses p chunk by chunk. The compressed data is buffered until Close.
+func (z *Writer) Write(p []byte) (int, error) {
+	if z.closed {
+		return 0, errorWriterClosed
+	}
+	if z.err != nil {
+		return 0, z.err
+	}
+
+	z.buf = append(z.buf, p...)
+	// the last chunk is compressed differently, so a full chunk is kept until more data arrives
+	n := 0
+	for len(z.buf)-n > z.chunkSize {
+		if z.err = z.compressChunk(z.buf[n:n+z.chunkSize], false); z.err != nil {
+			return len(p), z.err
+		}
+		n += z.chunkSize
+	}
+	z.buf = z.buf[:copy(z.buf, z.buf[n:])]
+	return len(p), nil
+}
+
+// Close compresses the remaining data and writes the file to the underlying writer.
+// It frees the Compressor, but does not close the underlying writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if z.err == nil {
+		z.err = z.finish()
+	}
+	z.c.Close()
+	z.closed = true
+	return z.err
+}
+
+func (z *Writer) finish() error {
+	if len(z.buf) > 0 {
+		if err := z.compressChunk(z.buf, true); err != nil {
+			return err
+		}
+	} else if len(z.sizes) == 0 {
+		z.comp.Write(emptyStream)
+	}
+
+	ra := make([]byte, 6+2*len(z.sizes))
+	binary.LittleEndian.PutUint16(ra, raVersion)
+	binary.LittleEndian.PutUint16(ra[2:], uint16(z.chunkSize))
+	binary.LittleEndian.PutUint16(ra[4:], uint16(len(z.sizes)))
+	for i, size := range z.sizes {
+		binary.LittleEndian.PutUint16(ra[6+2*i:], size)
+	}
+	h := z.Header
+	h.Extra = nil
+	if err := h.AddExtraField([2]byte{'R', 'A'}, ra); err != nil {
+		return errorTooManyChunks
+	}
+	if h.Extra = append(h.Extra, z.Header.Extra...); len(h.Extra) > 0xffff {
+		return errorTooManyChunks
+	}
+	if h.ExtraFlags == 0 {
+		h.ExtraFlags = libdeflate.GzipExtraFlags(z.c.Level())
+	}
+	hdr, err := h.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:], z.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(z.length))
+	for _, b := range [][]byte{hdr, z.comp.Bytes(), trailer[:]} {
+		if _, err := z.w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compressChunk compresses a chunk and appends it to the compressed data.
+func (z *Writer) compressChunk(chunk []byte, last bool) error {
+	if len(z.sizes) == maxChunks {
+		return errorTooManyChunks
+	}
+	out := make([]byte, z.c.WorstCaseCompressedSize(len(chunk), libdeflate.ModeDEFLATE))
+	_, out, err := z.c.Compress(chunk, out, libdeflate.ModeDEFLATE)
+	if err != nil {
+		return err
+	}
+	if !last {
+		if out, err = bitstream.Unfinish(out); err != nil {
+			return err
+		}
+	}
+	if len(out) > 0xffff {
+		return errorChunkTooLarge
+	}
+
+	z.comp.Write(out)
+	z.sizes = append(z.sizes, uint16(len(out)))
+	z.crc = libdeflate.Crc32(z.crc, chunk)
+	z.length += int64(len(chunk))
+	return nil
+}
@@ -1,8 +1,35 @@
 package libdeflate
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/4kills/go-libdeflate/v2/native"
+)
+
+// ErrOutputLimitExceeded is returned if the decompressed data would exceed DecompressorOptions.MaxOutputBytes.
+var ErrOutputLimitExceeded = native.ErrOutputLimitExceeded
+
+// ErrZlibDictionary is returned when decompressing zlib data that requires a preset dictionary, which is not supported.
+var ErrZlibDictionary = errors.New("libdeflate: decompressor: zlib data requires a preset dictionary")
+
+// ErrTrailingGarbage is returned by strict member iteration if data follows the last member.
+var ErrTrailingGarbage = errors.New("libdeflate: decompressor: trailing garbage after last member")
 
 var (
 	errorInvalidModeCompressor   = errors.New("libdeflate: compressor: invalid mode")
 	errorInvalidModeDecompressor = errors.New("libdeflate: decompressor: invalid mode")
+	errorInvalidChunkSize        = errors.New("libdeflate: writer: chunk size must be positive")
+	errorWriterClosed            = errors.New("libdeflate: writer: already closed")
+	errorReaderClosed            = errors.New("libdeflate: reader: already closed")
+	errorBadFrame                = errors.New("libdeflate: reader: frame length does not match compressed data")
+	errorShortBuffer             = errors.New("libdeflate: compressor: short buffer")
+	errorNoInput                 = errors.New("libdeflate: empty input")
+	errorInvalidLevel            = errors.New("libdeflate: compressor: illegal compression level")
+	errorGzipHeader              = errors.New("libdeflate: gzip: invalid header")
+	errorZlibHeader              = errors.New("libdeflate: zlib: invalid header")
+	errorGzipHeaderChecksum      = errors.New("libdeflate: gzip: header checksum mismatch")
+	errorGzipChecksum            = errors.New("libdeflate: gzip: checksum or size of decompressed data does not match trailer")
+	errorGzipExtra               = errors.New("libdeflate: gzip: malformed extra field")
+	errorGzipExtraTooLong        = errors.New("libdeflate: gzip: extra field exceeds 65535 bytes")
+	errorGzipNonLatin1           = errors.New("libdeflate: gzip: header string contains NUL or non-Latin-1 characters")
 )
@@ -0,0 +1,8 @@
+package flate
+
+import "errors"
+
+var (
+	errorWriterClosed = errors.New("libdeflate: flate: writer already closed")
+	errorDictionary   = errors.New("libdeflate: flate: preset dictionaries are not supported")
+)
@@ -0,0 +1,87 @@
+package flate
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"testing"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestNewWriter(t *testing.T) {
+	for _, level := range []int{HuffmanOnly, DefaultCompression, NoCompression, BestSpeed, BestCompression, MaxCompression} {
+		if _, err := NewWriter(&bytes.Buffer{}, level); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := NewWriter(&bytes.Buffer{}, MaxCompression+1); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+func TestWriterStdReader(t *testing.T) {
+	in := bytes.Repeat(shortString, 1000)
+
+	for _, level := range []int{HuffmanOnly, NoCompression, DefaultCompression, MaxCompression} {
+		buf := &bytes.Buffer{}
+		w, _ := NewWriter(buf, level)
+		w.Write(in[:100])
+		w.Flush()
+		w.Write(in[100:])
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+
+		out, err := ioutil.ReadAll(flate.NewReader(buf))
+		if err != nil {
+			t.Error(err)
+		}
+		slicesEqual(in, out, t)
+	}
+}
+
+func TestWriterEmpty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf, DefaultCompression)
+	w.Close()
+
+	out, err := ioutil.ReadAll(flate.NewReader(buf))
+	if err != nil || len(out) != 0 {
+		t.Error(err)
+	}
+}
+
+func TestReaderStdWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := flate.NewWriter(buf, flate.BestCompression)
+	w.Write(shortString)
+	w.Close()
+
+	r := NewReader(buf)
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	slicesEqual(shortString, out, t)
+
+	if err := r.(Resetter).Reset(buf, []byte("dict")); err == nil {
+		t.Error("expected error for preset dictionary")
+	}
+}
+
+/*---------------------
+		HELPER
+-----------------------*/
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,84 @@
+package flate
+
+import (
+	"io"
+	"io/ioutil"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// Resetter resets a ReadCloser returned by NewReader to switch to a new underlying Reader.
+// This permits reusing a ReadCloser instead of allocating a new one.
+type Resetter interface {
+	// Reset discards any buffered data and resets the Resetter as if it was
+	// newly initialized with the given reader. Preset dictionaries are not supported, so dict must be empty.
+	Reset(r io.Reader, dict []byte) error
+}
+
+type decompressor struct {
+	r    io.Reader
+	out  []byte
+	done bool
+	err  error
+}
+
+// NewReader returns a new ReadCloser that can be used to read the uncompressed version of r.
+// The whole compressed stream is read from r and decompressed at the first call to Read.
+// It is the caller's responsibility to call Close on the ReadCloser when finished reading.
+//
+// The ReadCloser returned by NewReader also implements Resetter.
+func NewReader(r io.Reader) io.ReadCloser {
+	return &decompressor{r: r}
+}
+
+func (f *decompressor) Read(p []byte) (int, error) {
+	if !f.done {
+		f.out, f.err = inflate(f.r)
+		f.done = true
+	}
+
+	if len(f.out) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		return 0, io.EOF
+	}
+
+	n := copy(p, f.out)
+	f.out = f.out[n:]
+	return n, nil
+}
+
+func (f *decompressor) Close() error {
+	return f.err
+}
+
+func (f *decompressor) Reset(r io.Reader, dict []byte) error {
+	*f = decompressor{r: r}
+	if len(dict) > 0 {
+		f.done = true
+		f.err = errorDictionary
+		return f.err
+	}
+	return nil
+}
+
+// inflate reads all data from r and decompresses it as a raw DEFLATE stream.
+func inflate(r io.Reader) ([]byte, error) {
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(in) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	dc, err := libdeflate.NewDecompressorWithExtendedDecompression(libdeflate.MaxPossibleDecompressionFactor)
+	if err != nil {
+		return nil, err
+	}
+	defer dc.Close()
+
+	_, out, err := dc.DecompressSizeHint(in, 4*len(in), libdeflate.ModeDEFLATE)
+	return out, err
+}
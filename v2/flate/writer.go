@@ -0,0 +1,174 @@
+// Package flate mirrors the API of compress/flate, but compresses and decompresses raw DEFLATE data
+// with libdeflate. Migrating from compress/flate only requires changing the import path.
+//
+// As libdeflate operates on whole buffers, a Writer buffers all data until it is closed
+// and a reader reads its whole source before returning any data.
+// Preset dictionaries are not supported.
+package flate
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// These constants are copied from compress/flate, so they can be used interchangeably.
+const (
+	NoCompression      = 0
+	BestSpeed          = 1
+	BestCompression    = 9
+	DefaultCompression = -1
+
+	// HuffmanOnly is accepted for compatibility. As libdeflate has no Huffman-only mode,
+	// it compresses at BestSpeed instead.
+	HuffmanOnly = -2
+
+	// MaxCompression is the highest level supported by libdeflate. It is not available in compress/flate.
+	MaxCompression = libdeflate.MaxCompressionLevel
+)
+
+// maxStoredBlockSize is the largest amount of data a stored DEFLATE block can hold.
+const maxStoredBlockSize = 1<<16 - 1
+
+// emptyFinalBlock is a static Huffman block containing only the end-of-block symbol with BFINAL set.
+var emptyFinalBlock = []byte{0x03, 0x00}
+
+// A Writer takes data written to it and writes the compressed form of that data to an underlying writer
+// (see NewWriter).
+type Writer struct {
+	w       io.Writer
+	level   int
+	pending []byte
+	closed  bool
+	err     error
+}
+
+// NewWriter returns a new Writer compressing data at the given level.
+// Following compress/flate, the level can be DefaultCompression, NoCompression, HuffmanOnly or any integer
+// value between BestSpeed and BestCompression inclusive. Additionally, levels up to MaxCompression are accepted.
+// The error returned will be nil if the level is valid.
+func NewWriter(w io.Writer, level int) (*Writer, error) {
+	if level < HuffmanOnly || level > MaxCompression {
+		return nil, fmt.Errorf("libdeflate: flate: invalid compression level %d: want value in range [-2, %d]", level, MaxCompression)
+	}
+	return &Writer{w: w, level: level}, nil
+}
+
+// Write writes data to w, which will eventually write the compressed form of data to its underlying writer.
+// The data is buffered until Flush or Close is called.
+func (w *Writer) Write(data []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.closed {
+		return 0, errorWriterClosed
+	}
+	w.pending = append(w.pending, data...)
+	return len(data), nil
+}
+
+// Flush writes all pending data to the underlying writer.
+// As libdeflate cannot continue a DEFLATE stream, the pending data is written uncompressed as stored blocks.
+// Flushing often therefore worsens the compression ratio considerably.
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	if w.closed {
+		return errorWriterClosed
+	}
+
+	w.err = writeStored(w.w, w.pending, false)
+	w.pending = w.pending[:0]
+	return w.err
+}
+
+// Close compresses all pending data, writes it to the underlying writer and ends the DEFLATE stream.
+// It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.closed {
+		return w.err
+	}
+	w.closed = true
+	if w.err != nil {
+		return w.err
+	}
+
+	switch {
+	case w.level == NoCompression:
+		w.err = writeStored(w.w, w.pending, true)
+	case len(w.pending) == 0:
+		_, w.err = w.w.Write(emptyFinalBlock)
+	default:
+		w.err = compress(w.w, w.pending, libdeflateLevel(w.level))
+	}
+	w.pending = w.pending[:0]
+	return w.err
+}
+
+// Reset discards the writer's state and makes it equivalent to the result of NewWriter,
+// but writing to dst instead.
+func (w *Writer) Reset(dst io.Writer) {
+	w.w = dst
+	w.pending = w.pending[:0]
+	w.closed = false
+	w.err = nil
+}
+
+// libdeflateLevel maps a compress/flate compatible compression level to the corresponding libdeflate level.
+func libdeflateLevel(level int) int {
+	switch level {
+	case DefaultCompression:
+		return libdeflate.DefaultCompressionLevel
+	case HuffmanOnly, NoCompression:
+		return libdeflate.MinCompressionLevel
+	default:
+		return level
+	}
+}
+
+func compress(w io.Writer, in []byte, level int) error {
+	c, err := libdeflate.NewCompressorLevel(level)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	n, out, err := c.Compress(in, make([]byte, c.WorstCaseCompressedSize(len(in), libdeflate.ModeDEFLATE)), libdeflate.ModeDEFLATE)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out[:n])
+	return err
+}
+
+// writeStored writes data as stored blocks. If final is set, the last block has BFINAL set,
+// which also produces an empty final block for empty data.
+func writeStored(w io.Writer, data []byte, final bool) error {
+	var hdr [5]byte
+	for len(data) > 0 || final {
+		n := len(data)
+		if n > maxStoredBlockSize {
+			n = maxStoredBlockSize
+		}
+
+		hdr[0] = 0
+		if final && n == len(data) {
+			hdr[0] = 1
+			final = false
+		}
+		binary.LittleEndian.PutUint16(hdr[1:], uint16(n))
+		binary.LittleEndian.PutUint16(hdr[3:], ^uint16(n))
+
+		if _, err := w.Write(hdr[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
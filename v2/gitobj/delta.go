@@ -0,0 +1,81 @@
+package gitobj
+
+// applyDelta reconstructs an object from its base and a delta in Git's format: the sizes of the base and the result
+// as little-endian base-128 varints, followed by instructions copying ranges of the base or inserting literal data.
+func applyDelta(base, delta []byte) ([]byte, error) {
+	baseSize, delta, ok := deltaSize(delta)
+	if !ok || baseSize != len(base) {
+		return nil, errorDelta
+	}
+	size, delta, ok := deltaSize(delta)
+	// every instruction byte inserts at most one byte or copies at most the whole base
+	if !ok || size < 0 || int64(size) > int64(len(delta))*int64(len(base)+1) {
+		return nil, errorDelta
+	}
+
+	// the size is untrusted, so the output only grows as the instructions require
+	capacity := len(base) + len(delta)
+	if size < capacity {
+		capacity = size
+	}
+	out := make([]byte, 0, capacity)
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		if op&0x80 == 0 {
+			// insert the next op bytes; op 0 is reserved
+			n := int(op)
+			if n == 0 || n > len(delta) {
+				return nil, errorDelta
+			}
+			out = append(out, delta[:n]...)
+			delta = delta[n:]
+			continue
+		}
+
+		// copy from the base: bits 0-3 select the present offset bytes, bits 4-6 the present size bytes
+		var offset, n int
+		for i := uint(0); i < 7; i++ {
+			if op&(1<<i) == 0 {
+				continue
+			}
+			if len(delta) == 0 {
+				return nil, errorDelta
+			}
+			if i < 4 {
+				offset |= int(delta[0]) << (8 * i)
+			} else {
+				n |= int(delta[0]) << (8 * (i - 4))
+			}
+			delta = delta[1:]
+		}
+		if n == 0 {
+			n = 0x10000
+		}
+		if offset+n > len(base) || len(out)+n > size {
+			return nil, errorDelta
+		}
+		out = append(out, base[offset:offset+n]...)
+	}
+
+	if len(out) != size {
+		return nil, errorDelta
+	}
+	return out, nil
+}
+
+// deltaSize reads a size from the header of a delta and returns it with the remaining delta.
+func deltaSize(delta []byte) (int, []byte, bool) {
+	size := 0
+	for i, b := range delta {
+		if i == 9 {
+			break
+		}
+		size |= int(b&0x7f) << (7 * uint(i))
+		if b&0x80 == 0 {
+			return size, delta[i+1:], true
+		}
+	}
+	return 0, nil, false
+}
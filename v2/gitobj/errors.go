@@ -0,0 +1,16 @@
+package gitobj
+
+import "errors"
+
+// ErrObjectNotFound is returned if an object is neither present as loose object nor in a pack.
+var ErrObjectNotFound = errors.New("libdeflate: gitobj: object not found")
+
+var (
+	errorHeader     = errors.New("libdeflate: gitobj: malformed object header")
+	errorSize       = errors.New("libdeflate: gitobj: object size does not match header")
+	errorType       = errors.New("libdeflate: gitobj: invalid object type")
+	errorPack       = errors.New("libdeflate: gitobj: malformed pack")
+	errorChecksum   = errors.New("libdeflate: gitobj: pack checksum mismatch")
+	errorDelta      = errors.New("libdeflate: gitobj: malformed delta")
+	errorDeltaDepth = errors.New("libdeflate: gitobj: delta chain too deep or cyclic")
+)
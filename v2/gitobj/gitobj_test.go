@@ -0,0 +1,476 @@
+package gitobj
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestHashObject(t *testing.T) {
+	// known object names, e.g. from git hash-object
+	for data, name := range map[string]string{
+		"":              "e69de29bb2d1d6434b8b29ae775ad8c2e48c5391",
+		"hello world\n": "3b18e512dba79e4c8300dd08aeb37f8e728b8dad",
+	} {
+		if h := HashObject(ObjBlob, []byte(data)); h.String() != name {
+			t.Errorf("hash of %q: %s, expected %s", data, h, name)
+		}
+		if h, err := ParseHash(name); err != nil || h != HashObject(ObjBlob, []byte(data)) {
+			t.Errorf("ParseHash(%s) failed: %v", name, err)
+		}
+	}
+	if _, err := ParseHash("e69de29b"); err == nil {
+		t.Error("expected error for short hash")
+	}
+}
+
+func TestLooseRoundTrip(t *testing.T) {
+	for _, data := range [][]byte{shortString, {}} {
+		comp, h, err := EncodeLoose(ObjBlob, data, libdeflate.DefaultCompressionLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h != HashObject(ObjBlob, data) {
+			t.Error("wrong object name")
+		}
+
+		// the header is part of the zlib stream
+		zr, _ := zlib.NewReader(bytes.NewReader(comp))
+		raw, _ := ioutil.ReadAll(zr)
+		slicesEqual(append([]byte("blob "+strconv.Itoa(len(data))+"\x00"), data...), raw, t)
+
+		typ, out, err := DecodeLoose(comp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != ObjBlob {
+			t.Errorf("decoded type %v", typ)
+		}
+		slicesEqual(data, out, t)
+	}
+
+	if _, _, err := EncodeLoose(ObjOfsDelta, shortString, libdeflate.DefaultCompressionLevel); err != errorType {
+		t.Errorf("expected errorType, got %v", err)
+	}
+	if _, _, err := EncodeLoose(ObjBlob, shortString, 13); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+func TestDecodeLooseErrors(t *testing.T) {
+	for raw, expected := range map[string]error{
+		"blob 3\x00ab":   errorSize,
+		"blob 3":         errorHeader,
+		"blub 2\x00ab":   errorHeader,
+		"blob -2\x00ab":  errorHeader,
+		"blob\x00 2 ab":  errorHeader,
+		"tree x\x00":     errorHeader,
+		"commit 0\x00":   nil,
+		"tag 3\x00abc":   nil,
+		"blob 0\x00\x00": errorSize,
+	} {
+		_, _, err := DecodeLoose(zlibCompress([]byte(raw)))
+		if err != expected {
+			t.Errorf("%q: expected %v, got %v", raw, expected, err)
+		}
+	}
+}
+
+func TestWriteReadLoose(t *testing.T) {
+	dir := tempDir(t)
+	h, err := WriteLoose(dir, ObjBlob, shortString, libdeflate.DefaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := h.String()
+	if _, err := os.Stat(filepath.Join(dir, s[:2], s[2:])); err != nil {
+		t.Fatal(err)
+	}
+	// existing objects are kept
+	if h2, err := WriteLoose(dir, ObjBlob, shortString, libdeflate.MaxCompressionLevel); err != nil || h2 != h {
+		t.Fatalf("rewriting object failed: %v", err)
+	}
+
+	typ, out, err := ReadLoose(dir, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != ObjBlob {
+		t.Errorf("read type %v", typ)
+	}
+	slicesEqual(shortString, out, t)
+
+	if _, _, err := ReadLoose(dir, HashObject(ObjBlob, nil)); err != ErrObjectNotFound {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+	}
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("0123456789abcdef")
+	delta := []byte{16, 12,
+		0x91, 10, 4, // copy 4 bytes from offset 10
+		3, 'x', 'y', 'z', // insert 3 bytes
+		0x90, 5, // copy 5 bytes from offset 0
+	}
+	out, err := applyDelta(base, delta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual([]byte("abcdxyz01234"), out, t)
+
+	// a copy without size bytes copies 0x10000 bytes
+	big := bytes.Repeat(shortString, 0x10000/len(shortString)+1)
+	out, err = applyDelta(big, append(appendDeltaSize(appendDeltaSize(nil, len(big)), 0x10000), 0x80))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(big[:0x10000], out, t)
+
+	for _, d := range [][]byte{
+		{15, 4, 0x90, 4},     // wrong base size
+		{16, 5, 0x90, 4},     // wrong result size
+		{16, 4, 0x91, 14, 4}, // copy beyond the base
+		{16, 4, 0},           // reserved instruction
+		{16, 4, 5, 'a'},      // truncated insert
+		{16, 4, 0x91, 1},     // truncated copy
+		{0x80, 0x80},         // truncated size
+		{16, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x7f, 0x90, 4}, // huge result size
+		{16, 0x80, 0x02, 0x90, 4}, // result size the delta cannot produce
+	} {
+		if _, err := applyDelta(base, d); err != errorDelta {
+			t.Errorf("delta %v: expected errorDelta, got %v", d, err)
+		}
+	}
+}
+
+func TestParsePack(t *testing.T) {
+	base := bytes.Repeat(shortString, 3)
+	second := append(append([]byte{}, base...), "goodbye\n"...)
+	third := []byte("hello, world\ngoodbye\n")
+
+	pb := &packBuilder{}
+	baseOffset := pb.add(ObjBlob, base, 0, Hash{})
+	secondOffset := pb.add(ObjOfsDelta, copyDelta(base, second), baseOffset, Hash{})
+	// a ref delta to the second object, which is a delta itself
+	thirdOffset := pb.add(ObjRefDelta, copyDelta(second, third), 0, HashObject(ObjBlob, second))
+	treeOffset := pb.add(ObjTree, nil, 0, Hash{})
+	data := pb.finish()
+
+	p, err := ParsePack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := p.Entries()
+	if len(entries) != 4 {
+		t.Fatalf("%d entries", len(entries))
+	}
+	if entries[1].Type != ObjOfsDelta || entries[1].BaseOffset != baseOffset || entries[2].Offset != thirdOffset ||
+		entries[2].BaseHash != HashObject(ObjBlob, second) || entries[0].Size != len(base) {
+		t.Errorf("wrong entries: %+v", entries)
+	}
+
+	expected := map[int64][]byte{baseOffset: base, secondOffset: second, thirdOffset: third}
+	for offset, data := range expected {
+		typ, out, err := p.Object(offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if typ != ObjBlob {
+			t.Errorf("type %v at offset %d", typ, offset)
+		}
+		slicesEqual(data, out, t)
+
+		typ, out, err = p.ObjectByHash(HashObject(ObjBlob, data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(data, out, t)
+	}
+	if typ, out, err := p.Object(treeOffset); err != nil || typ != ObjTree || len(out) != 0 {
+		t.Errorf("empty tree: %v %v %v", typ, out, err)
+	}
+
+	hashes, err := p.Hashes()
+	if err != nil || len(hashes) != 4 || hashes[HashObject(ObjBlob, third)] != thirdOffset {
+		t.Errorf("wrong hashes %v: %v", hashes, err)
+	}
+	if _, _, err := p.Object(1); err != ErrObjectNotFound {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+	}
+	if _, _, err := p.ObjectByHash(HashObject(ObjBlob, shortString)); err != ErrObjectNotFound {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+	}
+}
+
+func TestParsePackErrors(t *testing.T) {
+	pb := &packBuilder{}
+	pb.add(ObjBlob, shortString, 0, Hash{})
+	valid := pb.finish()
+
+	corrupt := append([]byte{}, valid...)
+	corrupt[20] ^= 0xff
+	if _, err := ParsePack(corrupt); err != errorChecksum {
+		t.Errorf("expected errorChecksum, got %v", err)
+	}
+
+	// a wrong count makes the entries end early or late
+	for _, count := range []uint32{0, 2} {
+		data := append([]byte{}, valid[:len(valid)-sha1.Size]...)
+		binary.BigEndian.PutUint32(data[8:], count)
+		if _, err := ParsePack(withChecksum(data)); err == nil {
+			t.Errorf("count %d: expected error", count)
+		}
+	}
+
+	// a thin pack: the base of the ref delta is missing
+	pb = &packBuilder{}
+	offset := pb.add(ObjRefDelta, copyDelta(shortString, shortString[:13]), 0, HashObject(ObjBlob, shortString))
+	p, err := ParsePack(pb.finish())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.Object(offset); err != ErrObjectNotFound {
+		t.Errorf("expected ErrObjectNotFound, got %v", err)
+	}
+
+	for _, data := range [][]byte{nil, []byte("PACK"), append([]byte("KCAP"), valid[4:]...)} {
+		if _, err := ParsePack(data); err != errorPack {
+			t.Errorf("expected errorPack, got %v", err)
+		}
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestGitPacks(t *testing.T) {
+	repo := gitRepo(t)
+
+	// git gc writes ofs deltas, pack-objects without --delta-base-offset ref deltas
+	git(t, repo, nil, "gc", "--quiet", "--aggressive")
+	packs, _ := filepath.Glob(filepath.Join(repo, ".git", "objects", "pack", "*.pack"))
+	if len(packs) != 1 {
+		t.Fatalf("%d packs", len(packs))
+	}
+	ofs, err := ioutil.ReadFile(packs[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := git(t, repo, nil, "rev-list", "--objects", "--all")
+	ref := git(t, repo, []byte(list), "pack-objects", "--stdout", "--window=50")
+
+	for name, data := range map[string][]byte{"ofs": ofs, "ref": []byte(ref)} {
+		p, err := ParsePack(data)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		deltas := 0
+		for _, e := range p.Entries() {
+			if e.Type == ObjOfsDelta || e.Type == ObjRefDelta {
+				deltas++
+			}
+		}
+		if deltas == 0 {
+			t.Errorf("%s: pack without deltas", name)
+		}
+
+		hashes, err := p.Hashes()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if len(hashes) != len(p.Entries()) {
+			t.Errorf("%s: %d of %d objects hashed", name, len(hashes), len(p.Entries()))
+		}
+		for h := range hashes {
+			typ, out, err := p.ObjectByHash(h)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if typ.String() != strings.TrimSpace(git(t, repo, nil, "cat-file", "-t", h.String())) {
+				t.Errorf("%s: wrong type %v of %s", name, typ, h)
+			}
+			slicesEqual([]byte(git(t, repo, nil, "cat-file", typ.String(), h.String())), out, t)
+		}
+	}
+}
+
+func TestGitLooseObjects(t *testing.T) {
+	repo := gitRepo(t)
+	objects := filepath.Join(repo, ".git", "objects")
+
+	h, err := WriteLoose(objects, ObjBlob, shortString, libdeflate.MaxCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(shortString, []byte(git(t, repo, nil, "cat-file", "blob", h.String())), t)
+
+	// read loose objects written by git
+	name := strings.TrimSpace(git(t, repo, []byte("from git\n"), "hash-object", "-w", "--stdin"))
+	h, _ = ParseHash(name)
+	typ, out, err := ReadLoose(objects, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if typ != ObjBlob {
+		t.Errorf("read type %v", typ)
+	}
+	slicesEqual([]byte("from git\n"), out, t)
+}
+
+// gitRepo creates a repository with a few commits of similar files, skipping the test if git is unavailable.
+func gitRepo(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+	repo := tempDir(t)
+	git(t, repo, nil, "init", "--quiet")
+	for i := 0; i < 5; i++ {
+		content := bytes.Repeat(shortString, 10+i)
+		content = append(content, strconv.Itoa(i)...)
+		ioutil.WriteFile(filepath.Join(repo, "file.txt"), content, 0644)
+		ioutil.WriteFile(filepath.Join(repo, "other.txt"), bytes.Repeat([]byte(strconv.Itoa(i)), 100), 0644)
+		git(t, repo, nil, "add", ".")
+		git(t, repo, nil, "commit", "--quiet", "-m", "commit "+strconv.Itoa(i))
+	}
+	return repo
+}
+
+func git(t *testing.T, repo string, stdin []byte, args ...string) string {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repo
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com", "GIT_CONFIG_NOSYSTEM=1", "HOME="+repo)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+	}
+	return string(out)
+}
+
+// packBuilder builds packs entry by entry, compressed with compress/zlib.
+type packBuilder struct {
+	buf   bytes.Buffer
+	count uint32
+}
+
+func (pb *packBuilder) add(typ ObjectType, data []byte, baseOffset int64, baseHash Hash) int64 {
+	if pb.buf.Len() == 0 {
+		pb.buf.WriteString("PACK\x00\x00\x00\x02\x00\x00\x00\x00")
+	}
+	offset := int64(pb.buf.Len())
+	pb.count++
+
+	size := len(data)
+	b := byte(typ)<<4 | byte(size&0x0f)
+	for size >>= 4; size > 0; size >>= 7 {
+		pb.buf.WriteByte(b | 0x80)
+		b = byte(size & 0x7f)
+	}
+	pb.buf.WriteByte(b)
+
+	switch typ {
+	case ObjOfsDelta:
+		dist := offset - baseOffset
+		enc := []byte{byte(dist & 0x7f)}
+		for dist >>= 7; dist > 0; dist >>= 7 {
+			dist--
+			enc = append([]byte{byte(dist&0x7f) | 0x80}, enc...)
+		}
+		pb.buf.Write(enc)
+	case ObjRefDelta:
+		pb.buf.Write(baseHash[:])
+	}
+	pb.buf.Write(zlibCompress(data))
+	return offset
+}
+
+func (pb *packBuilder) finish() []byte {
+	data := pb.buf.Bytes()
+	binary.BigEndian.PutUint32(data[8:], pb.count)
+	return withChecksum(data)
+}
+
+func withChecksum(data []byte) []byte {
+	sum := sha1.Sum(data)
+	return append(data, sum[:]...)
+}
+
+// copyDelta returns a delta creating target from base by copying the common prefix and inserting the rest.
+func copyDelta(base, target []byte) []byte {
+	n := 0
+	for n < len(base) && n < len(target) && base[n] == target[n] && n < 0xffff {
+		n++
+	}
+	d := appendDeltaSize(appendDeltaSize(nil, len(base)), len(target))
+	if n > 0 {
+		d = append(d, 0x90|0x20, byte(n), byte(n>>8))
+	}
+	for rest := target[n:]; len(rest) > 0; {
+		k := len(rest)
+		if k > 0x7f {
+			k = 0x7f
+		}
+		d = append(append(d, byte(k)), rest[:k]...)
+		rest = rest[k:]
+	}
+	return d
+}
+
+func appendDeltaSize(d []byte, size int) []byte {
+	for size >= 0x80 {
+		d = append(d, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(d, byte(size))
+}
+
+func zlibCompress(data []byte) []byte {
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "gitobj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// loose objects are read-only
+		filepath.Walk(dir, func(path string, _ os.FileInfo, _ error) error {
+			os.Chmod(path, 0755)
+			return nil
+		})
+		os.RemoveAll(dir)
+	})
+	return dir
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
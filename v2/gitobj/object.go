@@ -0,0 +1,207 @@
+// Package gitobj reads and writes Git loose objects and decodes Git packfiles, (de)compressing them with libdeflate.
+//
+// Loose objects are stored as the zlib compressed header "<type> <size>\x00" followed by the content.
+// Pack entries state the exact size of their decompressed data, so they are decompressed into buffers of
+// the exact size, and the number of consumed bytes reported by the Decompressor locates the next entry.
+package gitobj
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// ObjectType is the type of a Git object, numbered like in packfiles.
+type ObjectType int
+
+// Object types. ObjOfsDelta and ObjRefDelta only occur as the type of pack entries.
+const (
+	ObjCommit   ObjectType = 1
+	ObjTree     ObjectType = 2
+	ObjBlob     ObjectType = 3
+	ObjTag      ObjectType = 4
+	ObjOfsDelta ObjectType = 6
+	ObjRefDelta ObjectType = 7
+)
+
+var typeNames = map[ObjectType]string{
+	ObjCommit:   "commit",
+	ObjTree:     "tree",
+	ObjBlob:     "blob",
+	ObjTag:      "tag",
+	ObjOfsDelta: "ofs-delta",
+	ObjRefDelta: "ref-delta",
+}
+
+// String returns the name of the type as used in object headers, e.g. "blob".
+func (t ObjectType) String() string {
+	if name, ok := typeNames[t]; ok {
+		return name
+	}
+	return "ObjectType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// valid reports whether t is the type of an object, as opposed to a delta.
+func (t ObjectType) valid() bool {
+	return t >= ObjCommit && t <= ObjTag
+}
+
+// Hash is the SHA-1 object name of a Git object.
+type Hash [sha1.Size]byte
+
+// ParseHash parses a hexadecimal object name.
+func ParseHash(s string) (Hash, error) {
+	var h Hash
+	if len(s) != 2*len(h) {
+		return Hash{}, hex.ErrLength
+	}
+	_, err := hex.Decode(h[:], []byte(s))
+	return h, err
+}
+
+// String returns the hexadecimal object name.
+func (h Hash) String() string {
+	return hex.EncodeToString(h[:])
+}
+
+// HashObject returns the object name of an object of the given type and content.
+func HashObject(typ ObjectType, data []byte) Hash {
+	s := sha1.New()
+	s.Write(header(typ, len(data)))
+	s.Write(data)
+	var h Hash
+	s.Sum(h[:0])
+	return h
+}
+
+// compressors and decompressors are shared by all encoders and decoders of this package.
+// Objects may compress extremely well, so the maximum decompression factor is used.
+var compressors, _ = libdeflate.NewCompressorPool(libdeflate.PoolOptions{})
+
+var decompressors, _ = libdeflate.NewDecompressorPool(libdeflate.PoolOptions{}, libdeflate.DecompressorOptions{
+	MaxDecompressionFactor: libdeflate.MaxPossibleDecompressionFactor,
+})
+
+// EncodeLoose returns the loose object representation of an object of the given type and content,
+// compressed at the given level, and its object name.
+// Errors if an invalid type or an invalid compression level was passed.
+func EncodeLoose(typ ObjectType, data []byte, level int) ([]byte, Hash, error) {
+	if !typ.valid() {
+		return nil, Hash{}, errorType
+	}
+	c, err := compressors.Get(level)
+	if err != nil {
+		return nil, Hash{}, err
+	}
+	defer compressors.Put(c)
+
+	// the out buffer is sized for the worst case, as tiny objects may grow
+	raw := append(header(typ, len(data)), data...)
+	_, comp, err := c.Compress(raw, make([]byte, c.WorstCaseCompressedSize(len(raw), libdeflate.ModeZlib)), libdeflate.ModeZlib)
+	if err != nil {
+		return nil, Hash{}, err
+	}
+	return comp, HashObject(typ, data), nil
+}
+
+// DecodeLoose decompresses a loose object and returns its type and content.
+// Errors if the header is malformed or does not match the content.
+func DecodeLoose(comp []byte) (ObjectType, []byte, error) {
+	dc, err := decompressors.Get()
+	if err != nil {
+		return 0, nil, err
+	}
+	_, raw, err := dc.Decompress(comp, nil, libdeflate.ModeZlib)
+	decompressors.Put(dc)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	sp := bytes.IndexByte(raw, ' ')
+	nul := bytes.IndexByte(raw, 0)
+	if sp < 0 || nul < sp {
+		return 0, nil, errorHeader
+	}
+	typ := parseType(string(raw[:sp]))
+	size, err := strconv.Atoi(string(raw[sp+1 : nul]))
+	if !typ.valid() || err != nil || size < 0 {
+		return 0, nil, errorHeader
+	}
+	if size != len(raw)-nul-1 {
+		return 0, nil, errorSize
+	}
+	return typ, raw[nul+1:], nil
+}
+
+// WriteLoose stores an object of the given type and content as loose object in the objects directory
+// of a repository (usually .git/objects), compressed at the given level, and returns its object name.
+// Like Git, existing objects are not rewritten. The object is written to a temporary file first and renamed.
+func WriteLoose(objectsDir string, typ ObjectType, data []byte, level int) (Hash, error) {
+	comp, h, err := EncodeLoose(typ, data, level)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	name := loosePath(objectsDir, h)
+	if _, err := os.Stat(name); err == nil {
+		return h, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return Hash{}, err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(name), "tmp_obj_")
+	if err != nil {
+		return Hash{}, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(comp); err != nil {
+		tmp.Close()
+		return Hash{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return Hash{}, err
+	}
+	// loose objects are read-only
+	if err := os.Chmod(tmp.Name(), 0444); err != nil {
+		return Hash{}, err
+	}
+	return h, os.Rename(tmp.Name(), name)
+}
+
+// ReadLoose reads the loose object h from the objects directory of a repository (usually .git/objects)
+// and returns its type and content. Errors with ErrObjectNotFound if there is no such loose object.
+func ReadLoose(objectsDir string, h Hash) (ObjectType, []byte, error) {
+	comp, err := ioutil.ReadFile(loosePath(objectsDir, h))
+	if os.IsNotExist(err) {
+		return 0, nil, ErrObjectNotFound
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	return DecodeLoose(comp)
+}
+
+func loosePath(objectsDir string, h Hash) string {
+	s := h.String()
+	return filepath.Join(objectsDir, s[:2], s[2:])
+}
+
+// header returns the header preceding the content of an object for hashing and in loose objects.
+func header(typ ObjectType, size int) []byte {
+	return []byte(typ.String() + " " + strconv.Itoa(size) + "\x00")
+}
+
+func parseType(name string) ObjectType {
+	for typ, n := range typeNames {
+		if n == name && typ.valid() {
+			return typ
+		}
+	}
+	return 0
+}
@@ -0,0 +1,320 @@
+package gitobj
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"sync"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// maxDeltaDepth is the longest delta chain that is resolved, matching the maximum depth Git creates.
+const maxDeltaDepth = 4095
+
+// maxCacheSize is the maximum total size of the resolved delta bases cached by a Pack.
+const maxCacheSize = 32 << 20
+
+// Entry describes an entry of a pack.
+type Entry struct {
+	// Offset is the position of the entry within the pack.
+	Offset int64
+	// Type is the type of the object, or ObjOfsDelta or ObjRefDelta if the entry is stored as delta.
+	Type ObjectType
+	// Size is the size of the decompressed entry: the object, or the delta for deltas.
+	Size int
+	// BaseOffset is the offset of the base of an ObjOfsDelta entry.
+	BaseOffset int64
+	// BaseHash is the object name of the base of an ObjRefDelta entry.
+	BaseHash Hash
+
+	data []byte // compressed data
+}
+
+// Pack is a Git packfile held in memory. It is safe for concurrent use.
+type Pack struct {
+	entries []Entry
+	offsets map[int64]int
+
+	mu          sync.Mutex
+	hasRefDelta bool
+	hashes      map[Hash]int // built on first use
+	cache       map[int]object
+	cacheSize   int
+}
+
+type object struct {
+	typ  ObjectType
+	data []byte
+}
+
+// ParsePack parses a packfile (version 2 or 3) and verifies its checksum. data must not be modified afterwards.
+//
+// The entries are located by decompressing each of them into a buffer of the size stated in its header,
+// as only the number of consumed bytes tells where the next entry starts.
+// Errors if the pack is malformed or any entry fails to decompress.
+func ParsePack(data []byte) (*Pack, error) {
+	if len(data) < 12+sha1.Size || string(data[:4]) != "PACK" {
+		return nil, errorPack
+	}
+	if v := binary.BigEndian.Uint32(data[4:]); v != 2 && v != 3 {
+		return nil, errorPack
+	}
+	body := data[:len(data)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], data[len(body):]) {
+		return nil, errorChecksum
+	}
+
+	count := binary.BigEndian.Uint32(data[8:])
+	// every entry takes at least 2 bytes of header and 2 bytes of compressed data
+	if uint64(count) > uint64(len(body))/4 {
+		return nil, errorPack
+	}
+	p := &Pack{
+		entries: make([]Entry, count),
+		offsets: make(map[int64]int, count),
+	}
+
+	dc, err := decompressors.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer decompressors.Put(dc)
+
+	pos := 12
+	for i := range p.entries {
+		e, n, err := parseEntryHeader(body, pos)
+		if err != nil {
+			return nil, err
+		}
+		if e.Type == ObjRefDelta {
+			p.hasRefDelta = true
+		}
+
+		in := body[pos+n:]
+		if len(in) == 0 || e.Size > len(in)*libdeflate.MaxPossibleDecompressionFactor {
+			return nil, errorPack
+		}
+		consumed, _, err := dc.Decompress(in, make([]byte, e.Size), libdeflate.ModeZlib)
+		if err != nil {
+			return nil, err
+		}
+		e.data = in[:consumed]
+
+		p.entries[i] = e
+		p.offsets[e.Offset] = i
+		pos += n + consumed
+	}
+	if pos != len(body) {
+		return nil, errorPack
+	}
+	return p, nil
+}
+
+// parseEntryHeader parses the header of the entry at pos and returns the entry and the length of the header.
+func parseEntryHeader(body []byte, pos int) (Entry, int, error) {
+	e := Entry{Offset: int64(pos)}
+	n := 0
+	next := func() (byte, bool) {
+		if pos+n >= len(body) {
+			return 0, false
+		}
+		n++
+		return body[pos+n-1], true
+	}
+
+	// type and size: 3 bits of type and 4 bits of size, followed by 7 bits of size per byte
+	b, ok := next()
+	if !ok {
+		return Entry{}, 0, errorPack
+	}
+	e.Type = ObjectType(b >> 4 & 7)
+	size := uint64(b & 0x0f)
+	for shift := uint(4); b&0x80 != 0; shift += 7 {
+		if b, ok = next(); !ok || shift > 56 {
+			return Entry{}, 0, errorPack
+		}
+		size |= uint64(b&0x7f) << shift
+	}
+	if size > uint64(len(body))*libdeflate.MaxPossibleDecompressionFactor {
+		return Entry{}, 0, errorPack
+	}
+	e.Size = int(size)
+
+	switch {
+	case e.Type == ObjOfsDelta:
+		// big-endian offset back to the base, adding one per continuation to avoid redundant encodings
+		b, ok := next()
+		if !ok {
+			return Entry{}, 0, errorPack
+		}
+		dist := int64(b & 0x7f)
+		for b&0x80 != 0 {
+			if b, ok = next(); !ok || dist >= 1<<55 {
+				return Entry{}, 0, errorPack
+			}
+			dist = (dist+1)<<7 | int64(b&0x7f)
+		}
+		if dist <= 0 || dist > e.Offset {
+			return Entry{}, 0, errorPack
+		}
+		e.BaseOffset = e.Offset - dist
+	case e.Type == ObjRefDelta:
+		if pos+n+len(e.BaseHash) > len(body) {
+			return Entry{}, 0, errorPack
+		}
+		n += copy(e.BaseHash[:], body[pos+n:])
+	case !e.Type.valid():
+		return Entry{}, 0, errorType
+	}
+	return e, n, nil
+}
+
+// Entries returns the entries of the pack in the order they are stored. The slice must not be modified.
+func (p *Pack) Entries() []Entry {
+	return p.entries
+}
+
+// Object returns the type and content of the object stored at offset, resolving deltas.
+// If the pack contains ref deltas, all objects are resolved on first use to hash them, like for ObjectByHash.
+// Errors with ErrObjectNotFound if there is no entry at offset or the base of a delta is not part of the pack.
+func (p *Pack) Object(offset int64) (ObjectType, []byte, error) {
+	i, ok := p.offsets[offset]
+	if !ok {
+		return 0, nil, ErrObjectNotFound
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.hasRefDelta {
+		if err := p.buildIndex(); err != nil {
+			return 0, nil, err
+		}
+	}
+	return p.resolve(i, 0)
+}
+
+// ObjectByHash returns the type and content of the object h, resolving deltas.
+// On first use, all objects of the pack are resolved to hash them.
+// Errors with ErrObjectNotFound if the object is not part of the pack.
+func (p *Pack) ObjectByHash(h Hash) (ObjectType, []byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.buildIndex(); err != nil {
+		return 0, nil, err
+	}
+	i, ok := p.hashes[h]
+	if !ok {
+		return 0, nil, ErrObjectNotFound
+	}
+	return p.resolve(i, 0)
+}
+
+// Hashes returns the object names of all objects in the pack, mapped to their offsets.
+func (p *Pack) Hashes() (map[Hash]int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.buildIndex(); err != nil {
+		return nil, err
+	}
+	m := make(map[Hash]int64, len(p.hashes))
+	for h, i := range p.hashes {
+		m[h] = p.entries[i].Offset
+	}
+	return m, nil
+}
+
+// buildIndex hashes all objects. Objects whose delta chain contains a ref delta can only be resolved once the base
+// is hashed, so the entries are resolved in passes until no more progress is made.
+func (p *Pack) buildIndex() error {
+	if p.hashes != nil {
+		return nil
+	}
+	hashes := make(map[Hash]int, len(p.entries))
+	p.hashes = hashes
+
+	pending := make([]int, len(p.entries))
+	for i := range pending {
+		pending[i] = i
+	}
+	for len(pending) > 0 {
+		var unresolved []int
+		for _, i := range pending {
+			typ, data, err := p.resolve(i, 0)
+			if err == ErrObjectNotFound {
+				unresolved = append(unresolved, i)
+				continue
+			}
+			if err != nil {
+				p.hashes = nil
+				return err
+			}
+			hashes[HashObject(typ, data)] = i
+		}
+		if len(unresolved) == len(pending) {
+			// the bases are missing, e.g. in a thin pack
+			break
+		}
+		pending = unresolved
+	}
+	return nil
+}
+
+// resolve decompresses entry i and applies its deltas. depth is the number of deltas depending on this entry.
+func (p *Pack) resolve(i, depth int) (ObjectType, []byte, error) {
+	e := &p.entries[i]
+	dc, err := decompressors.Get()
+	if err != nil {
+		return 0, nil, err
+	}
+	_, data, err := dc.Decompress(e.data, make([]byte, e.Size), libdeflate.ModeZlib)
+	decompressors.Put(dc)
+	if err != nil {
+		return 0, nil, err
+	}
+	if e.Type.valid() {
+		return e.Type, data, nil
+	}
+
+	if depth >= maxDeltaDepth {
+		return 0, nil, errorDeltaDepth
+	}
+	var base int
+	var ok bool
+	if e.Type == ObjOfsDelta {
+		base, ok = p.offsets[e.BaseOffset]
+	} else {
+		base, ok = p.hashes[e.BaseHash]
+	}
+	if !ok {
+		return 0, nil, ErrObjectNotFound
+	}
+
+	obj, ok := p.cache[base]
+	if !ok {
+		if obj.typ, obj.data, err = p.resolve(base, depth+1); err != nil {
+			return 0, nil, err
+		}
+		p.cacheBase(base, obj)
+	}
+
+	data, err = applyDelta(obj.data, data)
+	if err != nil {
+		return 0, nil, err
+	}
+	return obj.typ, data, nil
+}
+
+// cacheBase caches a resolved delta base, as bases are usually shared by several deltas.
+// The cache is dropped when it grows too large.
+func (p *Pack) cacheBase(i int, obj object) {
+	if len(obj.data) > maxCacheSize/4 {
+		return
+	}
+	if p.cache == nil || p.cacheSize+len(obj.data) > maxCacheSize {
+		p.cache = make(map[int]object)
+		p.cacheSize = 0
+	}
+	p.cache[i] = obj
+	p.cacheSize += len(obj.data)
+}
@@ -0,0 +1,350 @@
+package libdeflate
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+const (
+	gzipID1         = 0x1f
+	gzipID2         = 0x8b
+	gzipCMDeflate   = 8
+	gzipHeaderSize  = 10
+	gzipTrailerSize = 8
+
+	gzipFlagText    = 1 << 0
+	gzipFlagHdrCrc  = 1 << 1
+	gzipFlagExtra   = 1 << 2
+	gzipFlagName    = 1 << 3
+	gzipFlagComment = 1 << 4
+)
+
+// Operating systems that can be stored in GzipHeader.OS, as specified by RFC 1952.
+const (
+	GzipOSFAT     byte = 0
+	GzipOSUnix    byte = 3
+	GzipOSMacOS   byte = 7
+	GzipOSNTFS    byte = 11
+	GzipOSUnknown byte = 255
+)
+
+// GzipHeader holds the metadata of a gzip member as specified by RFC 1952.
+//
+// Name and Comment must be UTF-8 encoded and may only contain Unicode code points
+// U+0001 through U+00FF, due to limitations of the gzip format.
+// The zero value of ModTime is stored as MTIME 0 (no timestamp), which is useful for reproducible output.
+type GzipHeader struct {
+	Name       string    // FNAME: original file name
+	Comment    string    // FCOMMENT: file comment
+	ModTime    time.Time // MTIME: modification time, truncated to seconds
+	Extra      []byte    // FEXTRA: raw extra field, see ExtraFields and AddExtraField. Written if not nil
+	OS         byte      // OS: operating system the data was compressed on, e.g. GzipOSUnix
+	ExtraFlags byte      // XFL: if 0, CompressGzipWithHeader derives it from the compression level
+	Text       bool      // FTEXT: data is probably ASCII text
+	HeaderCRC  bool      // FHCRC: header is protected by a CRC16
+}
+
+// GzipExtraField is a subfield of the FEXTRA field of a gzip header.
+type GzipExtraField struct {
+	ID   [2]byte // SI1, SI2: subfield ID
+	Data []byte
+}
+
+// GzipTrailer holds the checksum and the size of the decompressed data of a gzip member.
+type GzipTrailer struct {
+	CRC32 uint32 // CRC32 of the decompressed data
+	ISize uint32 // ISIZE: size of the decompressed data modulo 2^32
+}
+
+// ExtraFields parses the Extra field of h into its subfields.
+// Errors if the Extra field is not a valid sequence of subfields.
+func (h *GzipHeader) ExtraFields() ([]GzipExtraField, error) {
+	var fields []GzipExtraField
+	extra := h.Extra
+	for len(extra) > 0 {
+		if len(extra) < 4 {
+			return nil, errorGzipExtra
+		}
+		n := int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+n {
+			return nil, errorGzipExtra
+		}
+		fields = append(fields, GzipExtraField{ID: [2]byte{extra[0], extra[1]}, Data: extra[4 : 4+n]})
+		extra = extra[4+n:]
+	}
+	return fields, nil
+}
+
+// AddExtraField appends a subfield with the given id and data to the Extra field of h.
+// Errors if the Extra field would exceed its maximum size of 65535 bytes.
+func (h *GzipHeader) AddExtraField(id [2]byte, data []byte) error {
+	if len(h.Extra)+4+len(data) > 0xffff {
+		return errorGzipExtraTooLong
+	}
+
+	var hdr [4]byte
+	hdr[0], hdr[1] = id[0], id[1]
+	binary.LittleEndian.PutUint16(hdr[2:], uint16(len(data)))
+	h.Extra = append(append(h.Extra, hdr[:]...), data...)
+	return nil
+}
+
+// MarshalBinary encodes h as a gzip member header.
+func (h GzipHeader) MarshalBinary() ([]byte, error) {
+	hdr := make([]byte, gzipHeaderSize, gzipHeaderSize+2+len(h.Extra)+len(h.Name)+len(h.Comment)+4)
+	hdr[0] = gzipID1
+	hdr[1] = gzipID2
+	hdr[2] = gzipCMDeflate
+	if h.ModTime.After(time.Unix(0, 0)) {
+		binary.LittleEndian.PutUint32(hdr[4:8], uint32(h.ModTime.Unix()))
+	}
+	hdr[8] = h.ExtraFlags
+	hdr[9] = h.OS
+
+	if h.Text {
+		hdr[3] |= gzipFlagText
+	}
+	if h.Extra != nil {
+		if len(h.Extra) > 0xffff {
+			return nil, errorGzipExtraTooLong
+		}
+		hdr[3] |= gzipFlagExtra
+		hdr = append(hdr, byte(len(h.Extra)), byte(len(h.Extra)>>8))
+		hdr = append(hdr, h.Extra...)
+	}
+
+	var err error
+	if h.Name != "" {
+		hdr[3] |= gzipFlagName
+		if hdr, err = appendLatin1(hdr, h.Name); err != nil {
+			return nil, err
+		}
+	}
+	if h.Comment != "" {
+		hdr[3] |= gzipFlagComment
+		if hdr, err = appendLatin1(hdr, h.Comment); err != nil {
+			return nil, err
+		}
+	}
+
+	if h.HeaderCRC {
+		hdr[3] |= gzipFlagHdrCrc
+		crc := Crc32(0, hdr)
+		hdr = append(hdr, byte(crc), byte(crc>>8))
+	}
+	return hdr, nil
+}
+
+// ParseGzipHeader parses the gzip member header at the beginning of in
+// and returns it along with its size in bytes.
+// Errors with io.ErrUnexpectedEOF if in ends within the header.
+func ParseGzipHeader(in []byte) (GzipHeader, int, error) {
+	var h GzipHeader
+	if len(in) < gzipHeaderSize {
+		return h, 0, io.ErrUnexpectedEOF
+	}
+	if in[0] != gzipID1 || in[1] != gzipID2 || in[2] != gzipCMDeflate {
+		return h, 0, errorGzipHeader
+	}
+
+	flg := in[3]
+	if t := int64(binary.LittleEndian.Uint32(in[4:8])); t > 0 {
+		h.ModTime = time.Unix(t, 0)
+	}
+	h.ExtraFlags = in[8]
+	h.OS = in[9]
+	h.Text = flg&gzipFlagText != 0
+	h.HeaderCRC = flg&gzipFlagHdrCrc != 0
+
+	n := gzipHeaderSize
+	if flg&gzipFlagExtra != 0 {
+		if len(in) < n+2 {
+			return h, 0, io.ErrUnexpectedEOF
+		}
+		xlen := int(binary.LittleEndian.Uint16(in[n:]))
+		n += 2
+		if len(in) < n+xlen {
+			return h, 0, io.ErrUnexpectedEOF
+		}
+		h.Extra = append([]byte{}, in[n:n+xlen]...)
+		n += xlen
+	}
+
+	var err error
+	if flg&gzipFlagName != 0 {
+		if h.Name, n, err = readLatin1(in, n); err != nil {
+			return h, 0, err
+		}
+	}
+	if flg&gzipFlagComment != 0 {
+		if h.Comment, n, err = readLatin1(in, n); err != nil {
+			return h, 0, err
+		}
+	}
+
+	if h.HeaderCRC {
+		if len(in) < n+2 {
+			return h, 0, io.ErrUnexpectedEOF
+		}
+		if binary.LittleEndian.Uint16(in[n:]) != uint16(Crc32(0, in[:n])) {
+			return h, 0, errorGzipHeaderChecksum
+		}
+		n += 2
+	}
+	return h, n, nil
+}
+
+// CompressGzipWithHeader compresses the data from in to out as a gzip member with the given header
+// and returns the number of bytes written to out, out (sliced to written) or an error if the out buffer was too short.
+// If you pass nil for out, this function will allocate a fitting buffer and return it.
+//
+// Unlike Compress with ModeGzip, which always writes a minimal header, all fields of h are written.
+// If h.ExtraFlags is 0, it is derived from the level of this Compressor.
+func (c Compressor) CompressGzipWithHeader(in, out []byte, h GzipHeader) (int, []byte, error) {
+	if h.ExtraFlags == 0 {
+		h.ExtraFlags = GzipExtraFlags(c.lvl)
+	}
+	hdr, err := h.MarshalBinary()
+	if err != nil {
+		return 0, out, err
+	}
+
+	if out == nil {
+		out = make([]byte, len(hdr)+c.WorstCaseCompressedSize(len(in), ModeDEFLATE)+gzipTrailerSize)
+		n, out, err := c.CompressGzipWithHeader(in, out, h)
+		if err != nil {
+			return n, out, err
+		}
+		return n, append([]byte{}, out...), nil
+	}
+
+	if len(out) < len(hdr)+gzipTrailerSize {
+		return 0, out, errorShortBuffer
+	}
+	copy(out, hdr)
+	n, _, err := c.Compress(in, out[len(hdr):len(out)-gzipTrailerSize], ModeDEFLATE)
+	if err != nil {
+		return 0, out, err
+	}
+
+	n += len(hdr)
+	binary.LittleEndian.PutUint32(out[n:], Crc32(0, in))
+	binary.LittleEndian.PutUint32(out[n+4:], uint32(len(in)))
+	n += gzipTrailerSize
+	return n, out[:n], nil
+}
+
+// DecompressGzipWithHeader decompresses the gzip member at the beginning of in to out and returns
+// the number of consumed bytes c from 'in', 'out', the parsed header and trailer of the member
+// or an error if something went wrong.
+//
+// Unlike Decompress with ModeGzip, which discards the header, all header fields are parsed.
+// The CRC32 and ISIZE of the trailer are verified against the decompressed data.
+//
+// If you pass a buffer to out, the size of this buffer must exactly match the length of the decompressed data.
+// If you pass nil to out, this function will allocate a sufficient buffer and return it.
+//
+// If error != nil, the data in out is undefined.
+func (dc Decompressor) DecompressGzipWithHeader(in, out []byte) (int, []byte, GzipHeader, GzipTrailer, error) {
+	return dc.decompressGzipWithHeader(in, out, 0)
+}
+
+// decompressGzipWithHeader is DecompressGzipWithHeader, but if out is nil and sizeHint > 0, the output buffer starts at
+// sizeHint bytes like in DecompressSizeHint instead of the ISIZE at the end of in, which is of no use if in holds many members.
+func (dc Decompressor) decompressGzipWithHeader(in, out []byte, sizeHint int) (int, []byte, GzipHeader, GzipTrailer, error) {
+	var t GzipTrailer
+	h, n, err := ParseGzipHeader(in)
+	if err != nil {
+		return 0, out, h, t, err
+	}
+	if len(in) == n {
+		return 0, out, h, t, io.ErrUnexpectedEOF
+	}
+
+	var c int
+	if out == nil && sizeHint > 0 {
+		c, out, err = dc.DecompressSizeHint(in[n:], sizeHint, ModeDEFLATE)
+	} else if out == nil {
+		c, out, err = dc.decompressExpectedSize(in[n:], gzipISize(in), ModeDEFLATE)
+		if err == nil && n+c+gzipTrailerSize != len(in) {
+			// the buffer was sized from the ISIZE of a later member
+			out = trimCap(out)
+		}
+	} else {
+		c, out, err = dc.Decompress(in[n:], out, ModeDEFLATE)
+	}
+	if err != nil {
+		return 0, out, h, t, err
+	}
+	n += c
+
+	if len(in) < n+gzipTrailerSize {
+		return 0, out, h, t, io.ErrUnexpectedEOF
+	}
+	t.CRC32 = binary.LittleEndian.Uint32(in[n:])
+	t.ISize = binary.LittleEndian.Uint32(in[n+4:])
+	if t.CRC32 != Crc32(0, out) || t.ISize != uint32(len(out)) {
+		return 0, out, h, t, errorGzipChecksum
+	}
+	return n + gzipTrailerSize, out, h, t, nil
+}
+
+// gzipISize returns the ISIZE field of the trailer, assuming in ends with a gzip member,
+// or -1 if in is too short to be a gzip member.
+// The value has to be validated by decompressing, as in may be followed by other data.
+func gzipISize(in []byte) int {
+	if len(in) < gzipHeaderSize+gzipTrailerSize {
+		return -1
+	}
+	return int(binary.LittleEndian.Uint32(in[len(in)-4:]))
+}
+
+// trimCap returns out in a buffer of its own length, so that a larger buffer is not retained by the caller.
+func trimCap(out []byte) []byte {
+	if cap(out) == len(out) {
+		return out
+	}
+	trimmed := make([]byte, len(out))
+	copy(trimmed, out)
+	return trimmed
+}
+
+// GzipExtraFlags returns the XFL value of a gzip header for data compressed at the given level, following gzip:
+// 2 for the maximum compression of zlib and above, 4 for the fastest level and 0 otherwise.
+func GzipExtraFlags(level int) byte {
+	switch {
+	case level >= MaxStdZlibCompressionLevel:
+		return 2
+	case level == MinCompressionLevel:
+		return 4
+	default:
+		return 0
+	}
+}
+
+// appendLatin1 appends s as a zero-terminated ISO 8859-1 (Latin-1) string to b.
+func appendLatin1(b []byte, s string) ([]byte, error) {
+	for _, r := range s {
+		if r == 0 || r > 0xff {
+			return nil, errorGzipNonLatin1
+		}
+		b = append(b, byte(r))
+	}
+	return append(b, 0), nil
+}
+
+// readLatin1 reads a zero-terminated ISO 8859-1 (Latin-1) string starting at in[n:].
+// It returns the string and the offset behind the terminating zero.
+func readLatin1(in []byte, n int) (string, int, error) {
+	for i := n; i < len(in); i++ {
+		if in[i] == 0 {
+			r := make([]rune, i-n)
+			for j, b := range in[n:i] {
+				r[j] = rune(b)
+			}
+			return string(r), i + 1, nil
+		}
+	}
+	return "", 0, io.ErrUnexpectedEOF
+}
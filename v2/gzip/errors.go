@@ -0,0 +1,13 @@
+package gzip
+
+import "errors"
+
+var (
+	// ErrChecksum is returned when reading GZIP data that has an invalid checksum.
+	ErrChecksum = errors.New("libdeflate: gzip: invalid checksum")
+	// ErrHeader is returned when reading GZIP data that has an invalid header.
+	ErrHeader = errors.New("libdeflate: gzip: invalid header")
+
+	errorWriterClosed = errors.New("libdeflate: gzip: writer already closed")
+	errorReaderClosed = errors.New("libdeflate: gzip: reader already closed")
+)
@@ -0,0 +1,205 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestWriterStdReader(t *testing.T) {
+	in := bytes.Repeat(shortString, 1000)
+	buf := &bytes.Buffer{}
+	w, _ := NewWriterLevel(buf, MaxCompression)
+	w.Name = "hällo.txt"
+	w.Comment = "a comment"
+	w.Extra = []byte("extra")
+	w.ModTime = time.Unix(1600000000, 0)
+	w.OS = 3
+
+	w.Write(in[:100])
+	w.Flush()
+	w.Write(in[100:])
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+
+	r, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	slicesEqual(in, out, t)
+
+	if r.Name != w.Name || r.Comment != w.Comment || string(r.Extra) != "extra" || !r.ModTime.Equal(w.ModTime) || r.OS != 3 {
+		t.Errorf("header mismatch: %+v", r.Header)
+	}
+}
+
+func TestReaderStdWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	for _, name := range []string{"a", "b"} {
+		w := gzip.NewWriter(buf)
+		w.Name = name
+		w.Write(shortString)
+		w.Close()
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Name != "a" {
+		t.Error(r.Name)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	slicesEqual(append(shortString, shortString...), out, t)
+
+	r.Reset(bytes.NewReader(buf.Bytes()))
+	r.Multistream(false)
+	out, _ = ioutil.ReadAll(r)
+	slicesEqual(shortString, out, t)
+}
+
+func TestReaderStreaming(t *testing.T) {
+	member := make([]byte, 200000)
+	rand.New(rand.NewSource(1)).Read(member)
+	buf := &bytes.Buffer{}
+	for i := 0; i < 4; i++ {
+		w := gzip.NewWriter(buf)
+		w.Write(member)
+		w.Close()
+	}
+	comp := buf.Bytes()
+
+	// members are read as needed rather than all at once
+	src := bytes.NewReader(comp)
+	r, err := NewReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(r, make([]byte, len(member))); err != nil {
+		t.Fatal(err)
+	}
+	if src.Len() == 0 {
+		t.Error("whole source read for the first member")
+	}
+
+	// members and headers may arrive in pieces
+	r.Reset(iotest.HalfReader(iotest.OneByteReader(bytes.NewReader(comp))))
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(bytes.Repeat(member, 4), out, t)
+
+	if _, err := ioutil.ReadAll(mustReader(comp[:len(comp)-3], t)); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	// a closed Reader can be reset and read again
+	r.Close()
+	r.Reset(bytes.NewReader(comp))
+	out, err = ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(bytes.Repeat(member, 4), out, t)
+	r.Close()
+}
+
+func TestReaderInvalidMember(t *testing.T) {
+	text := bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog "), 1<<17)
+	buf := &bytes.Buffer{}
+	w, _ := NewWriterLevel(buf, BestSpeed)
+	w.Write(text)
+	w.Close()
+	for i := 0; i < 100; i++ {
+		buf.Write(bytes.Repeat([]byte{0}, readSize))
+	}
+	comp := buf.Bytes()
+	comp[10] |= 6 // the first block has the reserved block type 3
+
+	// the member fails without reading the rest of the source
+	src := &countingReader{r: bytes.NewReader(comp)}
+	r, err := NewReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatal("expected error for invalid member")
+	}
+	if src.n > len(comp)/8 {
+		t.Errorf("read %d of %d bytes", src.n, len(comp))
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Write(shortString)
+	w.Close()
+	comp := buf.Bytes()
+
+	corrupted := append([]byte{}, comp...)
+	corrupted[len(corrupted)-5]++
+	r, err := NewReader(bytes.NewReader(corrupted))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(r); err != ErrChecksum {
+		t.Error(err)
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("not gzip at all"))); err != ErrHeader {
+		t.Error(err)
+	}
+}
+
+/*---------------------
+		HELPER
+-----------------------*/
+
+func mustReader(comp []byte, t *testing.T) *Reader {
+	r, err := NewReader(bytes.NewReader(comp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
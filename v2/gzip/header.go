@@ -0,0 +1,53 @@
+package gzip
+
+import (
+	"io"
+	"time"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// The gzip file stores a header giving metadata about the compressed file.
+// That header is exposed as the fields of the Writer and Reader structs.
+//
+// Strings must be UTF-8 encoded and may only contain Unicode code points
+// U+0001 through U+00FF, due to limitations of the gzip file format.
+type Header struct {
+	Comment string    // comment
+	Extra   []byte    // "extra data"
+	ModTime time.Time // modification time
+	Name    string    // file name
+	OS      byte      // operating system type
+}
+
+// marshal encodes the header, setting the extra flags of the header to xfl.
+func (h *Header) marshal(xfl byte) ([]byte, error) {
+	return libdeflate.GzipHeader{
+		Name:       h.Name,
+		Comment:    h.Comment,
+		ModTime:    h.ModTime,
+		Extra:      h.Extra,
+		OS:         h.OS,
+		ExtraFlags: xfl,
+	}.MarshalBinary()
+}
+
+// unmarshal decodes the header at the beginning of in and returns its length.
+func (h *Header) unmarshal(in []byte) (int, error) {
+	gh, n, err := libdeflate.ParseGzipHeader(in)
+	if err == io.ErrUnexpectedEOF {
+		return 0, err
+	}
+	if err != nil {
+		return 0, ErrHeader
+	}
+
+	*h = Header{
+		Comment: gh.Comment,
+		Extra:   gh.Extra,
+		ModTime: gh.ModTime,
+		Name:    gh.Name,
+		OS:      gh.OS,
+	}
+	return n, nil
+}
@@ -0,0 +1,207 @@
+package gzip
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// A Reader is an io.Reader that can be read to retrieve uncompressed data from a gzip-format compressed file.
+//
+// In general, a gzip file can be a concatenation of gzip files, each with its own header.
+// Reads from the Reader return the concatenation of the uncompressed data of each.
+// Only the first header is recorded in the Reader fields.
+//
+// Gzip files store a length and checksum of the uncompressed data.
+// The Reader will return an ErrChecksum when Read reaches the end of the uncompressed data
+// if it does not have the expected length or checksum.
+type Reader struct {
+	Header      // valid after NewReader or Reader.Reset
+	r           io.Reader
+	dc          libdeflate.Decompressor
+	hasDC       bool   // dc is allocated and not closed yet
+	in          []byte // compressed data read from r, but not consumed yet
+	eof         bool
+	done        bool // no member follows
+	out         []byte
+	multistream bool
+	err         error
+}
+
+// readSize is the minimum amount of compressed data a Reader requests from its source at once.
+const readSize = 64 << 10
+
+// NewReader creates a new Reader reading the given reader.
+// The compressed data is read from r member by member, and every member is decompressed as a whole once it has been
+// read completely, so memory usage is bounded by the largest member rather than by the whole stream.
+//
+// It is the caller's responsibility to call Close on the Reader when done.
+//
+// The Reader.Header fields will be valid in the Reader returned.
+func NewReader(r io.Reader) (*Reader, error) {
+	z := new(Reader)
+	if err := z.Reset(r); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// Reset discards the Reader z's state and makes it equivalent to the result of its original state from NewReader,
+// but reading from r instead. This permits reusing a Reader rather than allocating a new one.
+func (z *Reader) Reset(r io.Reader) error {
+	*z = Reader{r: r, dc: z.dc, hasDC: z.hasDC, multistream: true}
+	if err := z.readHeader(); err != nil {
+		z.err = err
+		return err
+	}
+	return nil
+}
+
+// Multistream controls whether the reader supports multistream files.
+//
+// If enabled (the default), the Reader expects the input to be a sequence of individually gzipped data streams,
+// each with its own header and trailer, ending at EOF. The effect is that the concatenation of a sequence of
+// gzipped files is treated as equivalent to the gzip of the concatenation of the sequence.
+//
+// Calling Multistream(false) disables this behavior; Reading stops after the first member.
+func (z *Reader) Multistream(ok bool) {
+	z.multistream = ok
+}
+
+// Read implements io.Reader, reading uncompressed bytes from its underlying Reader.
+func (z *Reader) Read(p []byte) (int, error) {
+	for len(z.out) == 0 {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.next()
+	}
+
+	n := copy(p, z.out)
+	z.out = z.out[n:]
+	return n, nil
+}
+
+// Close closes the Reader and frees the c memory of its Decompressor. It does not close the underlying io.Reader.
+// In order for the GZIP checksum to be verified, the reader must be fully consumed until the io.EOF.
+func (z *Reader) Close() error {
+	if z.hasDC {
+		z.dc.Close()
+		z.hasDC = false
+	}
+	if z.err != nil && z.err != io.EOF {
+		return z.err
+	}
+	z.out = nil
+	z.err = errorReaderClosed
+	return nil
+}
+
+// next decompresses the member whose header has already been parsed and parses the header of the following member.
+// Returns io.EOF if there is no more member to decompress.
+func (z *Reader) next() error {
+	if z.done {
+		return io.EOF
+	}
+
+	if !z.hasDC {
+		dc, err := libdeflate.NewDecompressorWithExtendedDecompression(libdeflate.MaxPossibleDecompressionFactor)
+		if err != nil {
+			return err
+		}
+		z.dc, z.hasDC = dc, true
+	}
+
+	var (
+		c   int
+		out []byte
+		err error
+	)
+	for {
+		c, out, err = z.dc.DecompressSizeHint(z.in, 4*len(z.in), libdeflate.ModeDEFLATE)
+		if err == nil && (len(z.in)-c >= 8 || z.eof) {
+			break
+		}
+		// the member is most likely incomplete if it fails to decompress, so try again with more input,
+		// unless it fails too early to have been cut off by the end of the buffered input
+		if z.eof || err != nil && !z.dc.DecodesAtLeast(z.in, len(z.in)/2, libdeflate.ModeDEFLATE) {
+			return err
+		}
+		if err := z.fill(2 * len(z.in)); err != nil {
+			return err
+		}
+	}
+
+	trailer := z.in[c:]
+	if len(trailer) < 8 {
+		return io.ErrUnexpectedEOF
+	}
+	if binary.LittleEndian.Uint32(trailer) != libdeflate.Crc32(0, out) ||
+		binary.LittleEndian.Uint32(trailer[4:]) != uint32(len(out)) {
+		return ErrChecksum
+	}
+	z.out = out
+	z.in = trailer[8:]
+
+	if len(z.in) == 0 {
+		if err := z.fill(readSize); err != nil {
+			return err
+		}
+	}
+	if !z.multistream || len(z.in) == 0 {
+		z.done = true
+		return nil
+	}
+	return z.readHeader()
+}
+
+// readHeader parses the header of the next member into z.Header. Returns io.EOF if the source is empty.
+func (z *Reader) readHeader() error {
+	if len(z.in) == 0 {
+		if err := z.fill(readSize); err != nil {
+			return err
+		}
+		if len(z.in) == 0 {
+			return io.EOF
+		}
+	}
+	for {
+		var h Header
+		n, err := h.unmarshal(z.in)
+		if err == io.ErrUnexpectedEOF && !z.eof {
+			if err := z.fill(2 * len(z.in)); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		z.Header = h
+		z.in = z.in[n:]
+		return nil
+	}
+}
+
+// fill reads from the underlying io.Reader until at least min bytes are buffered or the source is exhausted.
+func (z *Reader) fill(min int) error {
+	if min < readSize {
+		min = readSize
+	}
+	if cap(z.in) < min {
+		in := make([]byte, len(z.in), min)
+		copy(in, z.in)
+		z.in = in
+	}
+	for len(z.in) < min && !z.eof {
+		n, err := z.r.Read(z.in[len(z.in):min])
+		z.in = z.in[:len(z.in)+n]
+		if err == io.EOF {
+			z.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,149 @@
+// Package gzip mirrors the API of compress/gzip, but compresses and decompresses gzip data
+// with libdeflate. Migrating from compress/gzip only requires changing the import path.
+//
+// As libdeflate operates on whole buffers, a Writer buffers all data until it is closed
+// and a Reader reads a whole member before returning any of its data.
+package gzip
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/4kills/go-libdeflate/v2"
+	"github.com/4kills/go-libdeflate/v2/flate"
+)
+
+// These constants are copied from the flate package, so code that imports this package does not also have to
+// import the flate package.
+const (
+	NoCompression      = flate.NoCompression
+	BestSpeed          = flate.BestSpeed
+	BestCompression    = flate.BestCompression
+	DefaultCompression = flate.DefaultCompression
+	HuffmanOnly        = flate.HuffmanOnly
+	MaxCompression     = flate.MaxCompression
+)
+
+// A Writer is an io.WriteCloser.
+// Writes to a Writer are compressed and written to w.
+type Writer struct {
+	Header      // written at first call to Write, Flush, or Close
+	w           io.Writer
+	level       int
+	fw          *flate.Writer
+	crc32       uint32
+	size        uint32
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+// NewWriter returns a new Writer.
+// Writes to the returned writer are compressed and written to w.
+//
+// It is the caller's responsibility to call Close on the Writer when done.
+// Writes may be buffered and not flushed until Close.
+//
+// Callers that wish to set the fields in Writer.Header must do so before
+// the first call to Write, Flush, or Close.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level instead of assuming DefaultCompression.
+//
+// The compression level can be DefaultCompression, NoCompression, HuffmanOnly or any integer value between
+// BestSpeed and BestCompression inclusive. Additionally, levels up to MaxCompression are accepted.
+// The error returned will be nil if the level is valid.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < HuffmanOnly || level > MaxCompression {
+		return nil, fmt.Errorf("libdeflate: gzip: invalid compression level: %d", level)
+	}
+	fw, err := flate.NewWriter(w, level)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{Header: Header{OS: libdeflate.GzipOSUnknown}, w: w, level: level, fw: fw}, nil
+}
+
+// Reset discards the Writer z's state and makes it equivalent to the result of its original state
+// from NewWriter or NewWriterLevel, but writing to w instead.
+func (z *Writer) Reset(w io.Writer) {
+	*z = Writer{Header: Header{OS: libdeflate.GzipOSUnknown}, w: w, level: z.level, fw: z.fw}
+	z.fw.Reset(w)
+}
+
+// Write writes a compressed form of p to the underlying io.Writer.
+// The compressed bytes are not necessarily flushed until the Writer is closed.
+func (z *Writer) Write(p []byte) (int, error) {
+	if err := z.writeHeader(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n, err := z.fw.Write(p)
+	if err != nil {
+		z.err = err
+		return n, err
+	}
+	z.crc32 = libdeflate.Crc32(z.crc32, p)
+	z.size += uint32(len(p))
+	return n, nil
+}
+
+// Flush flushes any pending compressed data to the underlying writer. See flate.Writer.Flush.
+func (z *Writer) Flush() error {
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	z.err = z.fw.Flush()
+	return z.err
+}
+
+// Close closes the Writer by flushing any unwritten data to the underlying io.Writer and writing the gzip footer.
+// It does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	z.closed = true
+
+	if z.err = z.fw.Close(); z.err != nil {
+		return z.err
+	}
+
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:4], z.crc32)
+	binary.LittleEndian.PutUint32(trailer[4:], z.size)
+	_, z.err = z.w.Write(trailer[:])
+	return z.err
+}
+
+// writeHeader writes the gzip header if it has not been written yet.
+func (z *Writer) writeHeader() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return errorWriterClosed
+	}
+	if z.wroteHeader {
+		return nil
+	}
+	z.wroteHeader = true
+
+	hdr, err := z.Header.marshal(libdeflate.GzipExtraFlags(z.level))
+	if err != nil {
+		z.err = err
+		return err
+	}
+	_, z.err = z.w.Write(hdr)
+	return z.err
+}
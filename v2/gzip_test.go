@@ -0,0 +1,146 @@
+package libdeflate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestGzipHeaderExtraFields(t *testing.T) {
+	var h GzipHeader
+	h.AddExtraField([2]byte{'B', 'C'}, []byte{1, 2})
+	h.AddExtraField([2]byte{'R', 'A'}, nil)
+
+	fields, err := h.ExtraFields()
+	if err != nil || len(fields) != 2 {
+		t.Fatal(err)
+	}
+	if fields[0].ID != [2]byte{'B', 'C'} || !bytes.Equal(fields[0].Data, []byte{1, 2}) || fields[1].ID != [2]byte{'R', 'A'} {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+
+	h.Extra = h.Extra[:3]
+	if _, err := h.ExtraFields(); err == nil {
+		t.Error("expected error for malformed extra field")
+	}
+	if err := h.AddExtraField([2]byte{'X', 'X'}, make([]byte, 0xffff)); err == nil {
+		t.Error("expected error for too long extra field")
+	}
+}
+
+func TestGzipHeaderMarshalParse(t *testing.T) {
+	h := GzipHeader{
+		Name:      "fïle.txt",
+		Comment:   "comment",
+		ModTime:   time.Unix(1600000000, 0),
+		Extra:     []byte{'A', 'B', 0, 0},
+		OS:        GzipOSUnix,
+		Text:      true,
+		HeaderCRC: true,
+	}
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, n, err := ParseGzipHeader(append(b, 1, 2, 3))
+	if err != nil || n != len(b) {
+		t.Fatal(err)
+	}
+	if parsed.Name != h.Name || parsed.Comment != h.Comment || !parsed.ModTime.Equal(h.ModTime) ||
+		!bytes.Equal(parsed.Extra, h.Extra) || parsed.OS != h.OS || !parsed.Text || !parsed.HeaderCRC {
+		t.Errorf("header mismatch: %+v", parsed)
+	}
+
+	b[len(b)-1]++
+	if _, _, err := ParseGzipHeader(b); err != errorGzipHeaderChecksum {
+		t.Error(err)
+	}
+	if _, _, err := ParseGzipHeader(b[:12]); err == nil {
+		t.Error("expected error for truncated header")
+	}
+	if _, err := (GzipHeader{Name: "世"}).MarshalBinary(); err == nil {
+		t.Error("expected error for non-Latin-1 name")
+	}
+}
+
+func TestCompressGzipWithHeader(t *testing.T) {
+	c, _ := NewCompressor()
+	defer c.Close()
+
+	h := GzipHeader{Name: "hello.txt", Comment: "hi", ModTime: time.Unix(1600000000, 0), OS: GzipOSUnix, HeaderCRC: true}
+	_, comp, err := c.CompressGzipWithHeader(shortString, nil, h)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(comp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	slicesEqual(shortString, out, t)
+	if r.Name != h.Name || r.Comment != h.Comment || !r.ModTime.Equal(h.ModTime) || r.OS != GzipOSUnix {
+		t.Errorf("header mismatch: %+v", r.Header)
+	}
+
+	// reproducible output without a timestamp
+	_, a, _ := c.CompressGzipWithHeader(shortString, nil, GzipHeader{OS: GzipOSUnix})
+	_, b, _ := c.CompressGzipWithHeader(shortString, nil, GzipHeader{OS: GzipOSUnix})
+	slicesEqual(a, b, t)
+
+	if _, _, err := c.CompressGzipWithHeader(shortString, make([]byte, 12), h); err == nil {
+		t.Error("expected error for short buffer")
+	}
+}
+
+func TestGzipExtraFlags(t *testing.T) {
+	for level, xfl := range map[int]byte{MinCompressionLevel: 4, 2: 0, DefaultCompressionLevel: 0, MaxStdZlibCompressionLevel: 2, MaxCompressionLevel: 2} {
+		if got := GzipExtraFlags(level); got != xfl {
+			t.Errorf("level %d: expected XFL %d, got %d", level, xfl, got)
+		}
+	}
+}
+
+func TestDecompressGzipWithHeader(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := gzip.NewWriter(buf)
+	w.Name = "hello.txt"
+	w.ModTime = time.Unix(1600000000, 0)
+	w.Extra = []byte{'A', 'B', 1, 0, 42}
+	w.Write(shortString)
+	w.Close()
+	in := append(buf.Bytes(), "trailing"...)
+
+	dc, _ := NewDecompressor()
+	defer dc.Close()
+	c, out, h, trailer, err := dc.DecompressGzipWithHeader(in, nil)
+	if err != nil || c != buf.Len() {
+		t.Fatal(err)
+	}
+	slicesEqual(shortString, out, t)
+	if h.Name != "hello.txt" || !h.ModTime.Equal(w.ModTime) {
+		t.Errorf("header mismatch: %+v", h)
+	}
+	if fields, _ := h.ExtraFields(); len(fields) != 1 || fields[0].Data[0] != 42 {
+		t.Errorf("unexpected extra fields: %v", fields)
+	}
+	if trailer.CRC32 != Crc32(0, shortString) || trailer.ISize != uint32(len(shortString)) {
+		t.Errorf("unexpected trailer: %+v", trailer)
+	}
+
+	corrupted := append([]byte{}, buf.Bytes()...)
+	corrupted[len(corrupted)-1]++
+	if _, _, _, _, err := dc.DecompressGzipWithHeader(corrupted, nil); err != errorGzipChecksum {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,146 @@
+// Package httpcompress provides net/http middleware that compresses responses and decompresses
+// request bodies with libdeflate, and a client-side http.RoundTripper doing the reverse.
+//
+// Responses are compressed as gzip or deflate, as negotiated with the Accept-Encoding header of the request.
+// Following common practice and RFC 9110, deflate denotes zlib-wrapped DEFLATE data.
+package httpcompress
+
+import (
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// Content codings supported by this package.
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// DefaultExcludedContentTypes are the media types whose responses are not compressed by default,
+// as they are compressed already. Entries ending in "/" match all subtypes of a type.
+var DefaultExcludedContentTypes = []string{
+	"image/",
+	"audio/",
+	"video/",
+	"font/woff",
+	"font/woff2",
+	"application/gzip",
+	"application/x-gzip",
+	"application/zip",
+	"application/zstd",
+	"application/x-bzip2",
+	"application/x-xz",
+	"application/x-7z-compressed",
+	"application/x-rar-compressed",
+	"application/vnd.rar",
+	"application/pdf",
+	"application/octet-stream",
+}
+
+// compressibleImages are image types that are text-based and compress well.
+var compressibleImages = map[string]bool{
+	"image/svg+xml":            true,
+	"image/bmp":                true,
+	"image/x-icon":             true,
+	"image/vnd.microsoft.icon": true,
+}
+
+// negotiate returns the content coding of the Accept-Encoding header value that is preferred by the client
+// and supported by this package, or "" if the client accepts neither gzip nor deflate.
+// On equal preference, gzip is chosen.
+func negotiate(acceptEncoding string) string {
+	qGzip, qDeflate, qAny := -1.0, -1.0, -1.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, q := parseCoding(part)
+		switch coding {
+		case "gzip", "x-gzip":
+			qGzip = q
+		case "deflate":
+			qDeflate = q
+		case "*":
+			qAny = q
+		}
+	}
+	if qGzip < 0 {
+		qGzip = qAny
+	}
+	if qDeflate < 0 {
+		qDeflate = qAny
+	}
+
+	switch {
+	case qGzip > 0 && qGzip >= qDeflate:
+		return EncodingGzip
+	case qDeflate > 0:
+		return EncodingDeflate
+	default:
+		return ""
+	}
+}
+
+// parseCoding parses a single element of an Accept-Encoding header, such as "gzip;q=0.8",
+// into its lower-case content coding and quality value. Malformed quality values count as 0.
+func parseCoding(s string) (string, float64) {
+	params := strings.Split(s, ";")
+	coding := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+	for _, p := range params[1:] {
+		p = strings.TrimSpace(p)
+		if len(p) < 2 || (p[0] != 'q' && p[0] != 'Q') || p[1] != '=' {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(p[2:]), 64)
+		if err != nil || v < 0 || v > 1 {
+			v = 0
+		}
+		q = v
+	}
+	return coding, q
+}
+
+// excluded reports whether responses of the given Content-Type should not be compressed.
+func excluded(contentType string, excludedTypes []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	if compressibleImages[mediaType] {
+		return false
+	}
+	for _, e := range excludedTypes {
+		if mediaType == e || (strings.HasSuffix(e, "/") && strings.HasPrefix(mediaType, e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodingMode returns the libdeflate Mode to decode a body with the given Content-Encoding.
+// deflate bodies are decoded as zlib or raw DEFLATE, as some clients send the latter.
+// Returns false if the content coding is not supported.
+func decodingMode(contentEncoding string) (libdeflate.Mode, bool) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "gzip", "x-gzip":
+		return libdeflate.ModeGzip, true
+	case "deflate":
+		return libdeflate.ModeAuto, true
+	default:
+		return 0, false
+	}
+}
+
+// decode decompresses a whole body encoded in mode m (see decodingMode).
+// All members of a multi-member gzip body are decoded. The MaxOutputBytes limit of dc applies to their total size.
+func decode(dc libdeflate.Decompressor, body []byte, m libdeflate.Mode) ([]byte, error) {
+	if len(body) == 0 {
+		return []byte{}, nil
+	}
+	if m == libdeflate.ModeGzip {
+		return dc.DecompressAll(body, m)
+	}
+	_, out, err := dc.Decompress(body, nil, m)
+	return out, err
+}
@@ -0,0 +1,11 @@
+package httpcompress
+
+import "errors"
+
+// ErrBodyTooLarge is returned if a compressed or decompressed body exceeds its configured size limit.
+var ErrBodyTooLarge = errors.New("libdeflate: httpcompress: body exceeds size limit")
+
+var (
+	errorInvalidLevel    = errors.New("libdeflate: httpcompress: invalid compression level")
+	errorInvalidEncoding = errors.New("libdeflate: httpcompress: unsupported request encoding")
+)
@@ -0,0 +1,386 @@
+package httpcompress
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+const (
+	// DefaultMinSize is the default minimum size of a response to be compressed.
+	// Smaller responses usually fit into a single packet anyway.
+	DefaultMinSize = 1400
+
+	// DefaultMaxBufferSize is the default amount of response data buffered to be compressed at once.
+	DefaultMaxBufferSize = libdeflate.DefaultChunkSize
+
+	// DefaultMaxRequestBodySize is the default limit of a decompressed request body.
+	DefaultMaxRequestBodySize = 32 << 20
+)
+
+// Options configures a Handler. The zero value selects the defaults of all fields.
+type Options struct {
+	// Level is the compression level. As level 0 does not compress, 0 selects libdeflate.DefaultCompressionLevel.
+	Level int
+
+	// MinSize is the minimum size of a response to be compressed. If 0, DefaultMinSize is used.
+	MinSize int
+
+	// MaxBufferSize is the maximum amount of response data buffered before compressing. If 0, DefaultMaxBufferSize is used.
+	// Responses that do not fit into the buffer, or that are flushed by the handler, are streamed instead:
+	// With gzip, every MaxBufferSize bytes become a separate gzip member. deflate responses are sent uncompressed,
+	// as a zlib stream can't be continued.
+	MaxBufferSize int
+
+	// MaxRequestBodySize limits the size of request bodies, both compressed and decompressed.
+	// Larger bodies are rejected with 413 Request Entity Too Large. If 0, DefaultMaxRequestBodySize is used.
+	MaxRequestBodySize int64
+
+	// ExcludedContentTypes are the media types that are not compressed. Entries ending in "/" match all subtypes.
+	// If nil, DefaultExcludedContentTypes is used.
+	ExcludedContentTypes []string
+}
+
+// Handler is an http.Handler that compresses the responses of the wrapped handler according to the
+// Accept-Encoding header of the request, and decompresses request bodies sent with
+// Content-Encoding gzip or deflate before passing them on.
+//
+// A Handler is safe for concurrent use. Compressors and Decompressors are pooled between requests.
+// Always Close() the Handler when it's no longer in use to free the c memory of the pooled instances.
+type Handler struct {
+	next          http.Handler
+	level         int
+	minSize       int
+	maxBufferSize int
+	maxBodySize   int64
+	excluded      []string
+	cp            *libdeflate.CompressorPool
+	dp            *libdeflate.DecompressorPool
+}
+
+// NewHandler returns a new Handler wrapping next, configured by opts.
+// Errors if out of memory or if an invalid compression level was passed.
+func NewHandler(next http.Handler, opts Options) (*Handler, error) {
+	h := &Handler{
+		next:          next,
+		level:         opts.Level,
+		minSize:       opts.MinSize,
+		maxBufferSize: opts.MaxBufferSize,
+		maxBodySize:   opts.MaxRequestBodySize,
+		excluded:      opts.ExcludedContentTypes,
+	}
+	if h.level == 0 {
+		h.level = libdeflate.DefaultCompressionLevel
+	}
+	if h.level < libdeflate.MinCompressionLevel || h.level > libdeflate.MaxCompressionLevel {
+		return nil, errorInvalidLevel
+	}
+	if h.minSize <= 0 {
+		h.minSize = DefaultMinSize
+	}
+	if h.maxBufferSize <= 0 {
+		h.maxBufferSize = DefaultMaxBufferSize
+	}
+	if h.maxBodySize <= 0 {
+		h.maxBodySize = DefaultMaxRequestBodySize
+	}
+	if h.excluded == nil {
+		h.excluded = DefaultExcludedContentTypes
+	}
+
+	var err error
+	if h.cp, err = libdeflate.NewCompressorPool(libdeflate.PoolOptions{}); err != nil {
+		return nil, err
+	}
+	h.dp, err = libdeflate.NewDecompressorPool(libdeflate.PoolOptions{}, libdeflate.DecompressorOptions{
+		MaxDecompressionFactor: libdeflate.MaxPossibleDecompressionFactor,
+		MaxOutputBytes:         h.maxBodySize,
+	})
+	if err != nil {
+		h.cp.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Close frees the pooled Compressors and Decompressors. The Handler must not serve requests afterwards.
+func (h *Handler) Close() {
+	h.cp.Close()
+	h.dp.Close()
+}
+
+// ServeHTTP decompresses the request body if necessary, calls the wrapped handler and compresses its response.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if status := h.decodeRequest(r); status != 0 {
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	cw := &responseWriter{ResponseWriter: w, h: h, encoding: negotiate(r.Header.Get("Accept-Encoding"))}
+	if r.Method == http.MethodHead {
+		cw.encoding = ""
+		cw.head = true
+	}
+	defer cw.close()
+
+	h.next.ServeHTTP(cw, r)
+}
+
+// decodeRequest replaces a gzip or deflate encoded request body with its decompressed form.
+// Returns the status code to reject the request with, or 0.
+func (h *Handler) decodeRequest(r *http.Request) int {
+	m, ok := decodingMode(r.Header.Get("Content-Encoding"))
+	if !ok || r.Body == nil || r.Body == http.NoBody {
+		return 0
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, h.maxBodySize+1))
+	r.Body.Close()
+	if err != nil {
+		return http.StatusBadRequest
+	}
+	if int64(len(body)) > h.maxBodySize {
+		return http.StatusRequestEntityTooLarge
+	}
+
+	dc, err := h.dp.Get()
+	if err != nil {
+		return http.StatusInternalServerError
+	}
+	out, err := decode(dc, body, m)
+	h.dp.Put(dc)
+	if err == libdeflate.ErrOutputLimitExceeded {
+		return http.StatusRequestEntityTooLarge
+	}
+	if err != nil {
+		return http.StatusBadRequest
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(out))
+	r.ContentLength = int64(len(out))
+	r.Header.Del("Content-Encoding")
+	r.Header.Set("Content-Length", strconv.Itoa(len(out)))
+	return 0
+}
+
+// responseWriter buffers the response of the wrapped handler to decide whether and how to compress it.
+type responseWriter struct {
+	http.ResponseWriter
+	h        *Handler
+	encoding string // negotiated content coding, "" if the response must not be compressed
+	status   int
+	buf      []byte
+	head     bool               // the request is a HEAD request, so the buffered body is not the response body
+	sent     bool               // the header has been sent to the client
+	stream   *libdeflate.Writer // streams gzip members once the buffer is exceeded
+	err      error
+}
+
+// WriteHeader records the status code, which is sent along with the first data.
+// Informational status codes are passed through right away.
+func (cw *responseWriter) WriteHeader(status int) {
+	if status < http.StatusOK {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if cw.status != 0 {
+		return
+	}
+	cw.status = status
+	if !compressibleStatus(status) {
+		cw.send(false)
+		cw.ResponseWriter.WriteHeader(status)
+	}
+}
+
+// Write buffers p, or writes it through once the response is being streamed.
+func (cw *responseWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.err != nil {
+		return 0, cw.err
+	}
+
+	if !cw.sent {
+		if len(cw.buf)+len(p) <= cw.h.maxBufferSize {
+			cw.buf = append(cw.buf, p...)
+			return len(p), nil
+		}
+		cw.startStream()
+		if cw.err != nil {
+			return 0, cw.err
+		}
+	}
+
+	var n int
+	if cw.stream != nil {
+		n, cw.err = cw.stream.Write(p)
+	} else {
+		n, cw.err = cw.ResponseWriter.Write(p)
+	}
+	return n, cw.err
+}
+
+// Flush sends all buffered data to the client. A flushed response is streamed from then on.
+func (cw *responseWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.sent {
+		cw.startStream()
+	}
+	if cw.stream != nil && cw.err == nil {
+		cw.err = cw.stream.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack lets the wrapped handler take over the connection, e.g. for WebSockets.
+func (cw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hj.Hijack()
+}
+
+// Unwrap returns the underlying http.ResponseWriter.
+func (cw *responseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// startStream sends the header and the buffered data, setting up gzip streaming if the response is compressible.
+func (cw *responseWriter) startStream() {
+	compress := cw.compressible() && cw.encoding == EncodingGzip
+	cw.send(compress)
+	cw.ResponseWriter.WriteHeader(cw.status)
+	if compress {
+		cw.stream, cw.err = libdeflate.NewWriterSize(cw.ResponseWriter, libdeflate.ModeGzip, cw.h.level, cw.h.maxBufferSize)
+		if cw.err != nil {
+			return
+		}
+	}
+
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) > 0 {
+		cw.Write(buf)
+	}
+}
+
+// close finishes the response after the wrapped handler returned.
+// Buffered responses are compressed as a whole, if worthwhile.
+func (cw *responseWriter) close() {
+	if cw.sent {
+		if cw.stream != nil {
+			cw.stream.Close()
+		}
+		return
+	}
+	if cw.status == 0 {
+		// nothing has been written, leave the response to net/http
+		return
+	}
+
+	if cw.encoding != "" && cw.compressible() && len(cw.buf) >= cw.h.minSize {
+		if comp, ok := cw.compress(); ok {
+			cw.send(true)
+			cw.Header().Set("Content-Length", strconv.Itoa(len(comp)))
+			cw.ResponseWriter.WriteHeader(cw.status)
+			cw.ResponseWriter.Write(comp)
+			return
+		}
+	}
+
+	cw.send(false)
+	// the body of a HEAD response is usually not written, so its length would contradict the one of a GET response
+	if !cw.head && cw.Header().Get("Content-Length") == "" {
+		cw.Header().Set("Content-Length", strconv.Itoa(len(cw.buf)))
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+	cw.ResponseWriter.Write(cw.buf)
+}
+
+// compress compresses the buffered response in the negotiated encoding.
+// Returns false if it could not be compressed or did not get smaller.
+func (cw *responseWriter) compress() ([]byte, bool) {
+	c, err := cw.h.cp.Get(cw.h.level)
+	if err != nil {
+		return nil, false
+	}
+	defer cw.h.cp.Put(c)
+
+	m := libdeflate.ModeGzip
+	if cw.encoding == EncodingDeflate {
+		m = libdeflate.ModeZlib
+	}
+	_, comp, err := c.Compress(cw.buf, nil, m)
+	if err != nil || len(comp) >= len(cw.buf) {
+		return nil, false
+	}
+	return comp, true
+}
+
+// compressible reports whether the response may be compressed regardless of the negotiated encoding.
+// It sniffs and sets the Content-Type if the handler did not, as the compressed data can't be sniffed by net/http.
+func (cw *responseWriter) compressible() bool {
+	hdr := cw.Header()
+	if !compressibleStatus(cw.status) || hdr.Get("Content-Encoding") != "" || hdr.Get("Content-Range") != "" {
+		return false
+	}
+
+	ct := hdr.Get("Content-Type")
+	if _, set := hdr["Content-Type"]; !set && len(cw.buf) > 0 {
+		ct = http.DetectContentType(cw.buf)
+		hdr.Set("Content-Type", ct)
+	}
+	return !excluded(ct, cw.h.excluded)
+}
+
+// send prepares the header of the response for being sent. If compress is set, the header
+// announces the negotiated encoding. The caller writes the status code afterwards.
+func (cw *responseWriter) send(compress bool) {
+	cw.sent = true
+
+	hdr := cw.Header()
+	if compress || cw.compressible() {
+		addVary(hdr, "Accept-Encoding")
+	}
+	if compress {
+		hdr.Set("Content-Encoding", cw.encoding)
+		hdr.Del("Content-Length")
+		hdr.Del("Accept-Ranges")
+		if etag := hdr.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			hdr.Set("ETag", "W/"+etag)
+		}
+	}
+}
+
+// compressibleStatus reports whether responses with the given status code may carry a compressed body.
+func compressibleStatus(status int) bool {
+	return status >= http.StatusOK && status != http.StatusNoContent &&
+		status != http.StatusPartialContent && status != http.StatusNotModified
+}
+
+// addVary adds value to the Vary header, unless it is listed already.
+func addVary(hdr http.Header, value string) {
+	for _, v := range hdr.Values("Vary") {
+		for _, field := range strings.Split(v, ",") {
+			field = strings.TrimSpace(field)
+			if field == "*" || strings.EqualFold(field, value) {
+				return
+			}
+		}
+	}
+	hdr.Add("Vary", value)
+}
@@ -0,0 +1,455 @@
+package httpcompress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                             "",
+		"gzip":                         EncodingGzip,
+		"deflate":                      EncodingDeflate,
+		"gzip, deflate, br":            EncodingGzip,
+		"gzip;q=0.5, deflate":          EncodingDeflate,
+		"deflate;q=0.5, gzip;q=0.5":    EncodingGzip,
+		"gzip;q=0, deflate;q=0":        "",
+		"*":                            EncodingGzip,
+		"*;q=0.1, gzip;q=0":            EncodingDeflate,
+		"br, identity":                 "",
+		"x-gzip":                       EncodingGzip,
+		"GZIP; Q=0.3 , deflate;q=0.2 ": EncodingGzip,
+		"gzip;q=abc":                   "",
+	}
+	for header, expected := range cases {
+		if got := negotiate(header); got != expected {
+			t.Errorf("negotiate(%q) = %q, expected %q", header, got, expected)
+		}
+	}
+}
+
+func TestExcluded(t *testing.T) {
+	cases := map[string]bool{
+		"text/html; charset=utf-8": false,
+		"application/json":         false,
+		"image/png":                true,
+		"image/svg+xml":            false,
+		"video/mp4":                true,
+		"application/zip":          true,
+		"":                         false,
+	}
+	for ct, expected := range cases {
+		if got := excluded(ct, DefaultExcludedContentTypes); got != expected {
+			t.Errorf("excluded(%q) = %v, expected %v", ct, got, expected)
+		}
+	}
+}
+
+func TestHandlerGzip(t *testing.T) {
+	in := bytes.Repeat(shortString, 100)
+	h := newTestHandler(t, Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("ETag", `"abc"`)
+		w.Write(in[:100])
+		w.Write(in[100:])
+	})
+	defer h.Close()
+
+	res := serve(h, "gzip, deflate", nil)
+	if ce := res.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Content-Encoding %q, expected gzip", ce)
+	}
+	if res.Header.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Vary %q", res.Header.Get("Vary"))
+	}
+	if res.Header.Get("ETag") != `W/"abc"` {
+		t.Errorf("ETag %q is not weak", res.Header.Get("ETag"))
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.Header.Get("Content-Length") != strconv.Itoa(len(body)) {
+		t.Errorf("Content-Length %q, body has %d bytes", res.Header.Get("Content-Length"), len(body))
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	slicesEqual(in, out, t)
+}
+
+func TestHandlerDeflate(t *testing.T) {
+	in := bytes.Repeat(shortString, 100)
+	h := newTestHandler(t, Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(in)
+	})
+	defer h.Close()
+
+	res := serve(h, "gzip;q=0.5, deflate", nil)
+	if ce := res.Header.Get("Content-Encoding"); ce != "deflate" {
+		t.Fatalf("Content-Encoding %q, expected deflate", ce)
+	}
+	if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("sniffed Content-Type %q", ct)
+	}
+
+	r, err := zlib.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	slicesEqual(in, out, t)
+}
+
+func TestHandlerSkip(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat(shortString, 100)...)
+	cases := []struct {
+		name           string
+		acceptEncoding string
+		body           []byte
+		contentType    string
+		status         int
+	}{
+		{"no accept-encoding", "", bytes.Repeat(shortString, 100), "", http.StatusOK},
+		{"too small", "gzip", shortString, "", http.StatusOK},
+		{"excluded content type", "gzip", bytes.Repeat(shortString, 100), "image/jpeg", http.StatusOK},
+		{"sniffed excluded content type", "gzip", png, "", http.StatusOK},
+		{"partial content", "gzip", bytes.Repeat(shortString, 100), "", http.StatusPartialContent},
+	}
+
+	for _, c := range cases {
+		h := newTestHandler(t, Options{}, func(w http.ResponseWriter, r *http.Request) {
+			if c.contentType != "" {
+				w.Header().Set("Content-Type", c.contentType)
+			}
+			w.WriteHeader(c.status)
+			w.Write(c.body)
+		})
+
+		res := serve(h, c.acceptEncoding, nil)
+		h.Close()
+		if ce := res.Header.Get("Content-Encoding"); ce != "" {
+			t.Errorf("%s: Content-Encoding %q, expected none", c.name, ce)
+		}
+		if res.StatusCode != c.status {
+			t.Errorf("%s: status %d, expected %d", c.name, res.StatusCode, c.status)
+		}
+		out, _ := ioutil.ReadAll(res.Body)
+		if !bytes.Equal(c.body, out) {
+			t.Errorf("%s: body differs", c.name)
+		}
+	}
+}
+
+func TestHandlerHead(t *testing.T) {
+	h := newTestHandler(t, Options{}, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		if r.Header.Get("X-Length") != "" {
+			w.Header().Set("Content-Length", r.Header.Get("X-Length"))
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+	defer h.Close()
+
+	for _, length := range []string{"", "1234"} {
+		req := httptest.NewRequest(http.MethodHead, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		req.Header.Set("X-Length", length)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		res := rec.Result()
+		if ce := res.Header.Get("Content-Encoding"); ce != "" {
+			t.Errorf("Content-Encoding %q, expected none", ce)
+		}
+		if cl := res.Header.Get("Content-Length"); cl != length {
+			t.Errorf("Content-Length %q, expected %q", cl, length)
+		}
+	}
+}
+
+func TestHandlerStreaming(t *testing.T) {
+	in := bytes.Repeat(shortString, 1000)
+	h := newTestHandler(t, Options{MaxBufferSize: 4096}, func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < len(in); i += 1000 {
+			end := i + 1000
+			if end > len(in) {
+				end = len(in)
+			}
+			w.Write(in[i:end])
+		}
+	})
+	defer h.Close()
+
+	res := serve(h, "gzip", nil)
+	if ce := res.Header.Get("Content-Encoding"); ce != "gzip" {
+		t.Fatalf("Content-Encoding %q, expected gzip", ce)
+	}
+	if cl := res.Header.Get("Content-Length"); cl != "" {
+		t.Errorf("streamed response has Content-Length %q", cl)
+	}
+	r, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	slicesEqual(in, out, t)
+
+	// deflate responses exceeding the buffer are sent uncompressed
+	res = serve(h, "deflate", nil)
+	if ce := res.Header.Get("Content-Encoding"); ce != "" {
+		t.Errorf("Content-Encoding %q, expected none", ce)
+	}
+	out, _ = ioutil.ReadAll(res.Body)
+	slicesEqual(in, out, t)
+}
+
+func TestHandlerRequestBody(t *testing.T) {
+	in := bytes.Repeat(shortString, 100)
+	h := newTestHandler(t, Options{MaxRequestBodySize: 16000}, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Error("Content-Encoding of request was not removed")
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		slicesEqual(in, body, t)
+	})
+	defer h.Close()
+
+	gz := &bytes.Buffer{}
+	gw := gzip.NewWriter(gz)
+	gw.Write(in)
+	gw.Close()
+	if res := serve(h, "", map[string][]byte{"gzip": gz.Bytes()}); res.StatusCode != http.StatusOK {
+		t.Errorf("gzip request: status %d", res.StatusCode)
+	}
+
+	zl := &bytes.Buffer{}
+	zw := zlib.NewWriter(zl)
+	zw.Write(in)
+	zw.Close()
+	if res := serve(h, "", map[string][]byte{"deflate": zl.Bytes()}); res.StatusCode != http.StatusOK {
+		t.Errorf("deflate request: status %d", res.StatusCode)
+	}
+
+	if res := serve(h, "", map[string][]byte{"gzip": []byte("garbage")}); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("malformed request: status %d, expected 400", res.StatusCode)
+	}
+
+	bomb := &bytes.Buffer{}
+	gw = gzip.NewWriter(bomb)
+	gw.Write(make([]byte, 20000))
+	gw.Close()
+	if res := serve(h, "", map[string][]byte{"gzip": bomb.Bytes()}); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized request: status %d, expected 413", res.StatusCode)
+	}
+}
+
+func TestHandlerRequestBodyMultiMember(t *testing.T) {
+	h := newTestHandler(t, Options{MaxRequestBodySize: 2000}, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler called with oversized body")
+	})
+	defer h.Close()
+
+	// every member is below the limit, but all of them exceed it
+	bomb := gzipMembers(50, 1000)
+	if len(bomb) > 2000 {
+		t.Fatalf("compressed body of %d bytes exceeds the limit itself", len(bomb))
+	}
+	if res := serve(h, "", map[string][]byte{"gzip": bomb}); res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized request: status %d, expected 413", res.StatusCode)
+	}
+}
+
+func TestNewHandlerInvalidLevel(t *testing.T) {
+	if _, err := NewHandler(http.NotFoundHandler(), Options{Level: 30}); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+func TestNewTransportErrors(t *testing.T) {
+	if _, err := NewTransport(nil, TransportOptions{Level: 30}); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if _, err := NewTransport(nil, TransportOptions{RequestEncoding: "br"}); err == nil {
+		t.Error("expected error for unsupported request encoding")
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestTransportHandler(t *testing.T) {
+	in := bytes.Repeat(shortString, 100)
+	h := newTestHandler(t, Options{}, func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	})
+	defer h.Close()
+
+	var sent http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Clone()
+		h.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	for _, enc := range []string{EncodingGzip, EncodingDeflate} {
+		tr, err := NewTransport(nil, TransportOptions{RequestEncoding: enc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := &http.Client{Transport: tr}
+
+		res, err := client.Post(srv.URL, "text/plain", bytes.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		tr.Close()
+
+		if sent.Get("Content-Encoding") != enc {
+			t.Errorf("request Content-Encoding %q, expected %q", sent.Get("Content-Encoding"), enc)
+		}
+		if sent.Get("Accept-Encoding") != "gzip, deflate" {
+			t.Errorf("Accept-Encoding %q", sent.Get("Accept-Encoding"))
+		}
+		if !res.Uncompressed || res.Header.Get("Content-Encoding") != "" {
+			t.Error("response was not decoded")
+		}
+		if res.ContentLength != int64(len(in)) {
+			t.Errorf("ContentLength %d, expected %d", res.ContentLength, len(in))
+		}
+		slicesEqual(in, out, t)
+	}
+}
+
+func TestTransportRawDeflate(t *testing.T) {
+	in := bytes.Repeat(shortString, 100)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fw, _ := flate.NewWriter(w, flate.BestCompression)
+		w.Header().Set("Content-Encoding", "deflate")
+		fw.Write(in)
+		fw.Close()
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(nil, TransportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	res, err := (&http.Client{Transport: tr}).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	slicesEqual(in, out, t)
+}
+
+func TestTransportMaxResponseSize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gw := gzip.NewWriter(w)
+		w.Header().Set("Content-Encoding", "gzip")
+		gw.Write(make([]byte, 1<<20))
+		gw.Close()
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(nil, TransportOptions{MaxResponseSize: 1 << 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	if _, err := (&http.Client{Transport: tr}).Get(srv.URL); err == nil || !strings.Contains(err.Error(), ErrBodyTooLarge.Error()) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+
+	// requests that set Accept-Encoding themselves get the raw response
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Header.Get("Content-Encoding") != "gzip" {
+		t.Error("response with custom Accept-Encoding was decoded")
+	}
+}
+
+func TestTransportMaxResponseSizeMultiMember(t *testing.T) {
+	bomb := gzipMembers(100, 1<<15)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(bomb)
+	}))
+	defer srv.Close()
+
+	tr, err := NewTransport(nil, TransportOptions{MaxResponseSize: 1 << 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+
+	if _, err := (&http.Client{Transport: tr}).Get(srv.URL); err == nil || !strings.Contains(err.Error(), ErrBodyTooLarge.Error()) {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+}
+
+// gzipMembers returns n gzip members of size zero bytes each.
+func gzipMembers(n, size int) []byte {
+	member := &bytes.Buffer{}
+	gw := gzip.NewWriter(member)
+	gw.Write(make([]byte, size))
+	gw.Close()
+	return bytes.Repeat(member.Bytes(), n)
+}
+
+func newTestHandler(t *testing.T, opts Options, f http.HandlerFunc) *Handler {
+	h, err := NewHandler(f, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+// serve sends a request with the given Accept-Encoding to h. body maps a Content-Encoding to the request body.
+func serve(h http.Handler, acceptEncoding string, body map[string][]byte) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for ce, b := range body {
+		req = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(b))
+		req.Header.Set("Content-Encoding", ce)
+	}
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec.Result()
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
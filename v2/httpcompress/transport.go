@@ -0,0 +1,208 @@
+package httpcompress
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// DefaultMaxResponseSize is the default limit of a decoded response body.
+const DefaultMaxResponseSize = 64 << 20
+
+// TransportOptions configures a Transport. The zero value selects the defaults of all fields.
+type TransportOptions struct {
+	// Level is the compression level of request bodies. As level 0 does not compress,
+	// 0 selects libdeflate.DefaultCompressionLevel.
+	Level int
+
+	// MinSize is the minimum size of a request body to be compressed. If 0, DefaultMinSize is used.
+	MinSize int
+
+	// RequestEncoding is the content coding of compressed request bodies, EncodingGzip or EncodingDeflate.
+	// If "", EncodingGzip is used. The server must be able to decode it, e.g. by using a Handler.
+	RequestEncoding string
+
+	// MaxResponseSize limits the size of response bodies decoded by the Transport, both compressed and decompressed.
+	// Reading a larger response fails with ErrBodyTooLarge. If 0, DefaultMaxResponseSize is used.
+	MaxResponseSize int64
+}
+
+// Transport is an http.RoundTripper that compresses request bodies and decodes compressed responses
+// on top of another http.RoundTripper.
+//
+// Request bodies of at least MinSize bytes are compressed, unless they have a Content-Encoding already.
+// If a request has no Accept-Encoding header, the Transport advertises gzip and deflate and decodes the response,
+// whose Content-Encoding and Content-Length headers are removed. As some servers send raw DEFLATE data
+// instead of zlib data as deflate, the format of deflate responses is detected from their header.
+// Requests that set Accept-Encoding themselves receive the response as is.
+//
+// Like libdeflate itself, the Transport operates on whole buffers: request bodies and encoded responses are read into memory.
+//
+// A Transport is safe for concurrent use. Compressors and Decompressors are pooled between requests.
+// Always Close() the Transport when it's no longer in use to free the c memory of the pooled instances.
+type Transport struct {
+	base     http.RoundTripper
+	level    int
+	minSize  int
+	mode     libdeflate.Mode
+	encoding string
+	maxSize  int64
+	cp       *libdeflate.CompressorPool
+	dp       *libdeflate.DecompressorPool
+}
+
+// NewTransport returns a new Transport sending requests through base, configured by opts.
+// If base is nil, http.DefaultTransport is used.
+// Errors if out of memory, if an invalid compression level or an unsupported request encoding was passed.
+func NewTransport(base http.RoundTripper, opts TransportOptions) (*Transport, error) {
+	t := &Transport{
+		base:     base,
+		level:    opts.Level,
+		minSize:  opts.MinSize,
+		encoding: opts.RequestEncoding,
+		maxSize:  opts.MaxResponseSize,
+	}
+	if t.base == nil {
+		t.base = http.DefaultTransport
+	}
+	if t.level == 0 {
+		t.level = libdeflate.DefaultCompressionLevel
+	}
+	if t.level < libdeflate.MinCompressionLevel || t.level > libdeflate.MaxCompressionLevel {
+		return nil, errorInvalidLevel
+	}
+	if t.minSize <= 0 {
+		t.minSize = DefaultMinSize
+	}
+	switch t.encoding {
+	case "", EncodingGzip:
+		t.encoding, t.mode = EncodingGzip, libdeflate.ModeGzip
+	case EncodingDeflate:
+		t.mode = libdeflate.ModeZlib
+	default:
+		return nil, errorInvalidEncoding
+	}
+	if t.maxSize <= 0 {
+		t.maxSize = DefaultMaxResponseSize
+	}
+
+	var err error
+	if t.cp, err = libdeflate.NewCompressorPool(libdeflate.PoolOptions{}); err != nil {
+		return nil, err
+	}
+	t.dp, err = libdeflate.NewDecompressorPool(libdeflate.PoolOptions{}, libdeflate.DecompressorOptions{
+		MaxDecompressionFactor: libdeflate.MaxPossibleDecompressionFactor,
+		MaxOutputBytes:         t.maxSize,
+	})
+	if err != nil {
+		t.cp.Close()
+		return nil, err
+	}
+	return t, nil
+}
+
+// Close frees the pooled Compressors and Decompressors. The Transport must not be used afterwards.
+func (t *Transport) Close() {
+	t.cp.Close()
+	t.dp.Close()
+}
+
+// RoundTrip compresses the body of req if worthwhile, sends it through the underlying http.RoundTripper
+// and decodes the response if the Transport negotiated its encoding. req is not modified.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	out := req.Clone(req.Context())
+
+	if req.Body != nil && req.Body != http.NoBody && req.Header.Get("Content-Encoding") == "" {
+		if err := t.encodeRequest(out); err != nil {
+			return nil, err
+		}
+	}
+
+	decode := req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == ""
+	if decode {
+		out.Header.Set("Accept-Encoding", EncodingGzip+", "+EncodingDeflate)
+	}
+
+	res, err := t.base.RoundTrip(out)
+	if err != nil || !decode {
+		return res, err
+	}
+	if err := t.decodeResponse(res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// encodeRequest replaces the body of req with its compressed form, if it has at least minSize bytes.
+func (t *Transport) encodeRequest(req *http.Request) error {
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	if len(body) >= t.minSize {
+		c, err := t.cp.Get(t.level)
+		if err != nil {
+			return err
+		}
+		_, comp, err := c.Compress(body, nil, t.mode)
+		t.cp.Put(c)
+		if err != nil {
+			return err
+		}
+
+		body = comp
+		req.Header.Set("Content-Encoding", t.encoding)
+	}
+
+	req.ContentLength = int64(len(body))
+	req.Header.Del("Content-Length")
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+// decodeResponse replaces a gzip or deflate encoded response body with its decompressed form.
+// The response body is closed if an error is returned.
+func (t *Transport) decodeResponse(res *http.Response) error {
+	m, ok := decodingMode(res.Header.Get("Content-Encoding"))
+	if !ok {
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, t.maxSize+1))
+	res.Body.Close()
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > t.maxSize {
+		return ErrBodyTooLarge
+	}
+
+	dc, err := t.dp.Get()
+	if err != nil {
+		return err
+	}
+	out, err := decode(dc, body, m)
+	t.dp.Put(dc)
+	if err == libdeflate.ErrOutputLimitExceeded {
+		return ErrBodyTooLarge
+	}
+	if err != nil {
+		return err
+	}
+
+	res.Body = ioutil.NopCloser(bytes.NewReader(out))
+	res.ContentLength = int64(len(out))
+	res.Header.Del("Content-Encoding")
+	res.Header.Set("Content-Length", strconv.Itoa(len(out)))
+	res.Uncompressed = true
+	return nil
+}
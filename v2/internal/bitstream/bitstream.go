@@ -0,0 +1,301 @@
+// Package bitstream walks the blocks of raw DEFLATE streams (RFC 1951) without producing output,
+// to locate the final block and the end of the stream at bit granularity and to count the decompressed bytes.
+// libdeflate always terminates its output with a final block, so joining streams requires editing the bitstream.
+package bitstream
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// ErrInvalid is returned for data that is not a complete DEFLATE stream.
+var ErrInvalid = errors.New("libdeflate: bitstream: invalid DEFLATE data")
+
+const (
+	maxBits     = 15  // maximum length of a Huffman code
+	maxLitCodes = 286 // number of literal/length codes
+	maxDistCode = 30  // number of distance codes
+)
+
+var (
+	lengthBase  = [29]int{3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31, 35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258}
+	lengthExtra = [29]uint{0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0}
+	distExtra   = [30]uint{0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6, 7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13}
+
+	// order of the code length code lengths of dynamic blocks
+	codeLengthOrder = [19]int{16, 17, 18, 0, 8, 7, 9, 6, 10, 5, 11, 4, 12, 3, 13, 2, 14, 1, 15}
+
+	fixedLit, fixedDist = fixedCodes()
+)
+
+// Stream describes a complete raw DEFLATE stream.
+type Stream struct {
+	// FinalBlock is the bit offset of the header of the final block. Its first bit is the BFINAL flag.
+	FinalBlock int
+	// End is the bit offset behind the end of the final block. The stream occupies (End+7)/8 bytes,
+	// the bits after End in its last byte are padding.
+	End int
+	// Size is the number of bytes the stream decompresses to.
+	Size int64
+}
+
+// Walk walks the blocks of the raw DEFLATE stream at the beginning of data up to the end of the final block.
+// Errors if data is not a valid DEFLATE stream or ends before the final block.
+func Walk(data []byte) (Stream, error) {
+	r := reader{data: data}
+	for {
+		header := r.pos
+		final := r.bits(1)
+		switch r.bits(2) {
+		case 0:
+			r.align()
+			if r.pos/8+4 > len(data) {
+				return Stream{}, ErrInvalid
+			}
+			n := binary.LittleEndian.Uint16(data[r.pos/8:])
+			if ^n != binary.LittleEndian.Uint16(data[r.pos/8+2:]) {
+				return Stream{}, ErrInvalid
+			}
+			r.pos += 8 * (4 + int(n))
+			r.size += int64(n)
+		case 1:
+			r.codes(&fixedLit, &fixedDist)
+		case 2:
+			var lit, dist huffman
+			if r.dynamicCodes(&lit, &dist) {
+				r.codes(&lit, &dist)
+			}
+		default:
+			return Stream{}, ErrInvalid
+		}
+
+		if r.err || r.pos > 8*len(data) {
+			return Stream{}, ErrInvalid
+		}
+		if final == 1 {
+			return Stream{FinalBlock: header, End: r.pos, Size: r.size}, nil
+		}
+	}
+}
+
+// Unfinish returns a copy of the raw DEFLATE stream at the beginning of data whose final block is turned into
+// a non-final block and followed by an empty stored block, as written by a zlib sync flush.
+// The result ends with 00 00 ff ff on a byte boundary, so another DEFLATE stream may follow it.
+func Unfinish(data []byte) ([]byte, error) {
+	s, err := Walk(data)
+	if err != nil {
+		return nil, err
+	}
+	return AppendUnfinished(make([]byte, 0, (s.End+7)/8+5), data, s), nil
+}
+
+// AppendUnfinished is like Unfinish, but appends the result to dst, given the Stream s returned by Walk for data.
+func AppendUnfinished(dst, data []byte, s Stream) []byte {
+	n := (s.End + 7) / 8
+	start := len(dst)
+	dst = append(dst, data[:n]...)
+	out := dst[start:]
+	out[s.FinalBlock/8] &^= 1 << (uint(s.FinalBlock) % 8)
+
+	// the header of the stored block takes 3 bits, which are zero, so it is part of the padding if it fits
+	if pad := n*8 - s.End; pad > 0 {
+		out[n-1] &= byte(1)<<(8-uint(pad)) - 1
+		if pad < 3 {
+			dst = append(dst, 0)
+		}
+	} else {
+		dst = append(dst, 0)
+	}
+	return append(dst, 0x00, 0x00, 0xff, 0xff)
+}
+
+// reader reads bits least significant first. Reading beyond the data yields zeros and sets err.
+type reader struct {
+	data []byte
+	pos  int   // bit offset
+	size int64 // number of decompressed bytes of the blocks read so far
+	err  bool
+}
+
+func (r *reader) bits(n uint) int {
+	v := 0
+	for i := uint(0); i < n; i++ {
+		if r.pos >= 8*len(r.data) {
+			r.err = true
+			return 0
+		}
+		v |= int(r.data[r.pos/8]>>(uint(r.pos)%8)&1) << i
+		r.pos++
+	}
+	return v
+}
+
+func (r *reader) align() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+// huffman is a canonical Huffman code: the number of codes of each length and the symbols ordered by code.
+type huffman struct {
+	count  [maxBits + 1]int
+	symbol [maxLitCodes + 2]int
+}
+
+// build builds the code from the code lengths of the symbols. Returns false if the code is over-subscribed.
+// Incomplete codes are allowed, as a distance code may consist of a single code.
+func (h *huffman) build(lengths []int) bool {
+	h.count = [maxBits + 1]int{}
+	for _, l := range lengths {
+		h.count[l]++
+	}
+	left := 1
+	for l := 1; l <= maxBits; l++ {
+		left = left<<1 - h.count[l]
+		if left < 0 {
+			return false
+		}
+	}
+
+	var offs [maxBits + 1]int
+	for l := 1; l < maxBits; l++ {
+		offs[l+1] = offs[l] + h.count[l]
+	}
+	for sym, l := range lengths {
+		if l != 0 {
+			h.symbol[offs[l]] = sym
+			offs[l]++
+		}
+	}
+	return true
+}
+
+// decode decodes a symbol, returning -1 for codes that are not part of an incomplete code.
+func (r *reader) decode(h *huffman) int {
+	code, first, index := 0, 0, 0
+	for l := 1; l <= maxBits; l++ {
+		code |= r.bits(1)
+		count := h.count[l]
+		if code-first < count {
+			return h.symbol[index+code-first]
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	r.err = true
+	return -1
+}
+
+// codes skips the compressed data of a block up to its end-of-block code, counting the decompressed bytes.
+func (r *reader) codes(lit, dist *huffman) {
+	for !r.err {
+		sym := r.decode(lit)
+		switch {
+		case sym < 0:
+			return
+		case sym < 256:
+			r.size++
+			continue
+		case sym == 256:
+			return
+		}
+		sym -= 257
+		if sym >= len(lengthExtra) {
+			r.err = true
+			return
+		}
+		r.size += int64(lengthBase[sym] + r.bits(lengthExtra[sym]))
+
+		d := r.decode(dist)
+		if d < 0 || d >= maxDistCode {
+			r.err = true
+			return
+		}
+		r.bits(distExtra[d])
+	}
+}
+
+// dynamicCodes reads the code lengths of a dynamic block and builds its codes.
+func (r *reader) dynamicCodes(lit, dist *huffman) bool {
+	nlen := r.bits(5) + 257
+	ndist := r.bits(5) + 1
+	ncode := r.bits(4) + 4
+	if nlen > maxLitCodes || ndist > maxDistCode {
+		r.err = true
+		return false
+	}
+
+	var lengths [maxLitCodes + maxDistCode]int
+	for i := 0; i < ncode; i++ {
+		lengths[codeLengthOrder[i]] = r.bits(3)
+	}
+	var lencode huffman
+	if !lencode.build(lengths[:19]) {
+		r.err = true
+		return false
+	}
+	for i := range lengths[:19] {
+		lengths[i] = 0
+	}
+
+	for i := 0; i < nlen+ndist && !r.err; {
+		sym := r.decode(&lencode)
+		if sym < 16 {
+			if sym < 0 {
+				return false
+			}
+			lengths[i] = sym
+			i++
+			continue
+		}
+
+		l, n := 0, 0
+		switch sym {
+		case 16:
+			if i == 0 {
+				r.err = true
+				return false
+			}
+			l, n = lengths[i-1], 3+r.bits(2)
+		case 17:
+			n = 3 + r.bits(3)
+		default:
+			n = 11 + r.bits(7)
+		}
+		if i+n > nlen+ndist {
+			r.err = true
+			return false
+		}
+		for ; n > 0; n-- {
+			lengths[i] = l
+			i++
+		}
+	}
+	if lengths[256] == 0 || !lit.build(lengths[:nlen]) || !dist.build(lengths[nlen:nlen+ndist]) {
+		r.err = true
+		return false
+	}
+	return !r.err
+}
+
+func fixedCodes() (lit, dist huffman) {
+	var lengths [288]int
+	for i := range lengths {
+		switch {
+		case i < 144:
+			lengths[i] = 8
+		case i < 256:
+			lengths[i] = 9
+		case i < 280:
+			lengths[i] = 7
+		default:
+			lengths[i] = 8
+		}
+	}
+	lit.build(lengths[:])
+
+	for i := 0; i < maxDistCode; i++ {
+		lengths[i] = 5
+	}
+	dist.build(lengths[:maxDistCode])
+	return lit, dist
+}
@@ -0,0 +1,141 @@
+package bitstream_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/4kills/go-libdeflate/v2"
+	"github.com/4kills/go-libdeflate/v2/internal/bitstream"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestWalk(t *testing.T) {
+	for _, data := range testInputs() {
+		for level := libdeflate.MinCompressionLevel; level <= libdeflate.MaxCompressionLevel; level++ {
+			comp := compress(data, level, t)
+			// trailing data is not part of the stream
+			s, err := bitstream.Walk(append(comp, 0xff, 0xff))
+			if err != nil {
+				t.Fatalf("level %d: %v", level, err)
+			}
+			if (s.End+7)/8 != len(comp) || s.FinalBlock >= s.End || s.Size != int64(len(data)) {
+				t.Errorf("level %d: stream of %d bytes, walked %+v", level, len(comp), s)
+			}
+			if comp[s.FinalBlock/8]>>(uint(s.FinalBlock)%8)&1 != 1 {
+				t.Errorf("level %d: BFINAL not set at %d", level, s.FinalBlock)
+			}
+		}
+	}
+}
+
+func TestWalkErrors(t *testing.T) {
+	comp := compress(shortString, libdeflate.DefaultCompressionLevel, t)
+	for _, data := range [][]byte{
+		nil,
+		comp[:len(comp)-1],
+		{0x07},                         // reserved block type
+		{0x01, 0x01, 0x00, 0x00},       // stored block length mismatch
+		{0x00, 0x00, 0x00, 0xff, 0xff}, // no final block
+	} {
+		if _, err := bitstream.Walk(data); err != bitstream.ErrInvalid {
+			t.Errorf("%x: expected ErrInvalid, got %v", data, err)
+		}
+	}
+}
+
+func TestUnfinish(t *testing.T) {
+	inputs := testInputs()
+	for level := libdeflate.MinCompressionLevel; level <= libdeflate.MaxCompressionLevel; level++ {
+		var joined, expected []byte
+		for i, data := range inputs {
+			comp := compress(data, level, t)
+			if i < len(inputs)-1 {
+				var err error
+				if comp, err = bitstream.Unfinish(comp); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.HasSuffix(comp, []byte{0x00, 0x00, 0xff, 0xff}) {
+					t.Error("missing sync marker")
+				}
+			}
+			joined = append(joined, comp...)
+			expected = append(expected, data...)
+		}
+
+		out, err := ioutil.ReadAll(flate.NewReader(bytes.NewReader(joined)))
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		slicesEqual(expected, out, t)
+
+		_, out, err = libdeflate.Decompress(joined, make([]byte, len(expected)), libdeflate.ModeDEFLATE)
+		if err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+		slicesEqual(expected, out, t)
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestWalkStdFlate(t *testing.T) {
+	for _, data := range testInputs() {
+		for _, level := range []int{flate.NoCompression, flate.BestSpeed, flate.DefaultCompression, flate.HuffmanOnly} {
+			buf := &bytes.Buffer{}
+			fw, _ := flate.NewWriter(buf, level)
+			fw.Write(data)
+			fw.Flush() // a sync flush in the middle of the stream
+			fw.Write(data)
+			fw.Close()
+
+			s, err := bitstream.Walk(buf.Bytes())
+			if err != nil {
+				t.Fatalf("level %d: %v", level, err)
+			}
+			if (s.End+7)/8 != buf.Len() || s.Size != int64(2*len(data)) {
+				t.Errorf("level %d: stream of %d bytes walked %+v", level, buf.Len(), s)
+			}
+		}
+	}
+}
+
+func testInputs() [][]byte {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(random)
+	var text []byte
+	for i := 0; i < 2000; i++ {
+		text = append(text, shortString[:i%len(shortString)]...)
+		text = append(text, byte(i), byte(i>>3))
+	}
+	return [][]byte{shortString, random, text, {'a'}}
+}
+
+func compress(data []byte, level int, t *testing.T) []byte {
+	c, err := libdeflate.NewCompressorLevel(level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, comp, err := c.Compress(data, make([]byte, c.WorstCaseCompressedSize(len(data), libdeflate.ModeDEFLATE)), libdeflate.ModeDEFLATE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return comp
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,67 @@
+package mcproto
+
+import "net"
+
+// Conn reads and writes packets over a net.Conn, e.g. between a proxy and a client or server.
+//
+// ReadPacket and WritePacket may be called concurrently by one reading and one writing goroutine.
+// As reads are buffered, the embedded net.Conn must not be read from directly.
+// Always Close() the Conn to close the connection and free the c memory of the codecs.
+type Conn struct {
+	net.Conn
+	r *Reader
+	w *Writer
+}
+
+// NewConn returns a new Conn exchanging packets over c with compression disabled.
+// Written packets are compressed at the given level once a threshold is set.
+// Errors if out of memory or if an invalid compression level was passed.
+func NewConn(c net.Conn, level int) (*Conn, error) {
+	w, err := NewWriter(c, level)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(c)
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+	return &Conn{Conn: c, r: r, w: w}, nil
+}
+
+// ReadPacket reads the next packet. See Reader.ReadPacket.
+func (c *Conn) ReadPacket() (Packet, error) {
+	return c.r.ReadPacket()
+}
+
+// WritePacket writes the packet p. See Writer.WritePacket.
+func (c *Conn) WritePacket(p Packet) error {
+	return c.w.WritePacket(p)
+}
+
+// SetThreshold sets the compression threshold of both directions, which applies to all packets
+// after the Set Compression packet. It must not be called concurrently with ReadPacket or WritePacket.
+// A negative threshold disables compression.
+func (c *Conn) SetThreshold(threshold int) {
+	c.SetReadThreshold(threshold)
+	c.SetWriteThreshold(threshold)
+}
+
+// SetReadThreshold sets the compression threshold of read packets. It must not be called concurrently with ReadPacket,
+// so the reading goroutine of a proxy can apply a Set Compression packet it reads right away.
+func (c *Conn) SetReadThreshold(threshold int) {
+	c.r.SetThreshold(threshold)
+}
+
+// SetWriteThreshold sets the compression threshold of written packets. It must not be called concurrently with WritePacket.
+func (c *Conn) SetWriteThreshold(threshold int) {
+	c.w.SetThreshold(threshold)
+}
+
+// Close closes the connection and frees the codecs. The Conn must not be used afterwards.
+func (c *Conn) Close() error {
+	err := c.Conn.Close()
+	c.r.Close()
+	c.w.Close()
+	return err
+}
@@ -0,0 +1,12 @@
+package mcproto
+
+import "errors"
+
+// ErrPacketTooLarge is returned if a packet exceeds MaxPacketLength or its uncompressed data exceeds MaxDataLength.
+var ErrPacketTooLarge = errors.New("libdeflate: mcproto: packet too large")
+
+var (
+	errorVarIntTooLong   = errors.New("libdeflate: mcproto: VarInt too long")
+	errorBadPacket       = errors.New("libdeflate: mcproto: malformed packet")
+	errorBadlyCompressed = errors.New("libdeflate: mcproto: compressed packet below threshold")
+)
@@ -0,0 +1,287 @@
+package mcproto
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestVarInt(t *testing.T) {
+	cases := []struct {
+		v   int32
+		enc []byte
+	}{
+		{0, []byte{0x00}},
+		{1, []byte{0x01}},
+		{127, []byte{0x7f}},
+		{128, []byte{0x80, 0x01}},
+		{255, []byte{0xff, 0x01}},
+		{25565, []byte{0xdd, 0xc7, 0x01}},
+		{2097151, []byte{0xff, 0xff, 0x7f}},
+		{2147483647, []byte{0xff, 0xff, 0xff, 0xff, 0x07}},
+		{-1, []byte{0xff, 0xff, 0xff, 0xff, 0x0f}},
+		{-2147483648, []byte{0x80, 0x80, 0x80, 0x80, 0x08}},
+	}
+	for _, c := range cases {
+		slicesEqual(c.enc, AppendVarInt(nil, c.v), t)
+		if n := VarIntSize(c.v); n != len(c.enc) {
+			t.Errorf("VarIntSize(%d) = %d, expected %d", c.v, n, len(c.enc))
+		}
+
+		v, err := ReadVarInt(bytes.NewReader(c.enc))
+		if err != nil || v != c.v {
+			t.Errorf("ReadVarInt: %d, %v, expected %d", v, err, c.v)
+		}
+		v, n, err := DecodeVarInt(c.enc)
+		if err != nil || v != c.v || n != len(c.enc) {
+			t.Errorf("DecodeVarInt: %d, %d, %v, expected %d", v, n, err, c.v)
+		}
+	}
+
+	if _, err := ReadVarInt(bytes.NewReader([]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x01})); err != errorVarIntTooLong {
+		t.Errorf("expected error for too long VarInt, got %v", err)
+	}
+	if _, err := ReadVarInt(bytes.NewReader([]byte{0x80})); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	if _, err := ReadVarInt(bytes.NewReader(nil)); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	packets := testPackets()
+	for _, threshold := range []int{DisableCompression, 0, 256} {
+		buf := &bytes.Buffer{}
+		w, err := NewWriter(buf, libdeflate.DefaultCompressionLevel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.SetThreshold(threshold)
+		for _, p := range packets {
+			if err := w.WritePacket(p); err != nil {
+				t.Fatal(err)
+			}
+		}
+		w.Close()
+
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.SetThreshold(threshold)
+		for _, expected := range packets {
+			p, err := r.ReadPacket()
+			if err != nil {
+				t.Fatalf("threshold %d: %v", threshold, err)
+			}
+			packetsEqual(expected, p, t)
+		}
+		if _, err := r.ReadPacket(); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+		r.Close()
+	}
+}
+
+func TestWireFormat(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf, libdeflate.DefaultCompressionLevel)
+	defer w.Close()
+
+	// without compression: Packet Length, Packet ID, Data
+	w.WritePacket(Packet{ID: 0x10, Data: []byte{1, 2, 3}})
+	slicesEqual([]byte{4, 0x10, 1, 2, 3}, buf.Bytes(), t)
+
+	// below the threshold: Packet Length, Data Length 0, Packet ID, Data
+	buf.Reset()
+	w.SetThreshold(64)
+	w.WritePacket(Packet{ID: 0x10, Data: []byte{1, 2, 3}})
+	slicesEqual([]byte{5, 0, 0x10, 1, 2, 3}, buf.Bytes(), t)
+
+	// above the threshold: Packet Length, Data Length, zlib compressed Packet ID and Data
+	buf.Reset()
+	w.WritePacket(Packet{ID: 0x20, Data: shortString})
+	r := bytes.NewReader(buf.Bytes())
+	length, _ := ReadVarInt(r)
+	if int(length) != r.Len() {
+		t.Errorf("packet length %d, expected %d", length, r.Len())
+	}
+	dataLength, _ := ReadVarInt(r)
+	if int(dataLength) != 1+len(shortString) {
+		t.Errorf("data length %d, expected %d", dataLength, 1+len(shortString))
+	}
+	zr, err := zlib.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	io.Copy(out, zr)
+	slicesEqual(append([]byte{0x20}, shortString...), out.Bytes(), t)
+}
+
+func TestReadStdCompressed(t *testing.T) {
+	payload := append([]byte{0x21}, bytes.Repeat(shortString, 10)...)
+	comp := &bytes.Buffer{}
+	zw := zlib.NewWriter(comp)
+	zw.Write(payload)
+	zw.Close()
+
+	body := append(AppendVarInt(nil, int32(len(payload))), comp.Bytes()...)
+	packet := append(AppendVarInt(nil, int32(len(body))), body...)
+
+	r, _ := NewReader(bytes.NewReader(packet))
+	defer r.Close()
+	r.SetThreshold(256)
+	p, err := r.ReadPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packetsEqual(Packet{ID: 0x21, Data: payload[1:]}, p, t)
+}
+
+func TestReadErrors(t *testing.T) {
+	comp := &bytes.Buffer{}
+	zw := zlib.NewWriter(comp)
+	zw.Write(shortString)
+	zw.Close()
+	compressed := func(dataLength int32) []byte {
+		body := append(AppendVarInt(nil, dataLength), comp.Bytes()...)
+		return append(AppendVarInt(nil, int32(len(body))), body...)
+	}
+
+	cases := []struct {
+		name     string
+		data     []byte
+		expected error
+	}{
+		{"below threshold", compressed(int32(len(shortString))), errorBadlyCompressed},
+		{"too large", compressed(MaxDataLength + 1), ErrPacketTooLarge},
+		{"packet too large", AppendVarInt(nil, MaxPacketLength+1), ErrPacketTooLarge},
+		{"truncated", []byte{10, 0, 1, 2}, io.ErrUnexpectedEOF},
+		{"empty", []byte{1, 0}, errorBadPacket},
+	}
+	for _, c := range cases {
+		r, _ := NewReader(bytes.NewReader(c.data))
+		r.SetThreshold(1024)
+		if _, err := r.ReadPacket(); err != c.expected {
+			t.Errorf("%s: expected %v, got %v", c.name, c.expected, err)
+		}
+		r.Close()
+	}
+
+	// a wrong declared size fails with the exact buffer
+	r, _ := NewReader(bytes.NewReader(compressed(int32(len(shortString) + 1))))
+	defer r.Close()
+	r.SetThreshold(0)
+	if _, err := r.ReadPacket(); err == nil {
+		t.Error("expected error for wrong data length")
+	}
+}
+
+func TestWriteTooLarge(t *testing.T) {
+	w, _ := NewWriter(ioutil.Discard, libdeflate.DefaultCompressionLevel)
+	defer w.Close()
+	if err := w.WritePacket(Packet{Data: make([]byte, MaxPacketLength)}); err != ErrPacketTooLarge {
+		t.Errorf("expected ErrPacketTooLarge, got %v", err)
+	}
+	w.SetThreshold(0)
+	if err := w.WritePacket(Packet{Data: make([]byte, MaxDataLength)}); err != ErrPacketTooLarge {
+		t.Errorf("expected ErrPacketTooLarge, got %v", err)
+	}
+	// highly compressible data may exceed MaxPacketLength uncompressed
+	if err := w.WritePacket(Packet{Data: make([]byte, MaxPacketLength)}); err != nil {
+		t.Error(err)
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestConn(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client, err := NewConn(c1, libdeflate.DefaultCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := NewConn(c2, libdeflate.MaxCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	const setCompression = 0x03
+	packets := testPackets()
+	done := make(chan error)
+	go func() {
+		// the server enables compression, then echoes all packets
+		err := server.WritePacket(Packet{ID: setCompression, Data: AppendVarInt(nil, 64)})
+		server.SetThreshold(64)
+		for i := 0; i < len(packets) && err == nil; i++ {
+			var p Packet
+			if p, err = server.ReadPacket(); err == nil {
+				err = server.WritePacket(p)
+			}
+		}
+		done <- err
+	}()
+
+	p, err := client.ReadPacket()
+	if err != nil || p.ID != setCompression {
+		t.Fatalf("expected Set Compression, got %v, %v", p, err)
+	}
+	threshold, _, _ := DecodeVarInt(p.Data)
+	client.SetThreshold(int(threshold))
+
+	for _, expected := range packets {
+		if err := client.WritePacket(expected); err != nil {
+			t.Fatal(err)
+		}
+		p, err := client.ReadPacket()
+		if err != nil {
+			t.Fatal(err)
+		}
+		packetsEqual(expected, p, t)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testPackets() []Packet {
+	return []Packet{
+		{ID: 0x00, Data: nil},
+		{ID: 0x01, Data: []byte{1, 2, 3}},
+		{ID: 0x7f, Data: shortString},
+		{ID: 0x80, Data: bytes.Repeat(shortString, 1000)},
+		{ID: 0x22, Data: make([]byte, 255)},
+		{ID: 0x23, Data: make([]byte, 254)},
+	}
+}
+
+func packetsEqual(expected, actual Packet, t *testing.T) {
+	if expected.ID != actual.ID {
+		t.Errorf("packet ID %#x, expected %#x", actual.ID, expected.ID)
+	}
+	slicesEqual(expected.Data, actual.Data, t)
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
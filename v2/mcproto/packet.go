@@ -0,0 +1,30 @@
+// Package mcproto reads and writes packets framed like in the Minecraft Java Edition protocol,
+// compressing them with libdeflate once compression has been negotiated.
+//
+// Every packet is prefixed by its length as a VarInt. Without compression, the packet ID and data follow.
+// After the Set Compression packet, the length is followed by the Data Length VarInt: packets with a size of at least
+// the compression threshold are zlib compressed and Data Length holds their uncompressed size,
+// while smaller packets are sent uncompressed with Data Length 0.
+//
+// As the uncompressed size is declared, the Reader decompresses every packet into a buffer of the exact size.
+package mcproto
+
+// MaxPacketLength is the maximum length of a packet on the wire, which is the largest value of a 3-byte VarInt.
+const MaxPacketLength = 1<<21 - 1
+
+// MaxDataLength is the maximum uncompressed size of a compressed packet accepted by the vanilla server.
+const MaxDataLength = 1 << 23
+
+// DisableCompression is the compression threshold before compression has been negotiated.
+const DisableCompression = -1
+
+// Packet is a single packet of the protocol.
+type Packet struct {
+	ID   int32
+	Data []byte
+}
+
+// size returns the uncompressed size of the packet, which is the size of its ID and data.
+func (p Packet) size() int {
+	return VarIntSize(p.ID) + len(p.Data)
+}
@@ -0,0 +1,125 @@
+package mcproto
+
+import (
+	"bufio"
+	"io"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// Reader reads packets from an underlying io.Reader and decompresses them with a native Decompressor.
+//
+// A single Reader must not be used across multiple threads concurrently.
+// Always Close() the Reader to free the c memory of the underlying Decompressor.
+type Reader struct {
+	r         byteReader
+	dc        libdeflate.Decompressor
+	threshold int
+	buf       []byte // packet as read from the wire
+	payload   []byte // decompressed packet
+}
+
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// NewReader returns a new Reader reading packets from r with compression disabled.
+// If r does not implement io.ByteReader, it is buffered, so the Reader may read beyond the current packet.
+// Errors if out of memory.
+func NewReader(r io.Reader) (*Reader, error) {
+	dc, err := libdeflate.NewDecompressor()
+	if err != nil {
+		return nil, err
+	}
+
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Reader{r: br, dc: dc, threshold: DisableCompression}, nil
+}
+
+// SetThreshold sets the compression threshold, as received in the Set Compression packet.
+// Packets read afterwards are expected to carry the Data Length field. A negative threshold disables compression.
+func (r *Reader) SetThreshold(threshold int) {
+	r.threshold = threshold
+}
+
+// ReadPacket reads the next packet and decompresses it if necessary.
+// The data of the returned packet is only valid until the next call to ReadPacket.
+//
+// Returns io.EOF if the input ends before a packet, and io.ErrUnexpectedEOF if it ends within a packet.
+// Errors with ErrPacketTooLarge if the packet exceeds MaxPacketLength or MaxDataLength.
+func (r *Reader) ReadPacket() (Packet, error) {
+	length, err := ReadVarInt(r.r)
+	if err != nil {
+		return Packet{}, err
+	}
+	if length < 0 {
+		return Packet{}, errorBadPacket
+	}
+	if length > MaxPacketLength {
+		return Packet{}, ErrPacketTooLarge
+	}
+
+	if cap(r.buf) < int(length) {
+		r.buf = make([]byte, length)
+	}
+	r.buf = r.buf[:length]
+	if _, err := io.ReadFull(r.r, r.buf); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Packet{}, err
+	}
+
+	payload := r.buf
+	if r.threshold >= 0 {
+		if payload, err = r.decompress(); err != nil {
+			return Packet{}, err
+		}
+	}
+
+	id, n, err := DecodeVarInt(payload)
+	if err != nil {
+		return Packet{}, errorBadPacket
+	}
+	return Packet{ID: id, Data: payload[n:]}, nil
+}
+
+// decompress parses the Data Length of the packet in buf and returns its uncompressed content.
+func (r *Reader) decompress() ([]byte, error) {
+	dataLength, n, err := DecodeVarInt(r.buf)
+	if err != nil {
+		return nil, errorBadPacket
+	}
+	comp := r.buf[n:]
+	switch {
+	case dataLength == 0:
+		return comp, nil
+	case dataLength < 0:
+		return nil, errorBadPacket
+	case dataLength > MaxDataLength:
+		return nil, ErrPacketTooLarge
+	case int(dataLength) < r.threshold:
+		return nil, errorBadlyCompressed
+	case len(comp) == 0:
+		return nil, errorBadPacket
+	}
+
+	if cap(r.payload) < int(dataLength) {
+		r.payload = make([]byte, dataLength)
+	}
+	r.payload = r.payload[:dataLength]
+	if _, _, err := r.dc.Decompress(comp, r.payload, libdeflate.ModeZlib); err != nil {
+		return nil, err
+	}
+	return r.payload, nil
+}
+
+// Close closes the Reader and frees the underlying Decompressor. It does not close the underlying io.Reader.
+func (r *Reader) Close() error {
+	r.dc.Close()
+	return nil
+}
@@ -0,0 +1,61 @@
+package mcproto
+
+import "io"
+
+// MaxVarIntSize is the maximum size of an encoded VarInt.
+const MaxVarIntSize = 5
+
+// AppendVarInt appends the VarInt encoding of v to b and returns the extended slice.
+// Negative values are encoded as their two's complement and always take MaxVarIntSize bytes.
+func AppendVarInt(b []byte, v int32) []byte {
+	u := uint32(v)
+	for u >= 0x80 {
+		b = append(b, byte(u)|0x80)
+		u >>= 7
+	}
+	return append(b, byte(u))
+}
+
+// VarIntSize returns the number of bytes of the VarInt encoding of v.
+func VarIntSize(v int32) int {
+	n := 1
+	for u := uint32(v); u >= 0x80; u >>= 7 {
+		n++
+	}
+	return n
+}
+
+// ReadVarInt reads a VarInt from r.
+// Returns io.EOF only if no byte could be read, and io.ErrUnexpectedEOF if the input ends within the VarInt.
+func ReadVarInt(r io.ByteReader) (int32, error) {
+	var u uint32
+	for i := 0; i < MaxVarIntSize; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		u |= uint32(b&0x7f) << uint(7*i)
+		if b < 0x80 {
+			return int32(u), nil
+		}
+	}
+	return 0, errorVarIntTooLong
+}
+
+// DecodeVarInt decodes the VarInt at the beginning of b and returns it along with the number of bytes it occupies.
+func DecodeVarInt(b []byte) (int32, int, error) {
+	var u uint32
+	for i := 0; i < MaxVarIntSize; i++ {
+		if i == len(b) {
+			return 0, 0, io.ErrUnexpectedEOF
+		}
+		u |= uint32(b[i]&0x7f) << uint(7*i)
+		if b[i] < 0x80 {
+			return int32(u), i + 1, nil
+		}
+	}
+	return 0, 0, errorVarIntTooLong
+}
@@ -0,0 +1,104 @@
+package mcproto
+
+import (
+	"io"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// maxHeaderSize is the maximum size of the Packet Length and Data Length fields of a compressed packet.
+const maxHeaderSize = 2 * MaxVarIntSize
+
+// Writer writes packets to an underlying io.Writer and compresses them with a native Compressor.
+// Every packet is written with a single call to Write.
+//
+// A single Writer must not be used across multiple threads concurrently.
+// Always Close() the Writer to free the c memory of the underlying Compressor.
+type Writer struct {
+	w         io.Writer
+	c         libdeflate.Compressor
+	threshold int
+	payload   []byte // uncompressed packet ID and data
+	buf       []byte // packet as written to the wire
+}
+
+// NewWriter returns a new Writer writing packets to w with compression disabled.
+// Packets are compressed at the given level once a threshold is set.
+// Errors if out of memory or if an invalid compression level was passed.
+func NewWriter(w io.Writer, level int) (*Writer, error) {
+	c, err := libdeflate.NewCompressorLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, c: c, threshold: DisableCompression}, nil
+}
+
+// SetThreshold sets the compression threshold, as sent in the Set Compression packet.
+// Packets written afterwards carry the Data Length field and are compressed if their size is at least threshold.
+// A negative threshold disables compression.
+func (w *Writer) SetThreshold(threshold int) {
+	w.threshold = threshold
+}
+
+// WritePacket writes the packet p, compressing it if its size reaches the threshold.
+// Errors with ErrPacketTooLarge if the packet exceeds MaxPacketLength or MaxDataLength.
+func (w *Writer) WritePacket(p Packet) error {
+	size := p.size()
+	if w.threshold < 0 || size < w.threshold {
+		return w.writeUncompressed(p, size)
+	}
+	if size > MaxDataLength {
+		return ErrPacketTooLarge
+	}
+
+	w.payload = append(AppendVarInt(w.payload[:0], p.ID), p.Data...)
+	bound := maxHeaderSize + w.c.WorstCaseCompressedSize(size, libdeflate.ModeZlib)
+	if cap(w.buf) < bound {
+		w.buf = make([]byte, bound)
+	}
+	buf := w.buf[:bound]
+	n, _, err := w.c.Compress(w.payload, buf[maxHeaderSize:], libdeflate.ModeZlib)
+	if err != nil {
+		return err
+	}
+
+	length := VarIntSize(int32(size)) + n
+	if length > MaxPacketLength {
+		return ErrPacketTooLarge
+	}
+	// the header is placed right in front of the compressed data
+	var hdr [maxHeaderSize]byte
+	h := AppendVarInt(AppendVarInt(hdr[:0], int32(length)), int32(size))
+	start := maxHeaderSize - len(h)
+	copy(buf[start:], h)
+
+	_, err = w.w.Write(buf[start : maxHeaderSize+n])
+	return err
+}
+
+// writeUncompressed writes p without compression, with Data Length 0 if compression is enabled.
+func (w *Writer) writeUncompressed(p Packet, size int) error {
+	length := size
+	if w.threshold >= 0 {
+		length++
+	}
+	if length > MaxPacketLength {
+		return ErrPacketTooLarge
+	}
+
+	buf := AppendVarInt(w.buf[:0], int32(length))
+	if w.threshold >= 0 {
+		buf = append(buf, 0)
+	}
+	buf = append(AppendVarInt(buf, p.ID), p.Data...)
+	w.buf = buf
+
+	_, err := w.w.Write(buf)
+	return err
+}
+
+// Close closes the Writer and frees the underlying Compressor. It does not close the underlying io.Writer.
+func (w *Writer) Close() error {
+	w.c.Close()
+	return nil
+}
@@ -0,0 +1,184 @@
+package libdeflate
+
+// Member is a single compressed stream found by a MemberIterator, e.g. one member of a multi-member gzip file.
+type Member struct {
+	Data   []byte      // decompressed data of the member
+	Offset int         // offset of the member within the compressed input
+	Size   int         // size of the compressed member, including header and trailer
+	Mode   Mode        // format of the member, useful with ModeAuto
+	Header *GzipHeader // parsed header of gzip members, nil for other formats
+}
+
+// MemberIterator iterates over the back-to-back compressed streams of an input, such as the members of
+// a concatenated gzip file (cat a.gz b.gz > c.gz). See Decompressor.Members.
+//
+// By default, data following the last member that does not look like the start of another member
+// is ignored, like gzip -d does. Use Strict to reject such trailing garbage.
+type MemberIterator struct {
+	dc     Decompressor
+	in     []byte
+	off    int
+	m      Mode
+	strict bool
+	cur    Member
+	last   int // size of the decompressed data of the previous member
+	err    error
+}
+
+// Members returns a MemberIterator over the back-to-back compressed streams of mode m within in.
+// If m is ModeAuto, the format of every member is detected separately.
+//
+// The iterator uses this Decompressor, so the Decompressor must not be used otherwise or closed
+// until the iteration is finished.
+func (dc Decompressor) Members(in []byte, m Mode) *MemberIterator {
+	if m != ModeDEFLATE && m != ModeZlib && m != ModeGzip && m != ModeAuto {
+		panic(errorInvalidModeDecompressor)
+	}
+	return &MemberIterator{dc: dc, in: in, m: m}
+}
+
+// Strict controls whether data after the last member is rejected with ErrTrailingGarbage.
+// It must be called before the first call to Next.
+func (it *MemberIterator) Strict(strict bool) {
+	it.strict = strict
+}
+
+// Next decompresses the next member, which is then available through Member.
+// It returns false when there are no more members or an error occurred, which is available through Err.
+func (it *MemberIterator) Next() bool {
+	if it.err != nil || it.off >= len(it.in) {
+		return false
+	}
+
+	rest := it.in[it.off:]
+	m := it.m
+	if m == ModeAuto {
+		var err error
+		if m, err = DetectMode(rest); err != nil {
+			it.err = err
+			return false
+		}
+	}
+
+	first := it.off == 0
+	if !first && !looksLikeMember(rest, m) {
+		it.stop()
+		return false
+	}
+
+	// the output is sized like the previous member, as neither the trailer at the end of the input
+	// nor the size of the remaining input tell anything about the size of this member
+	hint := it.last
+	if hint == 0 {
+		hint = len(rest)
+		if hint > readSize {
+			hint = readSize
+		}
+		hint *= 4
+	}
+	member := Member{Offset: it.off, Mode: m}
+	var err error
+	if m == ModeGzip {
+		var h GzipHeader
+		member.Size, member.Data, h, _, err = it.dc.decompressGzipWithHeader(rest, nil, hint)
+		member.Header = &h
+	} else {
+		member.Size, member.Data, err = it.dc.DecompressSizeHint(rest, hint, m)
+	}
+
+	if err != nil {
+		// raw DEFLATE has no header to tell trailing garbage from another member
+		if !first && m == ModeDEFLATE && !it.strict {
+			it.off = len(it.in)
+			return false
+		}
+		it.err = err
+		return false
+	}
+
+	member.Data = trimCap(member.Data)
+	it.off += member.Size
+	it.last = len(member.Data)
+	it.cur = member
+	return true
+}
+
+// Member returns the member decompressed by the last call to Next.
+func (it *MemberIterator) Member() Member {
+	return it.cur
+}
+
+// Offset returns the offset of the first byte of the input that has not been consumed by a member yet.
+func (it *MemberIterator) Offset() int {
+	return it.off
+}
+
+// Err returns the first error encountered during the iteration.
+func (it *MemberIterator) Err() error {
+	return it.err
+}
+
+// stop ends the iteration at trailing data that does not form another member.
+func (it *MemberIterator) stop() {
+	if it.strict {
+		it.err = ErrTrailingGarbage
+		return
+	}
+	it.off = len(it.in)
+}
+
+// looksLikeMember reports whether in starts with the header of a member of mode m.
+// Raw DEFLATE data has no header, so any data may be a member.
+func looksLikeMember(in []byte, m Mode) bool {
+	switch m {
+	case ModeGzip, ModeZlib:
+		detected, _ := DetectMode(in)
+		return detected == m
+	default:
+		return true
+	}
+}
+
+// DecompressAll decompresses all back-to-back compressed streams of mode m within in, such as all members
+// of a concatenated gzip file, and returns the concatenation of their decompressed data.
+// If m is ModeAuto, the format of every member is detected separately.
+//
+// Data following the last member that does not look like the start of another member is ignored.
+// See DecompressAllStrict to reject such data.
+// Errors with ErrOutputLimitExceeded if the data of all members exceeds DecompressorOptions.MaxOutputBytes.
+func (dc Decompressor) DecompressAll(in []byte, m Mode) ([]byte, error) {
+	return dc.decompressAll(in, m, false)
+}
+
+// DecompressAllStrict is like DecompressAll, but errors with ErrTrailingGarbage
+// if any data follows the last member.
+func (dc Decompressor) DecompressAllStrict(in []byte, m Mode) ([]byte, error) {
+	return dc.decompressAll(in, m, true)
+}
+
+func (dc Decompressor) decompressAll(in []byte, m Mode, strict bool) ([]byte, error) {
+	if len(in) == 0 {
+		return nil, errorNoInput
+	}
+
+	it := dc.Members(in, m)
+	it.Strict(strict)
+
+	// the limit of the Decompressor applies to every member, so it is enforced for their sum as well
+	limit := dc.dc.MaxOutputBytes()
+	var (
+		out   []byte
+		total int64
+	)
+	for it.Next() {
+		if total += int64(len(it.Member().Data)); limit > 0 && total > limit {
+			return nil, ErrOutputLimitExceeded
+		}
+		if out == nil {
+			out = it.Member().Data
+			continue
+		}
+		out = append(out, it.Member().Data...)
+	}
+	return out, it.Err()
+}
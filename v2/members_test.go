@@ -0,0 +1,133 @@
+package libdeflate
+
+import (
+	"bytes"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestMembers(t *testing.T) {
+	c, _ := NewCompressor()
+	defer c.Close()
+	_, a, _ := c.CompressGzipWithHeader(shortString, nil, GzipHeader{Name: "a"})
+	_, b, _ := c.CompressGzipWithHeader([]byte("second member"), nil, GzipHeader{Name: "b"})
+	in := append(append([]byte{}, a...), b...)
+
+	dc, _ := NewDecompressor()
+	defer dc.Close()
+
+	it := dc.Members(in, ModeGzip)
+	var members []Member
+	for it.Next() {
+		members = append(members, it.Member())
+	}
+	if it.Err() != nil || len(members) != 2 {
+		t.Fatal(it.Err())
+	}
+	if members[1].Offset != len(a) || members[1].Size != len(b) || members[1].Header.Name != "b" {
+		t.Errorf("unexpected member: %+v", members[1])
+	}
+	slicesEqual([]byte("second member"), members[1].Data, t)
+}
+
+func TestMembersSizing(t *testing.T) {
+	// many small members followed by a large one, whose ISIZE ends the input
+	large := make([]byte, 1<<20)
+	rand.New(rand.NewSource(1)).Read(large)
+	_, small, _ := Compress(shortString, nil, ModeGzip)
+	_, last, _ := Compress(large, nil, ModeGzip)
+	in := append(bytes.Repeat(small, 100), last...)
+
+	dc, _ := NewDecompressor()
+	defer dc.Close()
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	it := dc.Members(in, ModeGzip)
+	n := 0
+	for it.Next() {
+		if data := it.Member().Data; cap(data) != len(data) {
+			t.Errorf("member %d: %d bytes in a buffer of %d", n, len(data), cap(data))
+		}
+		n++
+	}
+	runtime.ReadMemStats(&after)
+	if it.Err() != nil || n != 101 {
+		t.Fatalf("%d members: %v", n, it.Err())
+	}
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 8*uint64(len(large)) {
+		t.Errorf("allocated %d bytes for %d bytes of data", alloc, 100*len(shortString)+len(large))
+	}
+}
+
+func TestDecompressAll(t *testing.T) {
+	want := append(append([]byte{}, shortString...), shortString...)
+
+	for _, m := range []Mode{ModeDEFLATE, ModeZlib, ModeGzip} {
+		_, comp, _ := Compress(shortString, nil, m)
+		in := append(append([]byte{}, comp...), comp...)
+
+		out, err := DecompressAll(in, m)
+		if err != nil {
+			t.Error(err)
+		}
+		slicesEqual(want, out, t)
+
+		dc, _ := NewDecompressor()
+		out, err = dc.DecompressAll(in, ModeAuto)
+		if err != nil {
+			t.Error(err)
+		}
+		slicesEqual(want, out, t)
+		dc.Close()
+	}
+}
+
+func TestDecompressAllOutputLimit(t *testing.T) {
+	_, comp, _ := Compress(shortString, nil, ModeGzip)
+	in := bytes.Repeat(comp, 10)
+
+	// every member is below the limit, but all of them exceed it
+	dc, _ := NewDecompressorWithOptions(DecompressorOptions{MaxOutputBytes: int64(5 * len(shortString))})
+	defer dc.Close()
+	if _, err := dc.DecompressAll(in, ModeGzip); err != ErrOutputLimitExceeded {
+		t.Errorf("expected ErrOutputLimitExceeded, got %v", err)
+	}
+	out, err := dc.DecompressAll(in[:5*len(comp)], ModeGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(bytes.Repeat(shortString, 5), out, t)
+}
+
+func TestDecompressAllTrailingGarbage(t *testing.T) {
+	_, comp, _ := Compress(shortString, nil, ModeGzip)
+	in := append(append([]byte{}, comp...), 0, 0, 0, 0)
+
+	dc, _ := NewDecompressor()
+	defer dc.Close()
+
+	out, err := dc.DecompressAll(in, ModeGzip)
+	if err != nil {
+		t.Error(err)
+	}
+	slicesEqual(shortString, out, t)
+
+	if _, err := dc.DecompressAllStrict(in, ModeGzip); err != ErrTrailingGarbage {
+		t.Error(err)
+	}
+	if out, err := dc.DecompressAllStrict(comp, ModeGzip); err != nil || !bytes.Equal(out, shortString) {
+		t.Error(err)
+	}
+
+	// a corrupted member is not garbage
+	corrupted := append(append([]byte{}, comp...), comp[:len(comp)-1]...)
+	if _, err := dc.DecompressAll(corrupted, ModeGzip); err == nil {
+		t.Error("expected error for corrupted member")
+	}
+}
@@ -1,5 +1,7 @@
 package libdeflate
 
+import "encoding/binary"
+
 // Mode specifies the type of compression/decompression such as zlib, gzip and raw DEFLATE
 type Mode int
 
@@ -8,4 +10,45 @@ const (
 	ModeDEFLATE Mode = iota
 	ModeZlib
 	ModeGzip
+
+	// ModeAuto detects the format of the data when decompressing (see DetectMode). It cannot be used for compression.
+	ModeAuto
 )
+
+// zlibFDICT is the flag of the zlib header indicating a preset dictionary.
+const zlibFDICT = 0x20
+
+// DetectMode sniffs the format of the compressed data in:
+// Data starting with the gzip magic bytes 1f 8b is gzip, data starting with a valid zlib header
+// (compression method 8 and a correct header checksum) is zlib and any other data is assumed to be raw DEFLATE.
+// Errors with ErrZlibDictionary if in starts with a zlib header requiring a preset dictionary.
+func DetectMode(in []byte) (Mode, error) {
+	if len(in) < 2 {
+		return ModeDEFLATE, nil
+	}
+	if in[0] == gzipID1 && in[1] == gzipID2 {
+		return ModeGzip, nil
+	}
+	if in[0]&0x0f == gzipCMDeflate && in[0]>>4 <= 7 && binary.BigEndian.Uint16(in)%31 == 0 {
+		if in[1]&zlibFDICT != 0 {
+			return ModeZlib, ErrZlibDictionary
+		}
+		return ModeZlib, nil
+	}
+	return ModeDEFLATE, nil
+}
+
+func (m Mode) String() string {
+	switch m {
+	case ModeDEFLATE:
+		return "DEFLATE"
+	case ModeZlib:
+		return "zlib"
+	case ModeGzip:
+		return "gzip"
+	case ModeAuto:
+		return "auto"
+	default:
+		return "invalid"
+	}
+}
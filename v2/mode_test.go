@@ -0,0 +1,53 @@
+package libdeflate
+
+import (
+	"bytes"
+	"compress/zlib"
+	"testing"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestDetectMode(t *testing.T) {
+	for _, m := range []Mode{ModeDEFLATE, ModeZlib, ModeGzip} {
+		_, comp, _ := Compress(shortString, nil, m)
+		if detected, err := DetectMode(comp); err != nil || detected != m {
+			t.Errorf("detected %v instead of %v: %v", detected, m, err)
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	w, _ := zlib.NewWriterLevelDict(buf, zlib.DefaultCompression, []byte("hello"))
+	w.Write(shortString)
+	w.Close()
+	if _, err := DetectMode(buf.Bytes()); err != ErrZlibDictionary {
+		t.Error(err)
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestDecompressAuto(t *testing.T) {
+	dc, _ := NewDecompressor()
+	defer dc.Close()
+
+	for _, m := range []Mode{ModeDEFLATE, ModeZlib, ModeGzip} {
+		_, comp, _ := Compress(shortString, nil, m)
+
+		c, out, detected, err := dc.DecompressAuto(comp, nil)
+		if err != nil || c != len(comp) || detected != m {
+			t.Errorf("mode %v: detected %v: %v", m, detected, err)
+		}
+		slicesEqual(shortString, out, t)
+
+		out = make([]byte, len(shortString))
+		if _, _, err := dc.Decompress(comp, out, ModeAuto); err != nil {
+			t.Error(err)
+		}
+		slicesEqual(shortString, out, t)
+	}
+}
@@ -57,6 +57,20 @@ func TestCompress(t *testing.T) {
 	slicesEqual([]byte(shortString), decomp, t)
 }
 
+func TestCompressNilOut(t *testing.T) {
+	c, _ := NewCompressor(DefaultCompressionLevel)
+	defer c.Close()
+	for _, in := range [][]byte{shortString, bytes.Repeat(shortString, 100)} {
+		n, comp, err := c.Compress(in, nil, CompressZlib)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(comp) != n {
+			t.Errorf("expected %d compressed bytes, got a slice of %d", n, len(comp))
+		}
+	}
+}
+
 // this test doesn't really say as much as TestCompress
 func TestCompressMeta(t *testing.T) {
 	c, _ := NewCompressor(DefaultCompressionLevel)
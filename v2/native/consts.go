@@ -5,4 +5,6 @@ const (
 	MaxStdZlibCompressionLevel = 9
 	MaxCompressionLevel        = 12
 	DefaultCompressionLevel    = 6
+
+	DefaultMaxDecompressionFactor = 30
 )
@@ -17,27 +17,36 @@ type Decompressor struct {
 	dc                     *C.decomp
 	isClosed               bool
 	maxDecompressionFactor int
+	maxOutputBytes         int64
+	ratios                 ratioHistory
 }
 
-// NewDecompressor returns a new Decompressor with maxDecompressionFactor = 30 or and error if out of memory
+// NewDecompressor returns a new Decompressor with maxDecompressionFactor = DefaultMaxDecompressionFactor or and error if out of memory
 func NewDecompressor() (*Decompressor, error) {
-	return NewDecompressorWithExtendedDecompression(30)
+	return NewDecompressorWithExtendedDecompression(DefaultMaxDecompressionFactor)
 }
 
 // NewDecompressorWithExtendedDecompression returns a new Decompressor with maxDecompressionFactor or and error if out of memory
 func NewDecompressorWithExtendedDecompression(maxDecompressionFactor int) (*Decompressor, error) {
+	return NewDecompressorWithLimits(maxDecompressionFactor, 0)
+}
+
+// NewDecompressorWithLimits returns a new Decompressor with maxDecompressionFactor, which never decompresses
+// to more than maxOutputBytes, or and error if out of memory. maxOutputBytes <= 0 means no limit.
+func NewDecompressorWithLimits(maxDecompressionFactor int, maxOutputBytes int64) (*Decompressor, error) {
 	dc := C.libdeflate_alloc_decompressor()
 	if C.isNull(unsafe.Pointer(dc)) == 1 {
 		return nil, errorOutOfMemory
 	}
 
-	return &Decompressor{dc, false, maxDecompressionFactor}, nil
+	return &Decompressor{dc: dc, maxDecompressionFactor: maxDecompressionFactor, maxOutputBytes: maxOutputBytes}, nil
 }
 
 // Decompress decompresses the given data from in to out and returns out and an error if something went wrong.
 // If error != nil, then the data in out is undefined.
 // If you pass a buffer to out, the size of this buffer must exactly match the length of the decompressed data.
 // If you pass nil as out, this function will allocate a sufficient buffer and return it.
+// Errors with ErrOutputLimitExceeded if the decompressed data would exceed maxOutputBytes.
 // Returns the number of consumed bytes from 'in'
 func (dc *Decompressor) Decompress(in, out []byte, f decompress) (int, []byte, error) {
 	if dc.isClosed {
@@ -48,32 +57,162 @@ func (dc *Decompressor) Decompress(in, out []byte, f decompress) (int, []byte, e
 	}
 
 	if out != nil {
+		if dc.exceedsLimit(len(out)) {
+			return 0, out, ErrOutputLimitExceeded
+		}
 		cons, _, err := dc.decompress(in, out, true, f)
+		if err == nil {
+			dc.ratios.add(cons, len(out))
+		}
 		return cons, out, err
 	}
 
 	cons := 0
 	n := 0
 	decompFactor := 6
+
+	// first guess based on the ratios this decompressor has seen before
+	if size := dc.ratios.guess(len(in)); size > 0 && size <= len(in)*dc.maxDecompressionFactor && !dc.exceedsLimit(size) {
+		out = make([]byte, size)
+		cons, n, err := dc.decompress(in, out, false, f)
+		if err != errorInsufficientSpace {
+			if err == nil {
+				dc.ratios.add(cons, n)
+			}
+			return cons, out[:n], err
+		}
+		for len(in)*decompFactor <= size {
+			decompFactor = nextDecompFactor(decompFactor)
+		}
+	}
+
 	err := errorInsufficientSpace
 	for err == errorInsufficientSpace {
-		out = make([]byte, len(in)*decompFactor)
+		size := len(in) * decompFactor
+		limited := dc.exceedsLimit(size)
+		if limited {
+			size = int(dc.maxOutputBytes)
+		}
+
+		out = make([]byte, size)
 		cons, n, err = dc.decompress(in, out, false, f)
+		if err != errorInsufficientSpace {
+			break
+		}
 
+		if limited {
+			return cons, nil, ErrOutputLimitExceeded
+		}
 		if decompFactor > dc.maxDecompressionFactor {
 			return cons, out, errorInsufficientDecompressionFactor
 		}
+		decompFactor = nextDecompFactor(decompFactor)
+	}
 
-		if decompFactor >= 16 {
-			decompFactor += 3
-			continue
-		}
-		decompFactor += 5
+	if err == nil {
+		dc.ratios.add(cons, n)
+	}
+	return cons, out[:n], err
+}
+
+// DecompressExpectedSize decompresses the given data from in like Decompress with out == nil,
+// but first tries an output buffer of exactly size bytes, e.g. taken from the ISIZE field of a gzip trailer.
+// If size is negative, exceeds len(in) * maxDecompressionFactor or maxOutputBytes or the data does not fit,
+// this falls back to Decompress.
+// Returns the number of consumed bytes from 'in'
+func (dc *Decompressor) DecompressExpectedSize(in []byte, size int, f decompress) (int, []byte, error) {
+	if dc.isClosed {
+		panic(errorAlreadyClosed)
+	}
+	if len(in) == 0 {
+		return 0, nil, errorNoInput
+	}
+	if size < 0 || size > len(in)*dc.maxDecompressionFactor || dc.exceedsLimit(size) {
+		return dc.Decompress(in, nil, f)
 	}
 
+	out := make([]byte, size)
+	cons, n, err := dc.decompress(in, out, false, f)
+	if err == errorInsufficientSpace {
+		return dc.Decompress(in, nil, f)
+	}
+	if err == nil {
+		dc.ratios.add(cons, n)
+	}
 	return cons, out[:n], err
 }
 
+// DecompressSizeHint decompresses the given data from in like Decompress with out == nil,
+// but starts with an output buffer of sizeHint bytes which is doubled until the decompressed data fits.
+// If sizeHint <= 0, len(in) is used instead.
+// Errors if the output would exceed len(in) * maxDecompressionFactor or maxOutputBytes.
+// Returns the number of consumed bytes from 'in'
+func (dc *Decompressor) DecompressSizeHint(in []byte, sizeHint int, f decompress) (int, []byte, error) {
+	if dc.isClosed {
+		panic(errorAlreadyClosed)
+	}
+	if len(in) == 0 {
+		return 0, nil, errorNoInput
+	}
+
+	max := len(in) * dc.maxDecompressionFactor
+	maxErr := errorInsufficientDecompressionFactor
+	if dc.exceedsLimit(max) {
+		max = int(dc.maxOutputBytes)
+		maxErr = ErrOutputLimitExceeded
+	}
+
+	size := sizeHint
+	if size <= 0 {
+		size = len(in)
+	}
+	for {
+		if size > max {
+			size = max
+		}
+
+		out := make([]byte, size)
+		cons, n, err := dc.decompress(in, out, false, f)
+		if err != errorInsufficientSpace {
+			if err == nil {
+				dc.ratios.add(cons, n)
+			}
+			return cons, out[:n], err
+		}
+		if size == max {
+			return cons, nil, maxErr
+		}
+		size *= 2
+	}
+}
+
+// DecodesAtLeast reports whether the data in decompresses to at least size bytes before it ends or turns out to be invalid.
+// The output is discarded and the limits of this Decompressor do not apply.
+func (dc *Decompressor) DecodesAtLeast(in []byte, size int, f decompress) bool {
+	if dc.isClosed {
+		panic(errorAlreadyClosed)
+	}
+	if len(in) == 0 || size < 0 {
+		return size <= 0
+	}
+	out := make([]byte, size)
+	_, n, err := dc.decompress(in, out, false, f)
+	return err == errorInsufficientSpace || err == nil && n == size
+}
+
+// MaxOutputBytes returns the limit of the size of the decompressed data, or 0 if there is no limit.
+func (dc *Decompressor) MaxOutputBytes() int64 {
+	if dc.maxOutputBytes < 0 {
+		return 0
+	}
+	return dc.maxOutputBytes
+}
+
+// exceedsLimit reports whether an output of size bytes would exceed maxOutputBytes.
+func (dc *Decompressor) exceedsLimit(size int) bool {
+	return dc.maxOutputBytes > 0 && int64(size) > dc.maxOutputBytes
+}
+
 func (dc *Decompressor) decompress(in, out []byte, fit bool, f decompress) (int, int, error) {
 	inAddr := startMemAddr(in)
 	outAddr := startMemAddr(out)
@@ -72,3 +72,63 @@ func TestDecompressOversizedInput(t *testing.T) {
 	}
 	slicesEqual(shortString, out, t)
 }
+
+func TestDecompressSizeHint(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := zlib.NewWriter(buf)
+	w.Write(bytes.Repeat(shortString, 50))
+	w.Close()
+	in := buf.Bytes()
+
+	dc, _ := NewDecompressorWithExtendedDecompression(1032)
+	defer dc.Close()
+	c, out, err := dc.DecompressSizeHint(in, 1, DecompressZlib)
+	if err != nil || c != len(in) {
+		t.Error(err)
+	}
+	slicesEqual(bytes.Repeat(shortString, 50), out, t)
+
+	dc2, _ := NewDecompressorWithExtendedDecompression(2)
+	defer dc2.Close()
+	if _, _, err := dc2.DecompressSizeHint(in, 0, DecompressZlib); err != errorInsufficientDecompressionFactor {
+		t.Error(err)
+	}
+}
+
+func TestDecompressExpectedSize(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := zlib.NewWriter(buf)
+	w.Write([]byte(shortString))
+	w.Close()
+	in := buf.Bytes()
+
+	dc, _ := NewDecompressor()
+	defer dc.Close()
+	for _, size := range []int{len(shortString), len(shortString) + 10, 3, -1} {
+		c, out, err := dc.DecompressExpectedSize(in, size, DecompressZlib)
+		if err != nil || c != len(in) {
+			t.Error(err)
+		}
+		slicesEqual(shortString, out, t)
+	}
+}
+
+func TestRatioHistory(t *testing.T) {
+	var h ratioHistory
+	if h.guess(100) != 0 {
+		t.Error("expected no guess without history")
+	}
+
+	h.add(10, 50)
+	h.add(10, 200)
+	if g := h.guess(10); g < 200 {
+		t.Errorf("guess %d smaller than largest observed output", g)
+	}
+
+	for i := 0; i < len(h.ratios); i++ {
+		h.add(10, 20)
+	}
+	if g := h.guess(10); g < 20 || g >= 200 {
+		t.Errorf("guess %d does not reflect recent ratios", g)
+	}
+}
@@ -14,6 +14,9 @@ var (
 	errorInsufficientDecompressionFactor = errors.New("libdeflate: native: your compressed data seems to be extraordinarily large when decompressed. " +
 		"However, this could also indicate corrupted data. The current maximum decompression factor does not allow for larger decompression, try to increase it")
 
+	// ErrOutputLimitExceeded is returned if the decompressed data would exceed the output limit of a Decompressor
+	ErrOutputLimitExceeded = errors.New("libdeflate: native: decompressed data exceeds the maximum output size of the decompressor")
+
 	// checked error (in native)
 	errorInsufficientSpace = errors.New("libdeflate: native: buffer too short. Retry with larger buffer")
 )
@@ -0,0 +1,42 @@
+package native
+
+// ratioHistory remembers the decompression ratios most recently observed by a Decompressor,
+// so that the first output buffer allocated for data of unknown size is likely to fit.
+type ratioHistory struct {
+	ratios [8]float64
+	n      int
+}
+
+// add records the ratio of a successful decompression of in bytes to out bytes.
+func (h *ratioHistory) add(in, out int) {
+	if in <= 0 {
+		return
+	}
+	h.ratios[h.n%len(h.ratios)] = float64(out) / float64(in)
+	h.n++
+}
+
+// guess returns the output size to try first for in bytes of compressed data
+// or 0 if no ratios have been recorded yet.
+// It is based on the largest recorded ratio with 10% headroom.
+func (h *ratioHistory) guess(in int) int {
+	if h.n == 0 {
+		return 0
+	}
+
+	max := 0.0
+	for _, r := range h.ratios {
+		if r > max {
+			max = r
+		}
+	}
+	return int(float64(in)*max*1.1) + 64
+}
+
+// nextDecompFactor returns the decompression factor to try after decompFactor did not suffice.
+func nextDecompFactor(decompFactor int) int {
+	if decompFactor >= 16 {
+		return decompFactor + 3
+	}
+	return decompFactor + 5
+}
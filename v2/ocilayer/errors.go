@@ -0,0 +1,15 @@
+package ocilayer
+
+import "errors"
+
+var (
+	// ErrDigestMismatch is returned by Verify if the sha256 of the compressed layer differs from the expected digest.
+	ErrDigestMismatch = errors.New("libdeflate: ocilayer: digest mismatch")
+	// ErrDiffIDMismatch is returned by Verify if the sha256 of the uncompressed layer differs from the expected diffID.
+	ErrDiffIDMismatch = errors.New("libdeflate: ocilayer: diffID mismatch")
+
+	errorAlgorithm     = errors.New("libdeflate: ocilayer: unsupported digest algorithm")
+	errorFileType      = errors.New("libdeflate: ocilayer: unsupported file type")
+	errorDuplicateName = errors.New("libdeflate: ocilayer: duplicate file name")
+	errorWriterClosed  = errors.New("libdeflate: ocilayer: writer already closed")
+)
@@ -0,0 +1,178 @@
+package ocilayer
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// epoch is the modification time of all files written by BuildFiles.
+var epoch = time.Unix(0, 0)
+
+// decompressors are shared by all verifications. Layers may compress extremely well,
+// so the maximum decompression factor is used.
+var decompressors, _ = libdeflate.NewDecompressorPool(libdeflate.PoolOptions{}, libdeflate.DecompressorOptions{
+	MaxDecompressionFactor: libdeflate.MaxPossibleDecompressionFactor,
+})
+
+// File is a file on disk to add to a layer with BuildFiles.
+type File struct {
+	Name   string // path of the file within the layer, using slashes
+	Source string // path of the file on disk. Symbolic links are added as links, not followed
+}
+
+// Build compresses the tar stream r into a layer at the given level on the given number of workers, writing to w.
+// The tar stream is not modified, so it must be reproducible itself for the layer to be reproducible.
+// Errors if reading r or writing w fails or if an invalid compression level was passed.
+func Build(w io.Writer, r io.Reader, level, workers int) (Layer, error) {
+	lw, err := NewWriter(w, level, workers)
+	if err != nil {
+		return Layer{}, err
+	}
+	if _, err := io.Copy(lw, r); err != nil {
+		lw.Close()
+		return Layer{}, err
+	}
+	if err := lw.Close(); err != nil {
+		return Layer{}, err
+	}
+	return lw.Layer(), nil
+}
+
+// BuildFiles writes a normalized tar archive of the given files into a layer at the given level on the given
+// number of workers, writing to w. The files are sorted by name, and their modification times, owners and
+// groups are reset, so only their names, contents, types and permissions end up in the layer.
+// Regular files, directories and symbolic links are supported. Parent directories are not added implicitly.
+// Errors if a file cannot be read, has an unsupported type or is listed twice.
+func BuildFiles(w io.Writer, files []File, level, workers int) (Layer, error) {
+	sorted := make([]File, len(files))
+	for i, f := range files {
+		sorted[i] = File{Name: strings.TrimPrefix(path.Clean("/"+f.Name), "/"), Source: f.Source}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	for i := 1; i < len(sorted); i++ {
+		if sorted[i].Name == sorted[i-1].Name {
+			return Layer{}, errorDuplicateName
+		}
+	}
+
+	lw, err := NewWriter(w, level, workers)
+	if err != nil {
+		return Layer{}, err
+	}
+	tw := tar.NewWriter(lw)
+	for _, f := range sorted {
+		if err = writeFile(tw, f); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		lw.Close()
+		return Layer{}, err
+	}
+	if err := lw.Close(); err != nil {
+		return Layer{}, err
+	}
+	return lw.Layer(), nil
+}
+
+// writeFile writes the normalized tar header and the content of f.
+func writeFile(tw *tar.Writer, f File) error {
+	fi, err := os.Lstat(f.Source)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    f.Name,
+		Mode:    int64(fi.Mode().Perm()),
+		ModTime: epoch,
+	}
+	switch {
+	case fi.Mode().IsRegular():
+		hdr.Typeflag = tar.TypeReg
+		hdr.Size = fi.Size()
+	case fi.IsDir():
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+	case fi.Mode()&os.ModeSymlink != 0:
+		hdr.Typeflag = tar.TypeSymlink
+		if hdr.Linkname, err = os.Readlink(f.Source); err != nil {
+			return err
+		}
+	default:
+		return errorFileType
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if hdr.Typeflag != tar.TypeReg {
+		return nil
+	}
+
+	file, err := os.Open(f.Source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	// the file must not change its size between Lstat and reading
+	if _, err := io.CopyN(tw, file, hdr.Size); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Verify decompresses the gzip compressed layer and checks its digest and diffID, which are skipped if empty.
+// It returns the digests and sizes of the layer, even if they do not match.
+// Errors with ErrDigestMismatch or ErrDiffIDMismatch if a digest differs,
+// or if a digest does not use sha256 or the layer is not valid gzip data.
+func Verify(layer []byte, digest, diffID string) (Layer, error) {
+	for _, d := range []string{digest, diffID} {
+		if d != "" && !strings.HasPrefix(d, "sha256:") {
+			return Layer{}, errorAlgorithm
+		}
+	}
+
+	sum := sha256.Sum256(layer)
+	l := Layer{Digest: "sha256:" + hex.EncodeToString(sum[:]), Size: int64(len(layer))}
+	if digest != "" && digest != l.Digest {
+		return l, ErrDigestMismatch
+	}
+
+	dc, err := decompressors.Get()
+	if err != nil {
+		return l, err
+	}
+	defer decompressors.Put(dc)
+
+	if len(layer) == 0 {
+		return l, io.ErrUnexpectedEOF
+	}
+	// layers written by other tools may consist of several concatenated members
+	h := sha256.New()
+	it := dc.Members(layer, libdeflate.ModeGzip)
+	it.Strict(true)
+	for it.Next() {
+		data := it.Member().Data
+		h.Write(data)
+		l.DiffSize += int64(len(data))
+	}
+	if err := it.Err(); err != nil {
+		return l, err
+	}
+	l.DiffID = "sha256:" + hex.EncodeToString(h.Sum(nil))
+	if diffID != "" && diffID != l.DiffID {
+		return l, ErrDiffIDMismatch
+	}
+	return l, nil
+}
@@ -0,0 +1,330 @@
+package ocilayer
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestWriterDeterministic(t *testing.T) {
+	data := testData(3*ChunkSize + ChunkSize/2)
+	var first []byte
+	for _, workers := range []int{1, 2, 3, 7} {
+		blob, layer := compress(data, libdeflate.DefaultCompressionLevel, workers, t)
+		if first == nil {
+			first = blob
+		}
+		slicesEqual(first, blob, t)
+		checkLayer(blob, data, layer, t)
+	}
+}
+
+func TestWriterDeterministicChunkMultiples(t *testing.T) {
+	// data ending with a full chunk is buffered until Close by some worker counts, but not by others
+	for _, chunks := range []int{1, 2, 4} {
+		data := testData(chunks * ChunkSize)
+		var first Layer
+		for _, workers := range []int{1, 2, 3, 4} {
+			blob, layer := compress(data, libdeflate.DefaultCompressionLevel, workers, t)
+			if workers == 1 {
+				first = layer
+			}
+			if layer.Digest != first.Digest {
+				t.Errorf("%d chunks on %d workers: digest %s, expected %s", chunks, workers, layer.Digest, first.Digest)
+			}
+			checkLayer(blob, data, layer, t)
+		}
+	}
+}
+
+func TestWriterSizes(t *testing.T) {
+	for _, size := range []int{0, 1, ChunkSize - 1, ChunkSize, 2 * ChunkSize, 2*ChunkSize + 1} {
+		data := testData(size)
+		for _, level := range []int{libdeflate.MinCompressionLevel, libdeflate.MaxCompressionLevel} {
+			blob, layer := compress(data, level, 2, t)
+			checkLayer(blob, data, layer, t)
+		}
+	}
+}
+
+func TestHeader(t *testing.T) {
+	blob, _ := compress(shortString, libdeflate.DefaultCompressionLevel, 1, t)
+	h, _, err := libdeflate.ParseGzipHeader(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !h.ModTime.IsZero() || h.Name != "" || h.OS != libdeflate.GzipOSUnknown || h.Extra != nil {
+		t.Errorf("header not normalized: %+v", h)
+	}
+}
+
+func TestWriterClosed(t *testing.T) {
+	lw, _ := NewWriter(ioutil.Discard, libdeflate.DefaultCompressionLevel, 1)
+	lw.Close()
+	if _, err := lw.Write(shortString); err != errorWriterClosed {
+		t.Errorf("expected errorWriterClosed, got %v", err)
+	}
+	if err := lw.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if _, err := NewWriter(ioutil.Discard, 13, 1); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+func TestBuildFiles(t *testing.T) {
+	dir := tempDir(t)
+	os.Mkdir(filepath.Join(dir, "etc"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "etc", "hosts"), shortString, 0644)
+	ioutil.WriteFile(filepath.Join(dir, "big"), testData(ChunkSize+100), 0600)
+	os.Symlink("etc/hosts", filepath.Join(dir, "link"))
+
+	files := []File{
+		{Name: "/etc/hosts", Source: filepath.Join(dir, "etc", "hosts")},
+		{Name: "usr/big", Source: filepath.Join(dir, "big")},
+		{Name: "etc", Source: filepath.Join(dir, "etc")},
+		{Name: "hosts", Source: filepath.Join(dir, "link")},
+	}
+	buf := &bytes.Buffer{}
+	layer, err := BuildFiles(buf, files, libdeflate.DefaultCompressionLevel, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// neither the order of the list nor the timestamps on disk change the layer
+	future := time.Now().Add(time.Hour)
+	os.Chtimes(filepath.Join(dir, "big"), future, future)
+	files[0], files[3] = files[3], files[0]
+	buf2 := &bytes.Buffer{}
+	layer2, err := BuildFiles(buf2, files, libdeflate.DefaultCompressionLevel, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layer != layer2 {
+		t.Errorf("layers differ: %+v, %+v", layer, layer2)
+	}
+	slicesEqual(buf.Bytes(), buf2.Bytes(), t)
+
+	tr := tar.NewReader(bytes.NewReader(decompress(buf.Bytes(), t)))
+	for _, name := range []string{"etc/", "etc/hosts", "hosts", "usr/big"} {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Name != name || !hdr.ModTime.Equal(epoch) || hdr.Uid != 0 || hdr.Uname != "" {
+			t.Errorf("unexpected header %+v", hdr)
+		}
+		switch name {
+		case "etc/hosts":
+			content, _ := ioutil.ReadAll(tr)
+			slicesEqual(shortString, content, t)
+		case "hosts":
+			if hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "etc/hosts" {
+				t.Errorf("unexpected link %+v", hdr)
+			}
+		case "usr/big":
+			if hdr.Mode != 0600 || hdr.Size != ChunkSize+100 {
+				t.Errorf("unexpected file %+v", hdr)
+			}
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("expected end of archive, got %v", err)
+	}
+}
+
+func TestBuildFilesErrors(t *testing.T) {
+	dir := tempDir(t)
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, shortString, 0644)
+
+	files := []File{{Name: "a/file", Source: file}, {Name: "/a//file", Source: file}}
+	if _, err := BuildFiles(ioutil.Discard, files, libdeflate.DefaultCompressionLevel, 1); err != errorDuplicateName {
+		t.Errorf("expected errorDuplicateName, got %v", err)
+	}
+	files = []File{{Name: "missing", Source: filepath.Join(dir, "missing")}}
+	if _, err := BuildFiles(ioutil.Discard, files, libdeflate.DefaultCompressionLevel, 1); !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	data := testData(ChunkSize + 1000)
+	blob, layer := compress(data, libdeflate.DefaultCompressionLevel, 2, t)
+
+	l, err := Verify(blob, layer.Digest, layer.DiffID)
+	if err != nil || l != layer {
+		t.Fatalf("verify %+v: %v", l, err)
+	}
+	if l, err := Verify(blob, "", ""); err != nil || l != layer {
+		t.Errorf("verify without digests %+v: %v", l, err)
+	}
+
+	other := "sha256:" + hex.EncodeToString(make([]byte, sha256.Size))
+	if _, err := Verify(blob, other, layer.DiffID); err != ErrDigestMismatch {
+		t.Errorf("expected ErrDigestMismatch, got %v", err)
+	}
+	if _, err := Verify(blob, layer.Digest, other); err != ErrDiffIDMismatch {
+		t.Errorf("expected ErrDiffIDMismatch, got %v", err)
+	}
+	if _, err := Verify(blob, "sha512:00", ""); err != errorAlgorithm {
+		t.Errorf("expected errorAlgorithm, got %v", err)
+	}
+
+	corrupt := append([]byte{}, blob...)
+	corrupt[len(corrupt)-100] ^= 0xff
+	if _, err := Verify(corrupt, "", ""); err == nil {
+		t.Error("expected error for corrupt layer")
+	}
+	if _, err := Verify(nil, "", ""); err == nil {
+		t.Error("expected error for empty layer")
+	}
+	if _, err := Verify(append(blob, 0), "", ""); err == nil {
+		t.Error("expected error for trailing garbage")
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestBuildStdTar(t *testing.T) {
+	archive := &bytes.Buffer{}
+	tw := tar.NewWriter(archive)
+	random := make([]byte, 3*ChunkSize/2)
+	rand.New(rand.NewSource(1)).Read(random)
+	for i, content := range [][]byte{shortString, random, testData(2 * ChunkSize)} {
+		tw.WriteHeader(&tar.Header{Name: string(rune('a' + i)), Mode: 0644, Size: int64(len(content)), ModTime: epoch})
+		tw.Write(content)
+	}
+	tw.Close()
+
+	buf := &bytes.Buffer{}
+	layer, err := Build(buf, bytes.NewReader(archive.Bytes()), libdeflate.DefaultCompressionLevel, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkLayer(buf.Bytes(), archive.Bytes(), layer, t)
+}
+
+func TestVerifyStdGzip(t *testing.T) {
+	data := testData(100000)
+	var blob []byte
+	// a layer of two gzip members, as written by tools that concatenate compressed parts
+	for _, part := range [][]byte{data[:50000], data[50000:]} {
+		buf := &bytes.Buffer{}
+		zw := gzip.NewWriter(buf)
+		zw.Write(part)
+		zw.Close()
+		blob = append(blob, buf.Bytes()...)
+	}
+
+	diffID := sha256.Sum256(data)
+	l, err := Verify(blob, "", "sha256:"+hex.EncodeToString(diffID[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.DiffSize != int64(len(data)) || l.Size != int64(len(blob)) {
+		t.Errorf("unexpected sizes %+v", l)
+	}
+}
+
+// checkLayer checks blob against the data with the standard library and the digests of layer.
+func checkLayer(blob, data []byte, layer Layer, t *testing.T) {
+	slicesEqual(data, decompress(blob, t), t)
+	digest, diffID := sha256.Sum256(blob), sha256.Sum256(data)
+	expected := Layer{
+		Digest:   "sha256:" + hex.EncodeToString(digest[:]),
+		DiffID:   "sha256:" + hex.EncodeToString(diffID[:]),
+		Size:     int64(len(blob)),
+		DiffSize: int64(len(data)),
+	}
+	if layer != expected {
+		t.Errorf("expected %+v, got %+v", expected, layer)
+	}
+}
+
+func compress(data []byte, level, workers int, t *testing.T) ([]byte, Layer) {
+	buf := &bytes.Buffer{}
+	lw, err := NewWriter(buf, level, workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// odd write sizes to cross chunk boundaries
+	for len(data) > 0 {
+		n := 77777
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := lw.Write(data[:n]); err != nil {
+			t.Fatal(err)
+		}
+		data = data[n:]
+	}
+	if err := lw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes(), lw.Layer()
+}
+
+// decompress decompresses a single gzip member with the standard library.
+func decompress(blob []byte, t *testing.T) []byte {
+	r := bytes.NewReader(blob)
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr.Multistream(false)
+	out, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Len() != 0 {
+		t.Errorf("%d bytes after the first member", r.Len())
+	}
+	return out
+}
+
+func testData(n int) []byte {
+	buf := &bytes.Buffer{}
+	for i := 0; buf.Len() < n; i++ {
+		buf.WriteString("layer file ")
+		buf.WriteByte(byte('a' + i%26))
+		buf.WriteByte(byte(i >> 5))
+		buf.Write(shortString[:i%len(shortString)])
+	}
+	return buf.Bytes()[:n]
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "ocilayer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,262 @@
+// Package ocilayer builds reproducible gzip compressed OCI and Docker image layers with libdeflate,
+// computing their digest and diffID in the same pass.
+//
+// A layer is a tar archive compressed with gzip. Its digest is the sha256 of the compressed blob, which names
+// the blob in a registry, and its diffID is the sha256 of the uncompressed tar, which is listed in the image config.
+//
+// The Writer writes a gzip header without timestamp and file name and compresses chunks of ChunkSize bytes
+// in parallel. The chunks are joined into a single gzip member the same way regardless of the number of workers,
+// so identical tar streams always yield identical layers.
+package ocilayer
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/4kills/go-libdeflate/v2"
+	"github.com/4kills/go-libdeflate/v2/internal/bitstream"
+)
+
+// ChunkSize is the size of the uncompressed chunks that are compressed independently.
+// It is fixed, as the compressed layer depends on it.
+const ChunkSize = libdeflate.DefaultChunkSize
+
+// emptyStream is a final fixed Huffman block without data. It ends the DEFLATE stream
+// if the data ends with a full chunk.
+var emptyStream = []byte{0x03, 0x00}
+
+// Layer describes a compressed layer.
+type Layer struct {
+	Digest   string // "sha256:" followed by the hex encoded sha256 of the compressed layer
+	DiffID   string // "sha256:" followed by the hex encoded sha256 of the uncompressed tar
+	Size     int64  // size of the compressed layer
+	DiffSize int64  // size of the uncompressed tar
+}
+
+// Writer compresses a tar stream into a gzip layer. One chunk per worker is buffered and compressed in parallel
+// as a raw DEFLATE stream on its own, while the uncompressed data is hashed.
+// The final block of every full chunk is turned into a non-final block followed by an empty stored block,
+// so the chunks form a single DEFLATE stream. It is ended by the final block of the last chunk if that one
+// is not full, and by emptyStream otherwise, which does not depend on when the chunks were compressed.
+//
+// Always Close() the Writer to write the remaining data and the gzip trailer and to free the c memory of its Compressors.
+type Writer struct {
+	w     io.Writer
+	comps []libdeflate.Compressor
+	level int
+	buf   []byte   // data not compressed yet, up to one chunk per worker
+	out   [][]byte // compressed chunks
+
+	digest hash.Hash
+	diffID hash.Hash
+	crc    uint32
+	layer  Layer
+
+	closed bool
+	err    error
+}
+
+// NewWriter returns a new Writer compressing data at the given level on the given number of workers, writing to w.
+// If workers <= 0, GOMAXPROCS workers are used. The number of workers does not change the compressed layer.
+// Errors if out of memory or if an invalid compression level was passed.
+func NewWriter(w io.Writer, level, workers int) (*Writer, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	comps := make([]libdeflate.Compressor, 0, workers)
+	for i := 0; i < workers; i++ {
+		c, err := libdeflate.NewCompressorLevel(level)
+		if err != nil {
+			for _, c := range comps {
+				c.Close()
+			}
+			return nil, err
+		}
+		comps = append(comps, c)
+	}
+	return &Writer{
+		w:      w,
+		comps:  comps,
+		level:  level,
+		buf:    make([]byte, 0, workers*ChunkSize),
+		out:    make([][]byte, workers),
+		digest: sha256.New(),
+		diffID: sha256.New(),
+	}, nil
+}
+
+// Write buffers p and compresses it once there is a full chunk for every worker.
+func (lw *Writer) Write(p []byte) (int, error) {
+	if lw.closed {
+		return 0, errorWriterClosed
+	}
+	if lw.err != nil {
+		return 0, lw.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := cap(lw.buf) - len(lw.buf)
+		if n > len(p) {
+			n = len(p)
+		}
+		lw.buf = append(lw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(lw.buf) == cap(lw.buf) {
+			if err := lw.writeChunks(false); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close compresses the remaining data, writes the gzip trailer and frees the Compressors.
+// It does not close the underlying writer. Returns the first error that occurred while writing.
+func (lw *Writer) Close() error {
+	if lw.closed {
+		return lw.err
+	}
+	if lw.err == nil {
+		lw.err = lw.writeChunks(true)
+	}
+	for _, c := range lw.comps {
+		c.Close()
+	}
+	lw.closed = true
+	return lw.err
+}
+
+// Layer returns the digests and sizes of the layer. It is complete after Close returned without error.
+func (lw *Writer) Layer() Layer {
+	layer := lw.layer
+	layer.Digest = "sha256:" + hex.EncodeToString(lw.digest.Sum(nil))
+	layer.DiffID = "sha256:" + hex.EncodeToString(lw.diffID.Sum(nil))
+	return layer
+}
+
+// writeChunks compresses all buffered data in chunks in parallel and writes them in order.
+// If last is set, the DEFLATE stream is ended and the trailer is written.
+func (lw *Writer) writeChunks(last bool) error {
+	n := len(lw.buf)
+	chunks := (n + ChunkSize - 1) / ChunkSize
+	// only a partial chunk can be the last one, so full chunks are compressed the same way whenever they are written
+	partial := last && n%ChunkSize != 0
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Crc32 returns 0 for empty data instead of the running checksum
+		if n > 0 {
+			lw.crc = libdeflate.Crc32(lw.crc, lw.buf)
+		}
+		lw.diffID.Write(lw.buf)
+	}()
+	for i := 0; i < chunks; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			end := (i + 1) * ChunkSize
+			if end > n {
+				end = n
+			}
+			out, err := compressChunk(lw.comps[i], lw.buf[i*ChunkSize:end], lw.out[i], partial && i == chunks-1)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			lw.out[i] = out
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		lw.err = firstErr
+		return firstErr
+	}
+
+	if err := lw.writeHeader(); err != nil {
+		return err
+	}
+	for i := 0; i < chunks; i++ {
+		if err := lw.write(lw.out[i]); err != nil {
+			return err
+		}
+	}
+	lw.layer.DiffSize += int64(n)
+	lw.buf = lw.buf[:0]
+	if !last {
+		return nil
+	}
+	if !partial {
+		if err := lw.write(emptyStream); err != nil {
+			return err
+		}
+	}
+	return lw.writeTrailer()
+}
+
+// writeHeader writes the gzip header before the first compressed data: no timestamp, no file name
+// and an unknown OS, like the layers built by the Docker and containerd tooling.
+func (lw *Writer) writeHeader() error {
+	if lw.layer.Size > 0 {
+		return nil
+	}
+	hdr, err := (&libdeflate.GzipHeader{OS: libdeflate.GzipOSUnknown, ExtraFlags: libdeflate.GzipExtraFlags(lw.level)}).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return lw.write(hdr)
+}
+
+func (lw *Writer) writeTrailer() error {
+	var trailer [8]byte
+	binary.LittleEndian.PutUint32(trailer[:], lw.crc)
+	binary.LittleEndian.PutUint32(trailer[4:], uint32(lw.layer.DiffSize))
+	return lw.write(trailer[:])
+}
+
+// write writes b to the underlying writer and adds it to the digest.
+func (lw *Writer) write(b []byte) error {
+	n, err := lw.w.Write(b)
+	lw.digest.Write(b[:n])
+	lw.layer.Size += int64(n)
+	if err != nil {
+		lw.err = err
+	}
+	return err
+}
+
+// compressChunk compresses a chunk as raw DEFLATE stream, reusing the buffer out if it is large enough.
+// Unless the chunk is the last one, the stream is unfinished so that the next chunk may follow it.
+func compressChunk(c libdeflate.Compressor, chunk, out []byte, last bool) ([]byte, error) {
+	size := c.WorstCaseCompressedSize(len(chunk), libdeflate.ModeDEFLATE)
+	if cap(out) < size {
+		out = make([]byte, size)
+	}
+	_, comp, err := c.Compress(chunk, out[:size], libdeflate.ModeDEFLATE)
+	if err != nil || last {
+		return comp, err
+	}
+	unfinished, err := bitstream.Unfinish(comp)
+	if err != nil {
+		return nil, err
+	}
+	// keep the larger buffer for the next chunk
+	return append(out[:0], unfinished...), nil
+}
@@ -0,0 +1,112 @@
+package libdeflate
+
+import (
+	"runtime"
+	"sync"
+)
+
+// CompressParallel splits in into chunks of chunkSize bytes and compresses them concurrently
+// on the given number of workers, each using its own Compressor at the given level.
+//
+// The compressed chunks are put together in order in the same format a Writer produces:
+// In ModeGzip the result is a regular multi-member gzip stream, with one member per chunk.
+// In ModeZlib and ModeDEFLATE every chunk becomes a frame prefixed by its compressed length,
+// which can be decompressed by a Reader.
+//
+// If chunkSize <= 0, DefaultChunkSize is used. If workers <= 0, GOMAXPROCS workers are used.
+// Errors if in is empty, if an invalid mode or if an invalid compression level was passed.
+func CompressParallel(in []byte, m Mode, level, chunkSize, workers int) ([]byte, error) {
+	if m != ModeDEFLATE && m != ModeZlib && m != ModeGzip {
+		return nil, errorInvalidModeCompressor
+	}
+	if len(in) == 0 {
+		return nil, errorNoInput
+	}
+	if level < MinCompressionLevel || level > MaxCompressionLevel {
+		return nil, errorInvalidLevel
+	}
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	chunks := (len(in) + chunkSize - 1) / chunkSize
+	if workers > chunks {
+		workers = chunks
+	}
+
+	results := make([][]byte, chunks)
+	jobs := make(chan int, chunks)
+	for i := 0; i < chunks; i++ {
+		jobs <- i
+	}
+	close(jobs)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if firstErr == nil {
+			firstErr = err
+		}
+		mu.Unlock()
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr != nil
+	}
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			c, err := NewCompressorLevel(level)
+			if err != nil {
+				fail(err)
+				return
+			}
+			defer c.Close()
+
+			for i := range jobs {
+				if failed() {
+					return
+				}
+
+				end := (i + 1) * chunkSize
+				if end > len(in) {
+					end = len(in)
+				}
+				chunk := in[i*chunkSize : end]
+				out := make([]byte, frameHeaderSize+c.WorstCaseCompressedSize(len(chunk), m))
+				n, err := compressFrame(c, chunk, out, m)
+				if err != nil {
+					fail(err)
+					return
+				}
+				results[i] = out[:n]
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	size := 0
+	for _, r := range results {
+		size += len(r)
+	}
+	out := make([]byte, 0, size)
+	for _, r := range results {
+		out = append(out, r...)
+	}
+	return out, nil
+}
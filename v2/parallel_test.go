@@ -0,0 +1,70 @@
+package libdeflate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestCompressParallelGzip(t *testing.T) {
+	in := bytes.Repeat(shortString, 1000)
+
+	comp, err := CompressParallel(in, ModeGzip, MaxCompressionLevel, 10000, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(comp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	slicesEqual(in, out, t)
+
+	// the output does not depend on the number of workers
+	single, _ := CompressParallel(in, ModeGzip, MaxCompressionLevel, 10000, 1)
+	slicesEqual(comp, single, t)
+}
+
+func TestCompressParallelErrors(t *testing.T) {
+	if _, err := CompressParallel(nil, ModeGzip, DefaultCompressionLevel, 0, 0); err == nil {
+		t.Error("expected error for empty input")
+	}
+	if _, err := CompressParallel(shortString, ModeGzip, 30, 0, 0); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if _, err := CompressParallel(shortString, ModeAuto, DefaultCompressionLevel, 0, 0); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestCompressParallelReader(t *testing.T) {
+	in := bytes.Repeat(shortString, 1000)
+
+	for _, m := range []Mode{ModeDEFLATE, ModeZlib} {
+		comp, err := CompressParallel(in, m, DefaultCompressionLevel, 10000, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r, _ := NewReader(bytes.NewReader(comp), m)
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Error(err)
+		}
+		r.Close()
+		slicesEqual(in, out, t)
+	}
+}
@@ -0,0 +1,17 @@
+package png
+
+// A FormatError reports that the input is not a valid PNG.
+type FormatError string
+
+func (e FormatError) Error() string { return "libdeflate: png: invalid format: " + string(e) }
+
+// An UnsupportedError reports that the input uses a valid but unimplemented PNG feature.
+type UnsupportedError string
+
+func (e UnsupportedError) Error() string { return "libdeflate: png: unsupported feature: " + string(e) }
+
+var (
+	errorChecksum = FormatError("invalid checksum")
+	errorNoIDAT   = FormatError("no IDAT chunk")
+	errorChunk    = FormatError("chunk out of order")
+)
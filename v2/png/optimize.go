@@ -0,0 +1,239 @@
+package png
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// OptimizeOptions configures OptimizePNG.
+type OptimizeOptions struct {
+	// Levels are the libdeflate compression levels tried for every filter strategy.
+	// Defaults to 10, 11 and 12 (libdeflate.MaxCompressionLevel), which compress better than zlib supports.
+	Levels []int
+	// Strip removes all ancillary chunks, except for tRNS and the chunk types listed in Keep.
+	// Note that removing the color space chunks (gAMA, cHRM, sRGB, iCCP) may change how the image is displayed.
+	Strip bool
+	// Keep lists the ancillary chunk types that are retained if Strip is set, e.g. "iCCP" or "pHYs".
+	Keep []string
+	// Workers is the number of concurrent compressions. Defaults to GOMAXPROCS.
+	Workers int
+}
+
+// strategyHeuristic chooses the filter of every row with the heuristic of the encoder.
+const strategyHeuristic = nFilter
+
+// strategies are the filter strategies tried by OptimizePNG: the heuristic and every fixed filter type.
+var strategies = []byte{strategyHeuristic, ftNone, ftSub, ftUp, ftAverage, ftPaeth}
+
+var defaultOptimizeLevels = []int{10, 11, libdeflate.MaxCompressionLevel}
+
+// OptimizePNG reads a PNG image from r and returns it with the smallest image data found, without changing any pixel.
+// The image data is decompressed, refiltered with every filter strategy, and recompressed at every configured
+// level concurrently. If none of the results is smaller than the original image data, the latter is kept.
+// Chunks are kept in their order and interlacing is preserved; ancillary chunks are removed according to opts.
+//
+// The whole image is held in memory once per filter strategy.
+// Errors if the PNG is invalid or if an invalid compression level was configured.
+func OptimizePNG(r io.Reader, opts OptimizeOptions) ([]byte, error) {
+	levels := opts.Levels
+	if len(levels) == 0 {
+		levels = defaultOptimizeLevels
+	}
+	for _, level := range levels {
+		if level < libdeflate.MinCompressionLevel || level > libdeflate.MaxCompressionLevel {
+			return nil, UnsupportedError("compression level " + strconv.Itoa(level))
+		}
+	}
+	workers := opts.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	d := &decoder{r: r, keepChunks: true}
+	if err := d.readChunks(false); err != nil {
+		return nil, err
+	}
+	raw, err := d.inflate()
+	if err != nil {
+		return nil, err
+	}
+	if err := d.unfilterAll(raw); err != nil {
+		return nil, err
+	}
+
+	filtered := make([][]byte, len(strategies))
+	for i, s := range strategies {
+		filtered[i] = d.refilter(raw, s)
+	}
+
+	// ties are broken by the candidate index, so the result does not depend on the order the workers finish in;
+	// the original image data, index -1, wins all ties
+	best, bestIndex := d.idat.Bytes(), -1
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, workers)
+		lastErr error
+	)
+	for i, data := range filtered {
+		for j, level := range levels {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(data []byte, level, index int) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				comp, err := recompress(data, level)
+
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					lastErr = err
+				} else if len(comp) < len(best) || len(comp) == len(best) && index < bestIndex {
+					best, bestIndex = comp, index
+				}
+			}(data, level, i*len(levels)+j)
+		}
+	}
+	wg.Wait()
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	return d.writeOptimized(best, opts), nil
+}
+
+// recompress compresses the filtered image data with a Compressor of the given level.
+func recompress(data []byte, level int) ([]byte, error) {
+	c, err := libdeflate.NewCompressorLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	_, comp, err := c.Compress(data, nil, libdeflate.ModeZlib)
+	return comp, err
+}
+
+// eachRow calls fn for every row of the decompressed image data, passing the row including its filter byte
+// and the previous row of the same pass without its filter byte, which is nil for the first row of a pass.
+func (d *decoder) eachRow(raw []byte, fn func(row, prev []byte) error) error {
+	passes := []int{-1}
+	if d.interlace == 1 {
+		passes = []int{0, 1, 2, 3, 4, 5, 6}
+	}
+
+	bitsPP := d.bitsPerPixel()
+	for _, pass := range passes {
+		w, h := d.passSize(pass)
+		if w == 0 || h == 0 {
+			continue
+		}
+		rowSize := (w*bitsPP + 7) / 8
+		var prev []byte
+		for j := 0; j < h; j++ {
+			row := raw[:1+rowSize]
+			if err := fn(row, prev); err != nil {
+				return err
+			}
+			prev, raw = row[1:], raw[1+rowSize:]
+		}
+	}
+	return nil
+}
+
+// unfilterAll reverses the filters of all rows of raw in place and sets their filter bytes to ftNone.
+func (d *decoder) unfilterAll(raw []byte) error {
+	bpp := (d.bitsPerPixel() + 7) / 8
+	var zero []byte
+	return d.eachRow(raw, func(row, prev []byte) error {
+		if prev == nil {
+			zero = growZero(zero, len(row)-1)
+			prev = zero
+		}
+		err := unfilter(row[0], row[1:], prev, bpp)
+		row[0] = ftNone
+		return err
+	})
+}
+
+// refilter returns a copy of the unfiltered image data raw, filtered according to strategy.
+func (d *decoder) refilter(raw []byte, strategy byte) []byte {
+	bpp := (d.bitsPerPixel() + 7) / 8
+	out := make([]byte, len(raw))
+	var zero []byte
+	var candidates [nFilter][]byte
+
+	off := 0
+	d.eachRow(raw, func(row, prev []byte) error {
+		cur, dst := row[1:], out[off:off+len(row)]
+		off += len(row)
+		if prev == nil {
+			zero = growZero(zero, len(cur))
+			prev = zero
+		}
+
+		if strategy != strategyHeuristic {
+			dst[0] = strategy
+			filter(strategy, dst[1:], cur, prev, bpp)
+			return nil
+		}
+		for i := range candidates {
+			if cap(candidates[i]) < len(cur) {
+				candidates[i] = make([]byte, len(cur))
+			}
+			candidates[i] = candidates[i][:len(cur)]
+		}
+		dst[0] = chooseFilter(&candidates, cur, prev, bpp)
+		copy(dst[1:], candidates[dst[0]])
+		return nil
+	})
+	return out
+}
+
+// writeOptimized writes the recorded chunks with the given compressed image data in place of the original one.
+func (d *decoder) writeOptimized(comp []byte, opts OptimizeOptions) []byte {
+	buf := &bytes.Buffer{}
+	buf.WriteString(pngHeader)
+	e := &encoder{w: buf}
+	for _, c := range d.chunks {
+		switch {
+		case c.typ == "IDAT":
+			e.writeIDATData(comp)
+		case opts.Strip && isAncillary(c.typ) && c.typ != "tRNS" && !contains(opts.Keep, c.typ):
+			// stripped
+		default:
+			e.writeChunk(c.data, c.typ)
+		}
+	}
+	e.writeChunk(nil, "IEND")
+	return buf.Bytes()
+}
+
+// isAncillary reports whether a chunk type is ancillary, which is indicated by a lowercase first letter.
+func isAncillary(typ string) bool {
+	return typ[0]&0x20 != 0
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// growZero returns a zeroed slice of length n, reusing b if it is large enough.
+func growZero(b []byte, n int) []byte {
+	if cap(b) < n {
+		return make([]byte, n)
+	}
+	return b[:n]
+}
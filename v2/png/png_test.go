@@ -0,0 +1,394 @@
+package png
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	stdpng "image/png"
+	"testing"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestEncodeStdDecode(t *testing.T) {
+	for name, img := range testImages() {
+		for _, level := range []CompressionLevel{DefaultCompression, NoCompression, BestSpeed, MaxCompression} {
+			buf := &bytes.Buffer{}
+			enc := Encoder{CompressionLevel: level}
+			if err := enc.Encode(buf, img); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+
+			out, err := stdpng.Decode(buf)
+			if err != nil {
+				t.Fatalf("%s at level %d: %v", name, level, err)
+			}
+			imagesEqual(name, img, out, t)
+		}
+	}
+}
+
+func TestStdEncodeDecode(t *testing.T) {
+	for name, img := range testImages() {
+		buf := &bytes.Buffer{}
+		if err := stdpng.Encode(buf, img); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		imagesEqual(name, img, out, t)
+
+		// the types of the decoded images match those of image/png
+		std, _ := stdpng.Decode(bytes.NewReader(buf.Bytes()))
+		if ot, st := typeName(out), typeName(std); ot != st {
+			t.Errorf("%s: decoded as %s, image/png decodes as %s", name, ot, st)
+		}
+
+		cfg, err := DecodeConfig(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Width != img.Bounds().Dx() || cfg.Height != img.Bounds().Dy() {
+			t.Errorf("%s: config size %dx%d", name, cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestDecodeInterlacedAndLowDepth(t *testing.T) {
+	for _, c := range []struct {
+		ct, depth, interlace int
+	}{
+		{ctTrueColor, 8, 1},
+		{ctTrueColorAlpha, 16, 1},
+		{ctGrayscale, 1, 0},
+		{ctGrayscale, 2, 1},
+		{ctGrayscale, 4, 0},
+		{ctGrayscaleAlpha, 8, 1},
+	} {
+		data := buildPNG(13, 11, c.ct, c.depth, c.interlace)
+		out, err := Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("color type %d, depth %d: %v", c.ct, c.depth, err)
+		}
+		std, err := stdpng.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		imagesEqual("built", std, out, t)
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	img := testImages()["nrgba"]
+	buf := &bytes.Buffer{}
+	Encode(buf, img)
+	data := buf.Bytes()
+
+	if _, err := Decode(bytes.NewReader(data[:len(data)-20])); err == nil {
+		t.Error("expected error for truncated data")
+	}
+
+	corrupt := append([]byte{}, data...)
+	corrupt[20] ^= 0xff
+	if _, err := Decode(bytes.NewReader(corrupt)); err != errorChecksum {
+		t.Errorf("expected checksum error, got %v", err)
+	}
+
+	if _, err := Decode(bytes.NewReader([]byte("GIF89a......"))); err == nil {
+		t.Error("expected error for non-PNG data")
+	}
+}
+
+func TestDecodeHugeDimensions(t *testing.T) {
+	for _, ct := range []int{ctGrayscale, ctTrueColorAlpha} {
+		depth := map[int]byte{ctGrayscale: 8, ctTrueColorAlpha: 16}[ct]
+		ihdr := []byte{0x7f, 0xff, 0xff, 0xff, 0x7f, 0xff, 0xff, 0xff, depth, byte(ct), 0, 0, 0}
+		for _, idat := range [][]byte{nil, {0x78, 0x9c, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01}} {
+			data := appendChunk([]byte(pngHeader), "IHDR", ihdr)
+			data = appendChunk(data, "IDAT", idat)
+			data = appendChunk(data, "IEND", nil)
+			if _, err := Decode(bytes.NewReader(data)); err == nil {
+				t.Errorf("color type %d: expected error for %dx%d image", ct, 0x7fffffff, 0x7fffffff)
+			}
+		}
+	}
+
+	// a uniform image compresses far better than the default decompression factor
+	img := image.NewGray(image.Rect(0, 0, 2000, 2000))
+	buf := &bytes.Buffer{}
+	if err := Encode(buf, img); err != nil {
+		t.Fatal(err)
+	}
+	out, err := Decode(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagesEqual("uniform", img, out, t)
+}
+
+func TestEncodeInvalidLevel(t *testing.T) {
+	enc := Encoder{CompressionLevel: 13}
+	if err := enc.Encode(&bytes.Buffer{}, image.NewGray(image.Rect(0, 0, 1, 1))); err == nil {
+		t.Error("expected error for invalid compression level")
+	}
+}
+
+func TestImageDecode(t *testing.T) {
+	buf := &bytes.Buffer{}
+	Encode(buf, testImages()["gray"])
+	if _, format, err := image.Decode(buf); err != nil || format != "png" {
+		t.Errorf("image.Decode: format %q, %v", format, err)
+	}
+}
+
+func TestOptimizePNG(t *testing.T) {
+	buf := &bytes.Buffer{}
+	enc := stdpng.Encoder{CompressionLevel: stdpng.BestSpeed}
+	enc.Encode(buf, testImages()["nrgba"])
+	// insert ancillary chunks after IHDR
+	in := append([]byte{}, buf.Bytes()[:33]...)
+	in = appendChunk(in, "tEXt", []byte("Comment\x00hello"))
+	in = appendChunk(in, "pHYs", make([]byte, 9))
+	in = append(in, buf.Bytes()[33:]...)
+
+	out, err := OptimizePNG(bytes.NewReader(in), OptimizeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) >= len(in) {
+		t.Errorf("optimized size %d, original %d", len(out), len(in))
+	}
+	checkOptimized(in, out, t)
+	if !bytes.Contains(out, []byte("tEXtComment")) {
+		t.Error("ancillary chunk was removed without Strip")
+	}
+
+	out, err = OptimizePNG(bytes.NewReader(in), OptimizeOptions{Levels: []int{12}, Strip: true, Keep: []string{"pHYs"}, Workers: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkOptimized(in, out, t)
+	if bytes.Contains(out, []byte("tEXt")) || !bytes.Contains(out, []byte("pHYs")) {
+		t.Error("chunks were not stripped according to the options")
+	}
+
+	// optimizing an optimized image keeps its image data
+	again, err := OptimizePNG(bytes.NewReader(out), OptimizeOptions{Levels: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(out, again, t)
+}
+
+func TestOptimizePNGDeterministic(t *testing.T) {
+	// a uniform image gives candidates of equal size for several levels and filters
+	buf := &bytes.Buffer{}
+	stdpng.Encode(buf, image.NewGray(image.Rect(0, 0, 64, 64)))
+	in := buf.Bytes()
+
+	opts := OptimizeOptions{Levels: []int{1, 6, 10, 11, 12}, Workers: 1}
+	expected, err := OptimizePNG(bytes.NewReader(in), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 20; i++ {
+		opts.Workers = 1 + i%8
+		out, err := OptimizePNG(bytes.NewReader(in), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(expected, out, t)
+	}
+}
+
+func TestOptimizePNGInterlaced(t *testing.T) {
+	for _, depth := range []int{2, 16} {
+		ct := ctGrayscale
+		if depth == 16 {
+			ct = ctTrueColorAlpha
+		}
+		in := buildPNG(29, 17, ct, depth, 1)
+		out, err := OptimizePNG(bytes.NewReader(in), OptimizeOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkOptimized(in, out, t)
+		if out[28] != 1 {
+			t.Error("interlacing was not preserved")
+		}
+	}
+}
+
+func TestOptimizePNGErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	Encode(buf, testImages()["gray"])
+	if _, err := OptimizePNG(bytes.NewReader(buf.Bytes()), OptimizeOptions{Levels: []int{13}}); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if _, err := OptimizePNG(bytes.NewReader(buf.Bytes()[:40]), OptimizeOptions{}); err == nil {
+		t.Error("expected error for truncated data")
+	}
+}
+
+func checkOptimized(in, out []byte, t *testing.T) {
+	expected, err := stdpng.Decode(bytes.NewReader(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := stdpng.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	imagesEqual("optimized", expected, actual, t)
+}
+
+func testImages() map[string]image.Image {
+	rect := image.Rect(0, 0, 37, 23)
+	gray := image.NewGray(rect)
+	gray16 := image.NewGray16(rect)
+	rgba := image.NewRGBA(rect)
+	nrgba := image.NewNRGBA(rect)
+	nrgba64 := image.NewNRGBA64(rect)
+	pal2 := image.NewPaletted(rect, color.Palette{color.Black, color.White})
+	pal16 := image.NewPaletted(rect, nil)
+	for i := 0; i < 16; i++ {
+		pal16.Palette = append(pal16.Palette, color.NRGBA{uint8(i * 16), 0, 0, uint8(255 - i)})
+	}
+
+	for y := 0; y < rect.Dy(); y++ {
+		for x := 0; x < rect.Dx(); x++ {
+			v := uint8(x*7 + y*3)
+			gray.SetGray(x, y, color.Gray{v})
+			gray16.SetGray16(x, y, color.Gray16{uint16(x) * 1000})
+			rgba.SetRGBA(x, y, color.RGBA{v, uint8(y), 0x80, 0xff})
+			nrgba.SetNRGBA(x, y, color.NRGBA{v, uint8(y), 0x80, uint8(x * 5)})
+			nrgba64.SetNRGBA64(x, y, color.NRGBA64{uint16(x) * 300, uint16(y) * 700, 3, uint16(x*y) * 50})
+			pal2.SetColorIndex(x, y, uint8((x+y)%2))
+			pal16.SetColorIndex(x, y, uint8(x%16))
+		}
+	}
+
+	// a sub-image, whose bounds don't start at the origin
+	sub := rgba.SubImage(image.Rect(5, 3, 20, 17))
+
+	return map[string]image.Image{
+		"gray": gray, "gray16": gray16, "rgba": rgba, "nrgba": nrgba, "nrgba64": nrgba64,
+		"pal2": pal2, "pal16": pal16, "sub": sub,
+	}
+}
+
+// buildPNG builds a PNG image of the given format with a gradient pattern and without filtering,
+// as image/png can't encode interlaced images or grayscale images with a bit depth below 8.
+func buildPNG(w, h, ct, depth, interlace int) []byte {
+	channels := map[int]int{ctGrayscale: 1, ctTrueColor: 3, ctGrayscaleAlpha: 2, ctTrueColorAlpha: 4}[ct]
+	max := 1<<uint(depth) - 1
+
+	passes := [][4]int{{0, 0, 1, 1}}
+	if interlace == 1 {
+		passes = nil
+		for _, p := range adam7 {
+			passes = append(passes, [4]int{p.xOffset, p.yOffset, p.xStep, p.yStep})
+		}
+	}
+
+	var raw []byte
+	for _, p := range passes {
+		for y := p[1]; y < h; y += p[3] {
+			if p[0] >= w {
+				break
+			}
+			raw = append(raw, ftNone)
+			var bits, nbits uint
+			for x := p[0]; x < w; x += p[2] {
+				for c := 0; c < channels; c++ {
+					v := uint((x*31 + y*17 + c*101) % (max + 1))
+					bits = bits<<uint(depth) | v
+					nbits += uint(depth)
+					for nbits >= 8 {
+						raw = append(raw, byte(bits>>(nbits-8)))
+						nbits -= 8
+					}
+				}
+			}
+			if nbits > 0 {
+				raw = append(raw, byte(bits<<(8-nbits)))
+			}
+		}
+	}
+
+	zbuf := &bytes.Buffer{}
+	zw := zlib.NewWriter(zbuf)
+	zw.Write(raw)
+	zw.Close()
+
+	out := []byte(pngHeader)
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(w))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(h))
+	ihdr[8], ihdr[9], ihdr[12] = byte(depth), byte(ct), byte(interlace)
+	out = appendChunk(out, "IHDR", ihdr)
+	out = appendChunk(out, "IDAT", zbuf.Bytes()[:10])
+	out = appendChunk(out, "IDAT", zbuf.Bytes()[10:])
+	return appendChunk(out, "IEND", nil)
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
+
+func appendChunk(out []byte, typ string, data []byte) []byte {
+	var n [4]byte
+	binary.BigEndian.PutUint32(n[:], uint32(len(data)))
+	out = append(append(append(out, n[:]...), typ...), data...)
+	binary.BigEndian.PutUint32(n[:], crc32.ChecksumIEEE(append([]byte(typ), data...)))
+	return append(out, n[:]...)
+}
+
+func imagesEqual(name string, expected, actual image.Image, t *testing.T) {
+	eb, ab := expected.Bounds(), actual.Bounds()
+	if eb.Dx() != ab.Dx() || eb.Dy() != ab.Dy() {
+		t.Fatalf("%s: size %v, expected %v", name, ab.Size(), eb.Size())
+	}
+	for y := 0; y < eb.Dy(); y++ {
+		for x := 0; x < eb.Dx(); x++ {
+			er, eg, ebl, ea := expected.At(eb.Min.X+x, eb.Min.Y+y).RGBA()
+			ar, ag, abl, aa := actual.At(ab.Min.X+x, ab.Min.Y+y).RGBA()
+			if er != ar || eg != ag || ebl != abl || ea != aa {
+				t.Fatalf("%s: pixel (%d, %d) is %v, expected %v", name, x, y,
+					actual.At(ab.Min.X+x, ab.Min.Y+y), expected.At(eb.Min.X+x, eb.Min.Y+y))
+			}
+		}
+	}
+}
+
+func typeName(img image.Image) string {
+	switch img.(type) {
+	case *image.Gray:
+		return "Gray"
+	case *image.Gray16:
+		return "Gray16"
+	case *image.RGBA:
+		return "RGBA"
+	case *image.RGBA64:
+		return "RGBA64"
+	case *image.NRGBA:
+		return "NRGBA"
+	case *image.NRGBA64:
+		return "NRGBA64"
+	case *image.Paletted:
+		return "Paletted"
+	default:
+		return "other"
+	}
+}
@@ -0,0 +1,651 @@
+// Package png mirrors the Encode and Decode API of image/png, but compresses and decompresses
+// the image data with libdeflate.
+//
+// The encoder filters all rows and compresses the whole image with a single zlib compression,
+// optionally at compression levels beyond those of image/png. The decoder collects all IDAT chunks and
+// decompresses them at once into a buffer of the size computed from the image header.
+package png
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+const pngHeader = "\x89PNG\r\n\x1a\n"
+
+// Color types, as specified by the PNG specification.
+const (
+	ctGrayscale      = 0
+	ctTrueColor      = 2
+	ctPaletted       = 3
+	ctGrayscaleAlpha = 4
+	ctTrueColorAlpha = 6
+)
+
+// Filter types, as specified by the PNG specification.
+const (
+	ftNone    = 0
+	ftSub     = 1
+	ftUp      = 2
+	ftAverage = 3
+	ftPaeth   = 4
+	nFilter   = 5
+)
+
+// maxChunkLength is the maximum length of a chunk, as specified by the PNG specification.
+const maxChunkLength = 0x7fffffff
+
+// adam7 describes the seven passes of an interlaced image.
+var adam7 = [7]struct{ xOffset, yOffset, xStep, yStep int }{
+	{0, 0, 8, 8},
+	{4, 0, 8, 8},
+	{0, 4, 4, 8},
+	{2, 0, 4, 4},
+	{0, 2, 2, 4},
+	{1, 0, 2, 2},
+	{0, 1, 1, 2},
+}
+
+// decompressors are shared by all decoders of this package.
+// As the size of the image data is known from the header, the maximum decompression factor is used.
+var decompressors, _ = libdeflate.NewDecompressorPool(libdeflate.PoolOptions{},
+	libdeflate.DecompressorOptions{MaxDecompressionFactor: libdeflate.MaxPossibleDecompressionFactor})
+
+func init() {
+	image.RegisterFormat("png", pngHeader, Decode, DecodeConfig)
+}
+
+type decoder struct {
+	r           io.Reader
+	width       int
+	height      int
+	depth       int
+	colorType   byte
+	interlace   byte
+	palette     color.Palette
+	transparent []byte // raw tRNS chunk of grayscale and true color images
+	idat        bytes.Buffer
+	seenIHDR    bool
+	seenIDAT    bool
+	keepChunks  bool    // whether readChunks records the chunks in chunks
+	chunks      []chunk // all chunks up to IEND, with a single IDAT chunk without data in place of the image data
+	tmp         [8]byte
+}
+
+type chunk struct {
+	typ  string
+	data []byte
+}
+
+// Decode reads a PNG image from r and returns it as an image.Image.
+// The type of Image returned depends on the PNG contents, like with image/png.
+func Decode(r io.Reader) (image.Image, error) {
+	d := &decoder{r: r}
+	if err := d.readChunks(false); err != nil {
+		return nil, err
+	}
+	return d.decodeImage()
+}
+
+// DecodeConfig returns the color model and dimensions of a PNG image without decoding the entire image.
+func DecodeConfig(r io.Reader) (image.Config, error) {
+	d := &decoder{r: r}
+	if err := d.readChunks(true); err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{ColorModel: d.colorModel(), Width: d.width, Height: d.height}, nil
+}
+
+// readChunks checks the PNG signature and reads all chunks up to IEND,
+// or up to the first IDAT chunk if configOnly is set.
+func (d *decoder) readChunks(configOnly bool) error {
+	if _, err := io.ReadFull(d.r, d.tmp[:8]); err != nil {
+		return unexpectedEOF(err)
+	}
+	if string(d.tmp[:8]) != pngHeader {
+		return FormatError("not a PNG file")
+	}
+
+	for {
+		if _, err := io.ReadFull(d.r, d.tmp[:8]); err != nil {
+			return unexpectedEOF(err)
+		}
+		length := binary.BigEndian.Uint32(d.tmp[:4])
+		if length > maxChunkLength {
+			return FormatError("chunk too long")
+		}
+		typ := string(d.tmp[4:8])
+
+		if !d.seenIHDR && typ != "IHDR" {
+			return errorChunk
+		}
+		if configOnly && typ == "IDAT" {
+			return nil
+		}
+
+		var data []byte
+		if typ == "IDAT" {
+			if d.palette == nil && d.colorType == ctPaletted {
+				return FormatError("missing PLTE chunk")
+			}
+			d.seenIDAT = true
+			start := d.idat.Len()
+			if _, err := io.CopyN(&d.idat, d.r, int64(length)); err != nil {
+				return unexpectedEOF(err)
+			}
+			data = d.idat.Bytes()[start:]
+		} else {
+			buf := &bytes.Buffer{}
+			if _, err := io.CopyN(buf, d.r, int64(length)); err != nil {
+				return unexpectedEOF(err)
+			}
+			data = buf.Bytes()
+		}
+
+		if _, err := io.ReadFull(d.r, d.tmp[:4]); err != nil {
+			return unexpectedEOF(err)
+		}
+		crc := chunkCrc32(typ, data)
+		if crc != binary.BigEndian.Uint32(d.tmp[:4]) {
+			return errorChecksum
+		}
+
+		if d.keepChunks && typ != "IEND" {
+			if typ != "IDAT" {
+				d.chunks = append(d.chunks, chunk{typ, data})
+			} else if n := len(d.chunks); n == 0 || d.chunks[n-1].typ != "IDAT" {
+				d.chunks = append(d.chunks, chunk{typ: typ})
+			}
+		}
+
+		var err error
+		switch typ {
+		case "IHDR":
+			err = d.parseIHDR(data)
+		case "PLTE":
+			err = d.parsePLTE(data)
+		case "tRNS":
+			err = d.parseTRNS(data)
+		case "IEND":
+			if !d.seenIDAT {
+				return errorNoIDAT
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (d *decoder) parseIHDR(data []byte) error {
+	if d.seenIHDR {
+		return errorChunk
+	}
+	if len(data) != 13 {
+		return FormatError("bad IHDR length")
+	}
+	d.seenIHDR = true
+
+	if data[10] != 0 {
+		return UnsupportedError("compression method")
+	}
+	if data[11] != 0 {
+		return UnsupportedError("filter method")
+	}
+	if data[12] > 1 {
+		return FormatError("invalid interlace method")
+	}
+	d.interlace = data[12]
+
+	w, h := int64(binary.BigEndian.Uint32(data[0:4])), int64(binary.BigEndian.Uint32(data[4:8]))
+	if w <= 0 || h <= 0 || w > maxChunkLength || h > maxChunkLength {
+		return FormatError("invalid dimensions")
+	}
+	if n := w * h; n != int64(int(n)) || n/h != w {
+		return UnsupportedError("dimension overflow")
+	}
+	d.width, d.height = int(w), int(h)
+
+	d.depth, d.colorType = int(data[8]), data[9]
+	valid := false
+	switch d.colorType {
+	case ctGrayscale:
+		valid = d.depth == 1 || d.depth == 2 || d.depth == 4 || d.depth == 8 || d.depth == 16
+	case ctPaletted:
+		valid = d.depth == 1 || d.depth == 2 || d.depth == 4 || d.depth == 8
+	case ctTrueColor, ctGrayscaleAlpha, ctTrueColorAlpha:
+		valid = d.depth == 8 || d.depth == 16
+	}
+	if !valid {
+		return UnsupportedError("bit depth " + strconv.Itoa(d.depth) + ", color type " + strconv.Itoa(int(d.colorType)))
+	}
+	return nil
+}
+
+func (d *decoder) parsePLTE(data []byte) error {
+	if d.seenIDAT || d.palette != nil {
+		return errorChunk
+	}
+	switch d.colorType {
+	case ctPaletted:
+		n := len(data) / 3
+		if len(data)%3 != 0 || n == 0 || n > 256 || n > 1<<uint(d.depth) {
+			return FormatError("bad PLTE length")
+		}
+		d.palette = make(color.Palette, n, 256)
+		for i := range d.palette {
+			d.palette[i] = color.RGBA{data[3*i], data[3*i+1], data[3*i+2], 0xff}
+		}
+	case ctTrueColor, ctTrueColorAlpha:
+		// a suggested palette for true color images, which can be ignored
+	default:
+		return FormatError("PLTE, color type mismatch")
+	}
+	return nil
+}
+
+func (d *decoder) parseTRNS(data []byte) error {
+	if d.seenIDAT {
+		return errorChunk
+	}
+	switch d.colorType {
+	case ctGrayscale:
+		if len(data) != 2 {
+			return FormatError("bad tRNS length")
+		}
+		d.transparent = data
+	case ctTrueColor:
+		if len(data) != 6 {
+			return FormatError("bad tRNS length")
+		}
+		d.transparent = data
+	case ctPaletted:
+		if d.palette == nil || len(data) > len(d.palette) {
+			return FormatError("bad tRNS length")
+		}
+		for i, a := range data {
+			r, g, b, _ := d.palette[i].RGBA()
+			d.palette[i] = color.NRGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), a}
+		}
+	default:
+		return FormatError("tRNS, color type mismatch")
+	}
+	return nil
+}
+
+// colorModel returns the color model of the image returned by Decode.
+func (d *decoder) colorModel() color.Model {
+	switch d.colorType {
+	case ctGrayscale:
+		switch {
+		case d.transparent != nil && d.depth == 16:
+			return color.NRGBA64Model
+		case d.transparent != nil:
+			return color.NRGBAModel
+		case d.depth == 16:
+			return color.Gray16Model
+		default:
+			return color.GrayModel
+		}
+	case ctTrueColor:
+		switch {
+		case d.transparent != nil && d.depth == 16:
+			return color.NRGBA64Model
+		case d.transparent != nil:
+			return color.NRGBAModel
+		case d.depth == 16:
+			return color.RGBA64Model
+		default:
+			return color.RGBAModel
+		}
+	case ctPaletted:
+		return d.palette
+	default:
+		if d.depth == 16 {
+			return color.NRGBA64Model
+		}
+		return color.NRGBAModel
+	}
+}
+
+// bitsPerPixel returns the number of bits of a single pixel of the image data.
+func (d *decoder) bitsPerPixel() int {
+	switch d.colorType {
+	case ctTrueColor:
+		return 3 * d.depth
+	case ctGrayscaleAlpha:
+		return 2 * d.depth
+	case ctTrueColorAlpha:
+		return 4 * d.depth
+	default:
+		return d.depth
+	}
+}
+
+// passSize returns the dimensions of an interlacing pass, or of the whole image if pass is -1.
+func (d *decoder) passSize(pass int) (int, int) {
+	if pass < 0 {
+		return d.width, d.height
+	}
+	p := adam7[pass]
+	return (d.width - p.xOffset + p.xStep - 1) / p.xStep, (d.height - p.yOffset + p.yStep - 1) / p.yStep
+}
+
+// rawSize returns the size of the decompressed image data, which is one filter byte and the pixels of every row,
+// or false if it overflows an int64.
+func (d *decoder) rawSize() (int64, bool) {
+	passes := []int{-1}
+	if d.interlace == 1 {
+		passes = []int{0, 1, 2, 3, 4, 5, 6}
+	}
+
+	var size int64
+	for _, pass := range passes {
+		w, h := d.passSize(pass)
+		if w == 0 || h == 0 {
+			continue
+		}
+		row := 1 + (int64(w)*int64(d.bitsPerPixel())+7)/8
+		if int64(h) > (math.MaxInt64-size)/row {
+			return 0, false
+		}
+		size += int64(h) * row
+	}
+	return size, true
+}
+
+// decodeImage decompresses the collected IDAT data and converts it to an image.
+func (d *decoder) decodeImage() (image.Image, error) {
+	raw, err := d.inflate()
+	if err != nil {
+		return nil, err
+	}
+
+	img := d.newImage()
+	if d.interlace == 0 {
+		_, err = d.decodePass(img, raw, -1)
+		return img, err
+	}
+	for pass := range adam7 {
+		n, err := d.decodePass(img, raw, pass)
+		if err != nil {
+			return nil, err
+		}
+		raw = raw[n:]
+	}
+	return img, nil
+}
+
+// inflate decompresses the collected IDAT data in a single call into a buffer of the size computed from the header.
+func (d *decoder) inflate() ([]byte, error) {
+	size, ok := d.rawSize()
+	if !ok || size != int64(int(size)) {
+		return nil, UnsupportedError("dimension overflow")
+	}
+	if d.idat.Len() == 0 {
+		return nil, errorNoIDAT
+	}
+	// checked before allocating, so a small file cannot claim huge dimensions
+	if size > int64(d.idat.Len())*libdeflate.MaxPossibleDecompressionFactor {
+		return nil, FormatError("not enough image data")
+	}
+
+	dc, err := decompressors.Get()
+	if err != nil {
+		return nil, err
+	}
+	raw := make([]byte, size)
+	_, _, err = dc.Decompress(d.idat.Bytes(), raw, libdeflate.ModeZlib)
+	decompressors.Put(dc)
+	if err != nil {
+		return nil, FormatError("bad image data: " + err.Error())
+	}
+	return raw, nil
+}
+
+// newImage allocates the image returned by Decode.
+func (d *decoder) newImage() image.Image {
+	rect := image.Rect(0, 0, d.width, d.height)
+	if d.colorType == ctPaletted {
+		return image.NewPaletted(rect, d.palette)
+	}
+
+	switch d.colorModel() {
+	case color.GrayModel:
+		return image.NewGray(rect)
+	case color.Gray16Model:
+		return image.NewGray16(rect)
+	case color.RGBAModel:
+		return image.NewRGBA(rect)
+	case color.RGBA64Model:
+		return image.NewRGBA64(rect)
+	case color.NRGBA64Model:
+		return image.NewNRGBA64(rect)
+	default:
+		return image.NewNRGBA(rect)
+	}
+}
+
+// decodePass unfilters the rows of an interlacing pass (or of the whole image if pass is -1)
+// at the beginning of raw and stores their pixels in img. Returns the number of bytes consumed from raw.
+func (d *decoder) decodePass(img image.Image, raw []byte, pass int) (int, error) {
+	w, h := d.passSize(pass)
+	if w == 0 || h == 0 {
+		return 0, nil
+	}
+	xOffset, yOffset, xStep, yStep := 0, 0, 1, 1
+	if pass >= 0 {
+		p := adam7[pass]
+		xOffset, yOffset, xStep, yStep = p.xOffset, p.yOffset, p.xStep, p.yStep
+	}
+
+	bitsPP := d.bitsPerPixel()
+	bpp := (bitsPP + 7) / 8
+	rowSize := (w*bitsPP + 7) / 8
+	prev := make([]byte, rowSize)
+
+	for j := 0; j < h; j++ {
+		row := raw[j*(1+rowSize) : (j+1)*(1+rowSize)]
+		cur := row[1:]
+		if err := unfilter(row[0], cur, prev, bpp); err != nil {
+			return 0, err
+		}
+		d.storeRow(img, cur, w, xOffset, yOffset+j*yStep, xStep)
+		prev = cur
+	}
+	return h * (1 + rowSize), nil
+}
+
+// unfilter reverses the filter ft of the row cur in place, given the previous, already unfiltered row prev.
+func unfilter(ft byte, cur, prev []byte, bpp int) error {
+	switch ft {
+	case ftNone:
+	case ftSub:
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += cur[i-bpp]
+		}
+	case ftUp:
+		for i, p := range prev {
+			cur[i] += p
+		}
+	case ftAverage:
+		for i := 0; i < bpp && i < len(cur); i++ {
+			cur[i] += prev[i] / 2
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += uint8((int(cur[i-bpp]) + int(prev[i])) / 2)
+		}
+	case ftPaeth:
+		for i := 0; i < bpp && i < len(cur); i++ {
+			cur[i] += prev[i]
+		}
+		for i := bpp; i < len(cur); i++ {
+			cur[i] += paeth(cur[i-bpp], prev[i], prev[i-bpp])
+		}
+	default:
+		return FormatError("bad filter type")
+	}
+	return nil
+}
+
+// paeth implements the Paeth predictor of the PNG specification.
+func paeth(a, b, c uint8) uint8 {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+// storeRow stores the w pixels of the unfiltered row cur in img, starting at (x0, y) and advancing by xStep.
+func (d *decoder) storeRow(img image.Image, cur []byte, w, x0, y, xStep int) {
+	switch img := img.(type) {
+	case *image.Gray:
+		for i := 0; i < w; i++ {
+			img.Pix[img.PixOffset(x0+i*xStep, y)] = d.gray(cur, i)
+		}
+	case *image.Gray16:
+		for i := 0; i < w; i++ {
+			off := img.PixOffset(x0+i*xStep, y)
+			img.Pix[off], img.Pix[off+1] = cur[2*i], cur[2*i+1]
+		}
+	case *image.Paletted:
+		for i := 0; i < w; i++ {
+			idx := d.sample(cur, i)
+			if int(idx) >= len(img.Palette) {
+				// out of range indices are treated as opaque black, like image/png does
+				for len(img.Palette) <= int(idx) {
+					img.Palette = append(img.Palette, color.RGBA{0, 0, 0, 0xff})
+				}
+			}
+			img.Pix[img.PixOffset(x0+i*xStep, y)] = idx
+		}
+	case *image.RGBA:
+		for i := 0; i < w; i++ {
+			off := img.PixOffset(x0+i*xStep, y)
+			copy(img.Pix[off:off+3], cur[3*i:3*i+3])
+			img.Pix[off+3] = 0xff
+		}
+	case *image.RGBA64:
+		for i := 0; i < w; i++ {
+			off := img.PixOffset(x0+i*xStep, y)
+			copy(img.Pix[off:off+6], cur[6*i:6*i+6])
+			img.Pix[off+6], img.Pix[off+7] = 0xff, 0xff
+		}
+	case *image.NRGBA:
+		for i := 0; i < w; i++ {
+			off := img.PixOffset(x0+i*xStep, y)
+			img.Pix[off], img.Pix[off+1], img.Pix[off+2], img.Pix[off+3] = d.nrgba(cur, i)
+		}
+	case *image.NRGBA64:
+		for i := 0; i < w; i++ {
+			off := img.PixOffset(x0+i*xStep, y)
+			d.nrgba64(img.Pix[off:off+8], cur, i)
+		}
+	}
+}
+
+// sample returns the i-th sample of a row of a single-channel image with a bit depth of at most 8.
+func (d *decoder) sample(cur []byte, i int) uint8 {
+	if d.depth == 8 {
+		return cur[i]
+	}
+	bit := i * d.depth
+	shift := uint(8 - d.depth - bit%8)
+	return cur[bit/8] >> shift & (1<<uint(d.depth) - 1)
+}
+
+// gray returns the i-th sample of a grayscale row with a bit depth of at most 8, scaled to 8 bits.
+func (d *decoder) gray(cur []byte, i int) uint8 {
+	return d.sample(cur, i) * (0xff / (1<<uint(d.depth) - 1))
+}
+
+// nrgba returns the i-th pixel of a row with a bit depth of at most 8 as non-premultiplied RGBA.
+func (d *decoder) nrgba(cur []byte, i int) (r, g, b, a uint8) {
+	switch d.colorType {
+	case ctGrayscale:
+		v := d.gray(cur, i)
+		a = 0xff
+		if uint16(d.sample(cur, i)) == binary.BigEndian.Uint16(d.transparent) {
+			a = 0
+		}
+		return v, v, v, a
+	case ctTrueColor:
+		r, g, b, a = cur[3*i], cur[3*i+1], cur[3*i+2], 0xff
+		t := d.transparent
+		if t[1] == r && t[3] == g && t[5] == b && t[0]|t[2]|t[4] == 0 {
+			a = 0
+		}
+		return r, g, b, a
+	case ctGrayscaleAlpha:
+		return cur[2*i], cur[2*i], cur[2*i], cur[2*i+1]
+	default:
+		return cur[4*i], cur[4*i+1], cur[4*i+2], cur[4*i+3]
+	}
+}
+
+// nrgba64 stores the i-th pixel of a row with a bit depth of 16 as big-endian non-premultiplied RGBA in pix.
+func (d *decoder) nrgba64(pix, cur []byte, i int) {
+	switch d.colorType {
+	case ctGrayscale:
+		s := cur[2*i : 2*i+2]
+		copy(pix[0:2], s)
+		copy(pix[2:4], s)
+		copy(pix[4:6], s)
+		pix[6], pix[7] = 0xff, 0xff
+		if bytes.Equal(s, d.transparent) {
+			pix[6], pix[7] = 0, 0
+		}
+	case ctTrueColor:
+		s := cur[6*i : 6*i+6]
+		copy(pix[0:6], s)
+		pix[6], pix[7] = 0xff, 0xff
+		if bytes.Equal(s, d.transparent) {
+			pix[6], pix[7] = 0, 0
+		}
+	case ctGrayscaleAlpha:
+		s := cur[4*i : 4*i+4]
+		copy(pix[0:2], s[0:2])
+		copy(pix[2:4], s[0:2])
+		copy(pix[4:6], s[0:2])
+		copy(pix[6:8], s[2:4])
+	default:
+		copy(pix[0:8], cur[8*i:8*i+8])
+	}
+}
+
+// chunkCrc32 returns the checksum of a chunk, which covers its type and data.
+func chunkCrc32(typ string, data []byte) uint32 {
+	crc := libdeflate.Crc32(0, []byte(typ))
+	if len(data) == 0 {
+		// libdeflate.Crc32 doesn't continue the running checksum for nil
+		return crc
+	}
+	return libdeflate.Crc32(crc, data)
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,387 @@
+package png
+
+import (
+	"encoding/binary"
+	"image"
+	"image/color"
+	"io"
+	"strconv"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// CompressionLevel indicates the compression level of an Encoder.
+// Besides the constants shared with image/png, positive values select a libdeflate compression level
+// up to libdeflate.MaxCompressionLevel.
+type CompressionLevel int
+
+// These constants mirror those of image/png.
+const (
+	DefaultCompression CompressionLevel = 0
+	NoCompression      CompressionLevel = -1
+	BestSpeed          CompressionLevel = -2
+	BestCompression    CompressionLevel = -3
+)
+
+// MaxCompression is the highest compression level, producing the smallest files at the expense of speed.
+const MaxCompression = CompressionLevel(libdeflate.MaxCompressionLevel)
+
+// maxIDATSize is the maximum size of a single IDAT chunk written by the encoder.
+const maxIDATSize = 1 << 30
+
+// Encoder configures encoding PNG images.
+type Encoder struct {
+	CompressionLevel CompressionLevel
+}
+
+type encoder struct {
+	w      io.Writer
+	m      image.Image
+	level  int
+	ct     byte
+	depth  int
+	bitsPP int
+	err    error
+}
+
+// Encode writes the Image m to w in PNG format at DefaultCompression.
+// Any Image may be encoded, but images that are not image.NRGBA might be encoded lossily.
+func Encode(w io.Writer, m image.Image) error {
+	var e Encoder
+	return e.Encode(w, m)
+}
+
+// Encode writes the Image m to w in PNG format.
+// Errors if the image is empty or too large, or if an invalid compression level was configured.
+func (enc *Encoder) Encode(w io.Writer, m image.Image) error {
+	mw, mh := int64(m.Bounds().Dx()), int64(m.Bounds().Dy())
+	if mw <= 0 || mh <= 0 || mw >= 1<<32 || mh >= 1<<32 {
+		return FormatError("invalid image size: " + strconv.FormatInt(mw, 10) + "x" + strconv.FormatInt(mh, 10))
+	}
+
+	e := &encoder{w: w, m: m}
+	switch enc.CompressionLevel {
+	case DefaultCompression:
+		e.level = libdeflate.DefaultCompressionLevel
+	case NoCompression:
+		e.level = libdeflate.MinCompressionLevel
+	case BestSpeed:
+		e.level = 1
+	case BestCompression:
+		e.level = libdeflate.MaxCompressionLevel
+	default:
+		e.level = int(enc.CompressionLevel)
+		if e.level < 1 || e.level > libdeflate.MaxCompressionLevel {
+			return UnsupportedError("compression level " + strconv.Itoa(e.level))
+		}
+	}
+	e.chooseFormat()
+
+	_, e.err = io.WriteString(w, pngHeader)
+	e.writeIHDR()
+	if p, ok := m.(*image.Paletted); ok && e.ct == ctPaletted {
+		e.writePLTEAndTRNS(p.Palette)
+	}
+	e.writeIDATs()
+	e.writeChunk(nil, "IEND")
+	return e.err
+}
+
+// chooseFormat selects the color type and bit depth of the encoded image, following image/png.
+func (e *encoder) chooseFormat() {
+	if p, ok := e.m.(*image.Paletted); ok && len(p.Palette) <= 256 {
+		e.ct, e.depth = ctPaletted, 8
+		switch n := len(p.Palette); {
+		case n <= 2:
+			e.depth = 1
+		case n <= 4:
+			e.depth = 2
+		case n <= 16:
+			e.depth = 4
+		}
+	} else {
+		opaque := isOpaque(e.m)
+		switch e.m.ColorModel() {
+		case color.GrayModel:
+			e.ct, e.depth = ctGrayscale, 8
+		case color.Gray16Model:
+			e.ct, e.depth = ctGrayscale, 16
+		case color.RGBA64Model, color.NRGBA64Model:
+			e.ct, e.depth = ctTrueColorAlpha, 16
+			if opaque {
+				e.ct = ctTrueColor
+			}
+		default:
+			e.ct, e.depth = ctTrueColorAlpha, 8
+			if opaque {
+				e.ct = ctTrueColor
+			}
+		}
+	}
+
+	d := decoder{colorType: e.ct, depth: e.depth}
+	e.bitsPP = d.bitsPerPixel()
+}
+
+func (e *encoder) writeIHDR() {
+	b := e.m.Bounds()
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:4], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:8], uint32(b.Dy()))
+	ihdr[8] = byte(e.depth)
+	ihdr[9] = e.ct
+	ihdr[10] = 0 // default compression method
+	ihdr[11] = 0 // default filter method
+	ihdr[12] = 0 // non-interlaced
+	e.writeChunk(ihdr, "IHDR")
+}
+
+func (e *encoder) writePLTEAndTRNS(p color.Palette) {
+	plte := make([]byte, 3*len(p))
+	trns := make([]byte, len(p))
+	last := -1
+	for i, c := range p {
+		nc := color.NRGBAModel.Convert(c).(color.NRGBA)
+		plte[3*i], plte[3*i+1], plte[3*i+2] = nc.R, nc.G, nc.B
+		trns[i] = nc.A
+		if nc.A != 0xff {
+			last = i
+		}
+	}
+	e.writeChunk(plte, "PLTE")
+	if last >= 0 {
+		e.writeChunk(trns[:last+1], "tRNS")
+	}
+}
+
+// writeIDATs filters all rows, compresses them with a single zlib compression and writes the result as IDAT chunks.
+func (e *encoder) writeIDATs() {
+	if e.err != nil {
+		return
+	}
+
+	b := e.m.Bounds()
+	rowSize := (b.Dx()*e.bitsPP + 7) / 8
+	bpp := (e.bitsPP + 7) / 8
+	filter := e.level != libdeflate.MinCompressionLevel && e.ct != ctPaletted
+
+	raw := make([]byte, b.Dy()*(1+rowSize))
+	prev := make([]byte, rowSize)
+	cur := make([]byte, rowSize)
+	var candidates [nFilter][]byte
+	if filter {
+		for i := range candidates {
+			candidates[i] = make([]byte, rowSize)
+		}
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		e.encodeRow(cur, y)
+		row := raw[(y-b.Min.Y)*(1+rowSize) : (y-b.Min.Y+1)*(1+rowSize)]
+		if filter {
+			ft := chooseFilter(&candidates, cur, prev, bpp)
+			row[0] = ft
+			copy(row[1:], candidates[ft])
+		} else {
+			row[0] = ftNone
+			copy(row[1:], cur)
+		}
+		prev, cur = cur, prev
+	}
+
+	_, comp, err := libdeflate.CompressLevel(raw, nil, libdeflate.ModeZlib, e.level)
+	if err != nil {
+		e.err = err
+		return
+	}
+	e.writeIDATData(comp)
+}
+
+// writeIDATData writes the compressed image data as IDAT chunks of at most maxIDATSize bytes.
+func (e *encoder) writeIDATData(comp []byte) {
+	for len(comp) > 0 {
+		n := len(comp)
+		if n > maxIDATSize {
+			n = maxIDATSize
+		}
+		e.writeChunk(comp[:n], "IDAT")
+		comp = comp[n:]
+	}
+}
+
+// encodeRow stores the pixels of row y of the image in cur.
+func (e *encoder) encodeRow(cur []byte, y int) {
+	b := e.m.Bounds()
+	switch e.ct {
+	case ctPaletted:
+		p := e.m.(*image.Paletted)
+		off := p.PixOffset(b.Min.X, y)
+		pix := p.Pix[off : off+b.Dx()]
+		if e.depth == 8 {
+			copy(cur, pix)
+			return
+		}
+		for i := range cur {
+			cur[i] = 0
+		}
+		for i, idx := range pix {
+			bit := i * e.depth
+			cur[bit/8] |= idx << uint(8-e.depth-bit%8)
+		}
+
+	case ctGrayscale:
+		if e.depth == 8 {
+			if g, ok := e.m.(*image.Gray); ok {
+				off := g.PixOffset(b.Min.X, y)
+				copy(cur, g.Pix[off:off+b.Dx()])
+				return
+			}
+			for x := b.Min.X; x < b.Max.X; x++ {
+				cur[x-b.Min.X] = color.GrayModel.Convert(e.m.At(x, y)).(color.Gray).Y
+			}
+			return
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			binary.BigEndian.PutUint16(cur[2*(x-b.Min.X):], color.Gray16Model.Convert(e.m.At(x, y)).(color.Gray16).Y)
+		}
+
+	case ctTrueColor:
+		if e.depth == 8 {
+			if rgba, ok := e.m.(*image.RGBA); ok {
+				off := rgba.PixOffset(b.Min.X, y)
+				pix := rgba.Pix[off : off+4*b.Dx()]
+				for i := 0; i < b.Dx(); i++ {
+					copy(cur[3*i:3*i+3], pix[4*i:4*i+3])
+				}
+				return
+			}
+			for x := b.Min.X; x < b.Max.X; x++ {
+				r, g, bl, _ := e.m.At(x, y).RGBA()
+				i := 3 * (x - b.Min.X)
+				cur[i], cur[i+1], cur[i+2] = uint8(r>>8), uint8(g>>8), uint8(bl>>8)
+			}
+			return
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, _ := e.m.At(x, y).RGBA()
+			i := 6 * (x - b.Min.X)
+			binary.BigEndian.PutUint16(cur[i:], uint16(r))
+			binary.BigEndian.PutUint16(cur[i+2:], uint16(g))
+			binary.BigEndian.PutUint16(cur[i+4:], uint16(bl))
+		}
+
+	default:
+		if e.depth == 8 {
+			if nrgba, ok := e.m.(*image.NRGBA); ok {
+				off := nrgba.PixOffset(b.Min.X, y)
+				copy(cur, nrgba.Pix[off:off+4*b.Dx()])
+				return
+			}
+			for x := b.Min.X; x < b.Max.X; x++ {
+				c := color.NRGBAModel.Convert(e.m.At(x, y)).(color.NRGBA)
+				i := 4 * (x - b.Min.X)
+				cur[i], cur[i+1], cur[i+2], cur[i+3] = c.R, c.G, c.B, c.A
+			}
+			return
+		}
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(e.m.At(x, y)).(color.NRGBA64)
+			i := 8 * (x - b.Min.X)
+			binary.BigEndian.PutUint16(cur[i:], c.R)
+			binary.BigEndian.PutUint16(cur[i+2:], c.G)
+			binary.BigEndian.PutUint16(cur[i+4:], c.B)
+			binary.BigEndian.PutUint16(cur[i+6:], c.A)
+		}
+	}
+}
+
+// chooseFilter applies all filter types to cur and returns the one whose output has the smallest
+// sum of absolute values, which is the heuristic recommended by the PNG specification.
+// The filtered rows are stored in candidates.
+func chooseFilter(candidates *[nFilter][]byte, cur, prev []byte, bpp int) byte {
+	best, bestSum := byte(ftNone), -1
+	for ft, row := range candidates {
+		filter(byte(ft), row, cur, prev, bpp)
+		sum := 0
+		for _, v := range row {
+			sum += abs(int(int8(v)))
+		}
+		if bestSum < 0 || sum < bestSum {
+			best, bestSum = byte(ft), sum
+		}
+	}
+	return best
+}
+
+// filter applies the filter ft to the row cur, given the previous row prev, and stores the result in dst.
+func filter(ft byte, dst, cur, prev []byte, bpp int) {
+	switch ft {
+	case ftNone:
+		copy(dst, cur)
+	case ftSub:
+		for i := range cur {
+			if i < bpp {
+				dst[i] = cur[i]
+			} else {
+				dst[i] = cur[i] - cur[i-bpp]
+			}
+		}
+	case ftUp:
+		for i := range cur {
+			dst[i] = cur[i] - prev[i]
+		}
+	case ftAverage:
+		for i := range cur {
+			var left uint8
+			if i >= bpp {
+				left = cur[i-bpp]
+			}
+			dst[i] = cur[i] - uint8((int(left)+int(prev[i]))/2)
+		}
+	case ftPaeth:
+		for i := range cur {
+			var left, upLeft uint8
+			if i >= bpp {
+				left, upLeft = cur[i-bpp], prev[i-bpp]
+			}
+			dst[i] = cur[i] - paeth(left, prev[i], upLeft)
+		}
+	}
+}
+
+// writeChunk writes a chunk of the given type with its length and checksum.
+func (e *encoder) writeChunk(data []byte, typ string) {
+	if e.err != nil {
+		return
+	}
+
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], typ)
+	var crc [4]byte
+	binary.BigEndian.PutUint32(crc[:], chunkCrc32(typ, data))
+
+	if _, e.err = e.w.Write(hdr[:]); e.err != nil {
+		return
+	}
+	if _, e.err = e.w.Write(data); e.err != nil {
+		return
+	}
+	_, e.err = e.w.Write(crc[:])
+}
+
+// isOpaque reports whether all pixels of m are fully opaque.
+func isOpaque(m image.Image) bool {
+	if o, ok := m.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	b := m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := m.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
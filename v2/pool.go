@@ -0,0 +1,251 @@
+package libdeflate
+
+import (
+	"runtime"
+	"sync"
+	"time"
+)
+
+// PoolOptions configures a CompressorPool or DecompressorPool.
+type PoolOptions struct {
+	// MaxIdle is the maximum number of idle instances kept per bucket (i.e. per compression level for a CompressorPool).
+	// Instances returned to a full bucket are closed. If 0, GOMAXPROCS is used.
+	MaxIdle int
+
+	// IdleTimeout is the duration after which an idle instance is closed. If 0, idle instances are kept forever.
+	IdleTimeout time.Duration
+
+	// Prewarm is the number of instances allocated per bucket when the pool is created, at most MaxIdle.
+	// For a CompressorPool, the buckets to prewarm are given by PrewarmLevels.
+	Prewarm int
+
+	// PrewarmLevels are the compression levels a CompressorPool allocates Prewarm Compressors for.
+	// It is ignored by a DecompressorPool.
+	PrewarmLevels []int
+}
+
+// CompressorPool is a goroutine-safe pool of Compressors with a separate bucket for every compression level.
+// Reusing Compressors avoids allocating native memory for each compression, which is up to hundreds of KiB
+// for the highest levels.
+//
+// Always Close() the pool to free the c memory of its idle Compressors.
+type CompressorPool struct {
+	p *pool
+}
+
+// NewCompressorPool returns a new CompressorPool configured by opts.
+// Errors if out of memory or if an invalid compression level was passed in opts.PrewarmLevels.
+func NewCompressorPool(opts PoolOptions) (*CompressorPool, error) {
+	p := newPool(opts, newCompressorAny, closeCompressorAny)
+
+	for _, level := range opts.PrewarmLevels {
+		if err := p.prewarm(level, opts.Prewarm); err != nil {
+			p.close()
+			return nil, err
+		}
+	}
+	return &CompressorPool{p}, nil
+}
+
+// Get returns an idle Compressor of the given level from the pool or creates a new one.
+// Errors if out of memory or if an invalid compression level was passed.
+// Return the Compressor with Put when done; the same Compressor must not be used by multiple goroutines concurrently.
+func (cp *CompressorPool) Get(level int) (Compressor, error) {
+	c, err := cp.p.get(level)
+	if err != nil {
+		return Compressor{}, err
+	}
+	return c.(Compressor), nil
+}
+
+// Put returns c to the pool. c must not be closed and must not be used after calling Put.
+func (cp *CompressorPool) Put(c Compressor) {
+	cp.p.put(c.Level(), c)
+}
+
+// Close closes all idle Compressors. Compressors returned to the pool afterwards are closed right away.
+func (cp *CompressorPool) Close() {
+	cp.p.close()
+}
+
+// DecompressorPool is a goroutine-safe pool of Decompressors.
+// Reusing Decompressors avoids allocating native memory for each decompression.
+//
+// Always Close() the pool to free the c memory of its idle Decompressors.
+type DecompressorPool struct {
+	p *pool
+}
+
+// NewDecompressorPool returns a new DecompressorPool configured by opts,
+// whose Decompressors are created with the given DecompressorOptions.
+// Errors if out of memory.
+func NewDecompressorPool(opts PoolOptions, dcOpts DecompressorOptions) (*DecompressorPool, error) {
+	p := newPool(opts, func(int) (interface{}, error) {
+		return NewDecompressorWithOptions(dcOpts)
+	}, closeDecompressorAny)
+
+	if err := p.prewarm(0, opts.Prewarm); err != nil {
+		p.close()
+		return nil, err
+	}
+	return &DecompressorPool{p}, nil
+}
+
+// Get returns an idle Decompressor from the pool or creates a new one. Errors if out of memory.
+// Return the Decompressor with Put when done; the same Decompressor must not be used by multiple goroutines concurrently.
+func (dp *DecompressorPool) Get() (Decompressor, error) {
+	dc, err := dp.p.get(0)
+	if err != nil {
+		return Decompressor{}, err
+	}
+	return dc.(Decompressor), nil
+}
+
+// Put returns dc to the pool. dc must not be closed and must not be used after calling Put.
+func (dp *DecompressorPool) Put(dc Decompressor) {
+	dp.p.put(0, dc)
+}
+
+// Close closes all idle Decompressors. Decompressors returned to the pool afterwards are closed right away.
+func (dp *DecompressorPool) Close() {
+	dp.p.close()
+}
+
+// defaultCompressorPool and defaultDecompressorPool back the package-level convenience functions.
+var (
+	defaultCompressorPool   = &CompressorPool{newPool(defaultPoolOptions, newCompressorAny, closeCompressorAny)}
+	defaultDecompressorPool = &DecompressorPool{newPool(defaultPoolOptions, newDecompressorAny, closeDecompressorAny)}
+	defaultPoolOptions      = PoolOptions{IdleTimeout: time.Minute}
+)
+
+func newCompressorAny(level int) (interface{}, error) { return NewCompressorLevel(level) }
+func closeCompressorAny(c interface{})                { c.(Compressor).Close() }
+func newDecompressorAny(int) (interface{}, error)     { return NewDecompressor() }
+func closeDecompressorAny(dc interface{})             { dc.(Decompressor).Close() }
+
+// pool keeps idle instances in buckets identified by an integer key, e.g. the compression level.
+type pool struct {
+	mu          sync.Mutex
+	buckets     map[int][]idle
+	maxIdle     int
+	idleTimeout time.Duration
+	timer       *time.Timer
+	closed      bool
+	alloc       func(key int) (interface{}, error)
+	free        func(v interface{})
+}
+
+type idle struct {
+	v     interface{}
+	since time.Time
+}
+
+func newPool(opts PoolOptions, alloc func(int) (interface{}, error), free func(interface{})) *pool {
+	maxIdle := opts.MaxIdle
+	if maxIdle <= 0 {
+		maxIdle = runtime.GOMAXPROCS(0)
+	}
+	return &pool{
+		buckets:     make(map[int][]idle),
+		maxIdle:     maxIdle,
+		idleTimeout: opts.IdleTimeout,
+		alloc:       alloc,
+		free:        free,
+	}
+}
+
+func (p *pool) prewarm(key, n int) error {
+	for i := 0; i < n; i++ {
+		v, err := p.alloc(key)
+		if err != nil {
+			return err
+		}
+		p.put(key, v)
+	}
+	return nil
+}
+
+// get returns the most recently used idle instance of the bucket, so that rarely used ones can time out.
+func (p *pool) get(key int) (interface{}, error) {
+	p.mu.Lock()
+	bucket := p.buckets[key]
+	if len(bucket) > 0 {
+		v := bucket[len(bucket)-1].v
+		bucket[len(bucket)-1] = idle{}
+		p.buckets[key] = bucket[:len(bucket)-1]
+		p.mu.Unlock()
+		return v, nil
+	}
+	p.mu.Unlock()
+
+	return p.alloc(key)
+}
+
+func (p *pool) put(key int, v interface{}) {
+	p.mu.Lock()
+	if p.closed || len(p.buckets[key]) >= p.maxIdle {
+		p.mu.Unlock()
+		p.free(v)
+		return
+	}
+
+	p.buckets[key] = append(p.buckets[key], idle{v, time.Now()})
+	if p.idleTimeout > 0 && p.timer == nil {
+		p.timer = time.AfterFunc(p.idleTimeout, p.evict)
+	}
+	p.mu.Unlock()
+}
+
+// evict closes all instances that have been idle for longer than the idle timeout
+// and schedules the next eviction if idle instances remain.
+func (p *pool) evict() {
+	var expired []interface{}
+	deadline := time.Now().Add(-p.idleTimeout)
+
+	p.mu.Lock()
+	p.timer = nil
+	var oldest time.Time
+	for key, bucket := range p.buckets {
+		// buckets are sorted by the time their instances became idle
+		i := 0
+		for i < len(bucket) && !bucket[i].since.After(deadline) {
+			expired = append(expired, bucket[i].v)
+			i++
+		}
+		remaining := append(bucket[:0], bucket[i:]...)
+		for j := len(remaining); j < len(bucket); j++ {
+			bucket[j] = idle{}
+		}
+		p.buckets[key] = remaining
+
+		if len(remaining) > 0 && (oldest.IsZero() || remaining[0].since.Before(oldest)) {
+			oldest = remaining[0].since
+		}
+	}
+	if !oldest.IsZero() && !p.closed {
+		p.timer = time.AfterFunc(time.Until(oldest.Add(p.idleTimeout)), p.evict)
+	}
+	p.mu.Unlock()
+
+	for _, v := range expired {
+		p.free(v)
+	}
+}
+
+func (p *pool) close() {
+	p.mu.Lock()
+	p.closed = true
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	buckets := p.buckets
+	p.buckets = make(map[int][]idle)
+	p.mu.Unlock()
+
+	for _, bucket := range buckets {
+		for _, e := range bucket {
+			p.free(e.v)
+		}
+	}
+}
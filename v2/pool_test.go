@@ -0,0 +1,187 @@
+package libdeflate
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestCompressorPool(t *testing.T) {
+	cp, err := NewCompressorPool(PoolOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+
+	c, err := cp.Get(MaxCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Level() != MaxCompressionLevel {
+		t.Errorf("level %d, expected %d", c.Level(), MaxCompressionLevel)
+	}
+	cp.Put(c)
+
+	// instances are reused within their level only
+	other, _ := cp.Get(MinCompressionLevel)
+	if other.c == c.c {
+		t.Error("got a Compressor of a different level")
+	}
+	cp.Put(other)
+	again, _ := cp.Get(MaxCompressionLevel)
+	if again.c != c.c {
+		t.Error("idle Compressor was not reused")
+	}
+	cp.Put(again)
+
+	if _, err := cp.Get(30); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+func TestDecompressorPool(t *testing.T) {
+	dp, err := NewDecompressorPool(PoolOptions{MaxIdle: 4, Prewarm: 2}, DecompressorOptions{MaxOutputBytes: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dp.Close()
+
+	if n := len(dp.p.buckets[0]); n != 2 {
+		t.Errorf("%d idle Decompressors after prewarm, expected 2", n)
+	}
+
+	_, comp, _ := Compress(shortString, nil, ModeZlib)
+	dc, err := dp.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := dc.Decompress(comp, nil, ModeZlib); err != ErrOutputLimitExceeded {
+		t.Errorf("expected ErrOutputLimitExceeded, got %v", err)
+	}
+	dp.Put(dc)
+}
+
+func TestCompressorPoolPrewarm(t *testing.T) {
+	cp, err := NewCompressorPool(PoolOptions{MaxIdle: 4, Prewarm: 3, PrewarmLevels: []int{1, 6}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+
+	for _, level := range []int{1, 6} {
+		if n := len(cp.p.buckets[level]); n != 3 {
+			t.Errorf("%d idle Compressors of level %d after prewarm, expected 3", n, level)
+		}
+	}
+
+	if _, err := NewCompressorPool(PoolOptions{Prewarm: 1, PrewarmLevels: []int{30}}); err == nil {
+		t.Error("expected error for invalid prewarm level")
+	}
+}
+
+func TestPoolMaxIdle(t *testing.T) {
+	p, allocs, frees := newCountingPool(PoolOptions{MaxIdle: 2})
+
+	var vs []interface{}
+	for i := 0; i < 3; i++ {
+		v, _ := p.get(0)
+		vs = append(vs, v)
+	}
+	for _, v := range vs {
+		p.put(0, v)
+	}
+	if *allocs != 3 || *frees != 1 {
+		t.Errorf("%d allocs and %d frees, expected 3 and 1", *allocs, *frees)
+	}
+
+	p.close()
+	if *frees != 3 {
+		t.Errorf("%d frees after close, expected 3", *frees)
+	}
+
+	// instances returned to a closed pool are freed right away
+	p.put(0, 42)
+	if *frees != 4 {
+		t.Errorf("%d frees after put to closed pool, expected 4", *frees)
+	}
+}
+
+func TestPoolIdleTimeout(t *testing.T) {
+	p, _, frees := newCountingPool(PoolOptions{IdleTimeout: 20 * time.Millisecond})
+	defer p.close()
+
+	v, _ := p.get(1)
+	p.put(1, v)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		p.mu.Lock()
+		idle := len(p.buckets[1])
+		p.mu.Unlock()
+		n := atomic.LoadInt64(frees)
+		if n == 1 && idle == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("idle instance was not evicted, %d frees", n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestCompressorPoolConcurrent(t *testing.T) {
+	cp, err := NewCompressorPool(PoolOptions{MaxIdle: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cp.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(level int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				c, err := cp.Get(level)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				_, comp, err := c.Compress(shortString, nil, ModeGzip)
+				cp.Put(c)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				_, out, err := Decompress(comp, nil, ModeGzip)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				slicesEqual(shortString, out, t)
+			}
+		}(i%3 + 1)
+	}
+	wg.Wait()
+}
+
+// newCountingPool returns a pool of dummy instances that counts its allocations and frees.
+func newCountingPool(opts PoolOptions) (p *pool, allocs, frees *int64) {
+	allocs, frees = new(int64), new(int64)
+	p = newPool(opts, func(key int) (interface{}, error) {
+		return atomic.AddInt64(allocs, 1), nil
+	}, func(interface{}) {
+		atomic.AddInt64(frees, 1)
+	})
+	return p, allocs, frees
+}
@@ -0,0 +1,222 @@
+package libdeflate
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// readSize is the minimum amount of compressed data a Reader requests from its source at once.
+const readSize = 64 << 10
+
+// Reader is an io.ReadCloser that decompresses data read from an underlying io.Reader with a native Decompressor.
+//
+// In ModeGzip the source may be any (multi-member) gzip stream: The Reader buffers input until a complete member
+// is available and uses the number of bytes consumed by the Decompressor to find where the next member starts.
+// In ModeZlib and ModeDEFLATE the source must consist of length-prefixed frames as written by a Writer.
+//
+// Members and frames are decompressed lazily as a whole, so memory usage is bounded by the largest member or frame.
+// A gzip member that fails to decompress is only read further while it may just be incomplete.
+//
+// A single Reader must not be used across multiple threads concurrently.
+// Always Close() the Reader to free the c memory of the underlying Decompressor.
+type Reader struct {
+	r      io.Reader
+	dc     Decompressor
+	m      Mode
+	buf    []byte
+	in     []byte
+	out    []byte
+	last   int
+	eof    bool
+	closed bool
+	err    error
+}
+
+// NewReader returns a new Reader decompressing data of the given mode read from r.
+// Errors if out of memory or if an invalid mode was passed.
+func NewReader(r io.Reader, m Mode) (*Reader, error) {
+	if m != ModeDEFLATE && m != ModeZlib && m != ModeGzip {
+		return nil, errorInvalidModeDecompressor
+	}
+
+	dc, err := NewDecompressorWithExtendedDecompression(MaxPossibleDecompressionFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{r: r, dc: dc, m: m}, nil
+}
+
+// Read reads decompressed data into p and returns the number of bytes read.
+// At the end of the compressed stream, Read returns 0, io.EOF.
+func (zr *Reader) Read(p []byte) (int, error) {
+	if zr.closed {
+		return 0, errorReaderClosed
+	}
+
+	for len(zr.out) == 0 {
+		if zr.err != nil {
+			return 0, zr.err
+		}
+		zr.err = zr.next()
+	}
+
+	n := copy(p, zr.out)
+	zr.out = zr.out[n:]
+	return n, nil
+}
+
+// Close releases the underlying Decompressor. It does not close the underlying io.Reader.
+func (zr *Reader) Close() error {
+	if zr.closed {
+		return nil
+	}
+	zr.dc.Close()
+	zr.closed = true
+	return nil
+}
+
+// Reset discards the Reader's state and makes it equivalent to the result of NewReader,
+// but reading from r instead. Reset can also be used on a closed Reader.
+func (zr *Reader) Reset(r io.Reader) error {
+	if zr.closed {
+		dc, err := NewDecompressorWithExtendedDecompression(MaxPossibleDecompressionFactor)
+		if err != nil {
+			return err
+		}
+		zr.dc = dc
+		zr.closed = false
+	}
+
+	zr.r = r
+	zr.in = zr.buf[:0]
+	zr.out = nil
+	zr.last = 0
+	zr.eof = false
+	zr.err = nil
+	return nil
+}
+
+// next decompresses the next member or frame into zr.out.
+func (zr *Reader) next() error {
+	if zr.m == ModeGzip {
+		return zr.nextMember()
+	}
+	return zr.nextFrame()
+}
+
+func (zr *Reader) nextMember() error {
+	if len(zr.in) == 0 {
+		if err := zr.fill(readSize); err != nil {
+			return err
+		}
+		if len(zr.in) == 0 {
+			return io.EOF
+		}
+	}
+
+	for {
+		c, out, err := zr.dc.DecompressSizeHint(zr.in, zr.sizeHint(), ModeGzip)
+		if err == nil {
+			zr.in = zr.in[c:]
+			zr.setOut(out)
+			return nil
+		}
+
+		// the member is most likely incomplete, so try again with more input,
+		// unless it fails too early to have been cut off by the end of the buffered input
+		if zr.eof || !zr.dc.DecodesAtLeast(zr.in, len(zr.in)/2, ModeGzip) {
+			return err
+		}
+		if err := zr.fill(2 * len(zr.in)); err != nil {
+			return err
+		}
+	}
+}
+
+func (zr *Reader) nextFrame() error {
+	var hdr [frameHeaderSize]byte
+	if _, err := io.ReadFull(zr.r, hdr[:]); err != nil {
+		return err
+	}
+
+	n := int(binary.BigEndian.Uint32(hdr[:]))
+	if n == 0 {
+		return errorBadFrame
+	}
+	// the length is untrusted, so the buffer only grows as the frame arrives
+	zr.in = zr.buf[:0]
+	for len(zr.in) < n {
+		size := 2 * len(zr.in)
+		if size < readSize {
+			size = readSize
+		}
+		if size > n {
+			size = n
+		}
+		if cap(zr.in) < size {
+			buf := make([]byte, len(zr.in), size)
+			copy(buf, zr.in)
+			zr.buf = buf
+			zr.in = buf
+		}
+		read, err := io.ReadFull(zr.r, zr.in[len(zr.in):size])
+		zr.in = zr.in[:len(zr.in)+read]
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	c, out, err := zr.dc.DecompressSizeHint(zr.in, zr.sizeHint(), zr.m)
+	if err != nil {
+		return err
+	}
+	if c != n {
+		return errorBadFrame
+	}
+	zr.setOut(out)
+	return nil
+}
+
+// fill reads from the underlying io.Reader until at least min bytes are buffered or the source is exhausted.
+func (zr *Reader) fill(min int) error {
+	if min < readSize {
+		min = readSize
+	}
+	if cap(zr.buf) < min {
+		buf := make([]byte, len(zr.in), min)
+		copy(buf, zr.in)
+		zr.buf = buf
+		zr.in = buf
+	} else if cap(zr.buf)-cap(zr.in) > 0 {
+		// move the unconsumed input to the front of the buffer
+		zr.in = zr.buf[:copy(zr.buf[:len(zr.in)], zr.in)]
+	}
+
+	for len(zr.in) < min && !zr.eof {
+		n, err := zr.r.Read(zr.in[len(zr.in):min])
+		zr.in = zr.in[:len(zr.in)+n]
+		if err == io.EOF {
+			zr.eof = true
+		} else if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sizeHint guesses the size of the next member or frame, assuming it is about as large as the previous one.
+func (zr *Reader) sizeHint() int {
+	if zr.last > 0 {
+		return zr.last
+	}
+	return 4 * len(zr.in)
+}
+
+func (zr *Reader) setOut(out []byte) {
+	zr.out = out
+	zr.last = len(out)
+}
@@ -0,0 +1,135 @@
+package libdeflate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+	"testing/iotest"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestNewReader(t *testing.T) {
+	r, err := NewReader(&bytes.Buffer{}, ModeGzip)
+	if err != nil {
+		t.Error(err)
+	}
+	defer r.Close()
+
+	if _, err := NewReader(&bytes.Buffer{}, Mode(42)); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+}
+
+func TestReaderStdGzip(t *testing.T) {
+	// two members written by the standard library
+	buf := &bytes.Buffer{}
+	for i := 0; i < 2; i++ {
+		w := gzip.NewWriter(buf)
+		w.Write(shortString)
+		w.Close()
+	}
+
+	r, _ := NewReader(buf, ModeGzip)
+	defer r.Close()
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	slicesEqual(append(shortString, shortString...), out, t)
+}
+
+func TestReaderCorrupted(t *testing.T) {
+	_, comp, _ := Compress(shortString, nil, ModeGzip)
+
+	r, _ := NewReader(bytes.NewReader(comp[:len(comp)-3]), ModeGzip)
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Error("expected error for truncated member")
+	}
+}
+
+func TestReaderCorruptLargeSource(t *testing.T) {
+	in := rsyncTestData(16 << 20)
+	_, comp, _ := Compress(in, nil, ModeGzip)
+
+	// large members are still read in pieces
+	r, _ := NewReader(iotest.HalfReader(bytes.NewReader(comp)), ModeGzip)
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(in, out, t)
+	r.Close()
+
+	// a member that is invalid at its start fails without reading the rest of the source:
+	// its first block has the reserved block type 3
+	comp[10] |= 6
+	src := &countingReader{r: bytes.NewReader(comp)}
+	r, _ = NewReader(src, ModeGzip)
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err == nil {
+		t.Fatal("expected error for corrupt member")
+	}
+	if src.n > len(comp)/8 {
+		t.Errorf("read %d of %d bytes", src.n, len(comp))
+	}
+}
+
+func TestReaderFrameLength(t *testing.T) {
+	// a frame claiming 4 GiB that ends early does not allocate its claimed length
+	frame := append([]byte{0xff, 0xff, 0xff, 0xff}, shortString...)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	r, _ := NewReader(bytes.NewReader(frame), ModeZlib)
+	defer r.Close()
+	if _, err := ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+	runtime.ReadMemStats(&after)
+	if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 1<<20 {
+		t.Errorf("allocated %d bytes for a truncated frame", alloc)
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestWriterReader(t *testing.T) {
+	in := append(bytes.Repeat(shortString, 3000), make([]byte, 1<<20)...)
+
+	for _, m := range []Mode{ModeDEFLATE, ModeZlib, ModeGzip} {
+		buf := &bytes.Buffer{}
+		w, _ := NewWriterSize(buf, m, DefaultCompressionLevel, 100<<10)
+		io.Copy(w, bytes.NewReader(in))
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+
+		r, _ := NewReader(buf, m)
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Error(err)
+		}
+		r.Close()
+		slicesEqual(in, out, t)
+	}
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
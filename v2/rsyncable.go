@@ -0,0 +1,251 @@
+package libdeflate
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// The member boundaries of rsyncable output are placed where the bits of rsyncMask are clear in the rolling hash
+// of the input, but not before rsyncMinSize bytes, which gives members of about 80 KiB on average.
+// Members are cut at rsyncMaxSize bytes if no boundary was found, e.g. in long runs of a single byte.
+// These values must never change, as they define the output.
+const (
+	rsyncMinSize = 1 << 14
+	rsyncMask    = 0xffff << 48 // the high bits depend on all of the last 64 bytes
+	rsyncMaxSize = DefaultChunkSize
+)
+
+// gear maps every byte to a pseudorandom value for the rolling hash, which is a gear hash as used by FastCDC.
+// Each byte is shifted out of the 64 bit hash after 64 bytes, so the hash only depends on the last 64 bytes.
+var gear = gearTable()
+
+// CompressGzipRsyncable compresses in into multi-member gzip data like gzip --rsyncable does:
+// the member boundaries are chosen by a rolling hash over the input, so a local change of the input
+// only changes the compressed members around it and the compressed data resynchronizes afterwards.
+// This keeps transfers of updated files with rsync or other delta tools small, at a small cost in compression ratio.
+//
+// The members are compressed at the given level on the given number of workers. If workers <= 0, GOMAXPROCS workers are used.
+// The output only depends on the input and the level, not on the number of workers,
+// and is identical to the output of an RsyncableWriter.
+// Errors if in is empty or if an invalid compression level was passed.
+func CompressGzipRsyncable(in []byte, level, workers int) ([]byte, error) {
+	if len(in) == 0 {
+		return nil, errorNoInput
+	}
+	buf := &bytes.Buffer{}
+	zw, err := NewRsyncableWriter(buf, level, workers)
+	if err != nil {
+		return nil, err
+	}
+	zw.Write(in)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RsyncableWriter is an io.WriteCloser that compresses everything written to it into multi-member gzip data
+// whose member boundaries are chosen by a rolling hash over the input, see CompressGzipRsyncable.
+// Complete members are buffered until there is one for every worker, which are then compressed in parallel.
+//
+// Unlike Writer, RsyncableWriter has no Flush, as ending a member at an arbitrary point
+// would make the output depend on the sizes of the writes.
+//
+// A single RsyncableWriter must not be used across multiple threads concurrently.
+// Always Close() the RsyncableWriter to write pending data and free the c memory of its Compressors.
+type RsyncableWriter struct {
+	w       io.Writer
+	comps   []Compressor
+	chunker rsyncChunker
+	buf     []byte // data not compressed yet
+	scanned int    // number of bytes of buf the chunker has seen
+	cuts    []int  // ends of the complete members in buf
+	out     [][]byte
+	written bool
+	closed  bool
+	err     error
+}
+
+// NewRsyncableWriter returns a new RsyncableWriter compressing to w at the given level on the given number of workers.
+// If workers <= 0, GOMAXPROCS workers are used. The number of workers does not change the output.
+// Errors if out of memory or if an invalid compression level was passed.
+func NewRsyncableWriter(w io.Writer, level, workers int) (*RsyncableWriter, error) {
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	comps := make([]Compressor, 0, workers)
+	for i := 0; i < workers; i++ {
+		c, err := NewCompressorLevel(level)
+		if err != nil {
+			for _, c := range comps {
+				c.Close()
+			}
+			return nil, err
+		}
+		comps = append(comps, c)
+	}
+	return &RsyncableWriter{
+		w:     w,
+		comps: comps,
+		cuts:  make([]int, 0, workers),
+		out:   make([][]byte, workers),
+	}, nil
+}
+
+// Write buffers p and compresses the complete members once there is one for every worker.
+// It returns the number of bytes consumed from p and the first error encountered.
+func (zw *RsyncableWriter) Write(p []byte) (int, error) {
+	if zw.closed {
+		return 0, errorWriterClosed
+	}
+	if zw.err != nil {
+		return 0, zw.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		// p is consumed in pieces to bound the buffer to a member per worker
+		n := len(p)
+		if n > rsyncMaxSize {
+			n = rsyncMaxSize
+		}
+		zw.buf = append(zw.buf, p[:n]...)
+		p = p[n:]
+		written += n
+
+		for {
+			cut := zw.chunker.cut(zw.buf[zw.scanned:])
+			if cut < 0 {
+				zw.scanned = len(zw.buf)
+				break
+			}
+			zw.scanned += cut
+			zw.cuts = append(zw.cuts, zw.scanned)
+			if len(zw.cuts) == len(zw.comps) {
+				if err := zw.writeMembers(); err != nil {
+					return written, err
+				}
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close compresses the remaining data into the last member and releases the Compressors.
+// If nothing has been written, an empty gzip member is emitted, so the output is always valid gzip.
+// Close does not close the underlying io.Writer.
+func (zw *RsyncableWriter) Close() error {
+	if zw.closed {
+		return zw.err
+	}
+	if zw.err == nil {
+		last := 0
+		if len(zw.cuts) > 0 {
+			last = zw.cuts[len(zw.cuts)-1]
+		}
+		if len(zw.buf) > last {
+			zw.cuts = append(zw.cuts, len(zw.buf))
+		}
+		if zw.writeMembers() == nil && !zw.written {
+			if _, err := zw.w.Write(emptyGzipMember); err != nil {
+				zw.err = err
+			}
+		}
+	}
+	for _, c := range zw.comps {
+		c.Close()
+	}
+	zw.closed = true
+	return zw.err
+}
+
+// writeMembers compresses the complete members in parallel and writes them in order.
+func (zw *RsyncableWriter) writeMembers() error {
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	for i := range zw.cuts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			start := 0
+			if i > 0 {
+				start = zw.cuts[i-1]
+			}
+			member := zw.buf[start:zw.cuts[i]]
+			c := zw.comps[i]
+			size := c.WorstCaseCompressedSize(len(member), ModeGzip)
+			if cap(zw.out[i]) < size {
+				zw.out[i] = make([]byte, size)
+			}
+			n, _, err := c.Compress(member, zw.out[i][:size], ModeGzip)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			zw.out[i] = zw.out[i][:n]
+		}(i)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		zw.err = firstErr
+		return firstErr
+	}
+
+	for i := range zw.cuts {
+		if _, err := zw.w.Write(zw.out[i]); err != nil {
+			zw.err = err
+			return err
+		}
+		zw.written = true
+	}
+
+	if len(zw.cuts) > 0 {
+		end := zw.cuts[len(zw.cuts)-1]
+		zw.buf = zw.buf[:copy(zw.buf, zw.buf[end:])]
+		zw.scanned -= end
+		zw.cuts = zw.cuts[:0]
+	}
+	return nil
+}
+
+// rsyncChunker finds the member boundaries of rsyncable output in a stream of data.
+type rsyncChunker struct {
+	h uint64 // rolling hash
+	n int    // size of the current member so far
+}
+
+// cut continues the current member with data and returns the length of the part of data that completes it,
+// or -1 if data does not complete the member.
+func (c *rsyncChunker) cut(data []byte) int {
+	for i, b := range data {
+		c.h = c.h<<1 + gear[b]
+		c.n++
+		if c.n >= rsyncMaxSize || c.n >= rsyncMinSize && c.h&rsyncMask == 0 {
+			c.h, c.n = 0, 0
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// gearTable generates the values of gear with splitmix64, so they are fixed without spelling out 256 constants.
+func gearTable() (table [256]uint64) {
+	x := uint64(0)
+	for i := range table {
+		x += 0x9e3779b97f4a7c15
+		z := x
+		z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+		z = (z ^ z>>27) * 0x94d049bb133111eb
+		table[i] = z ^ z>>31
+	}
+	return table
+}
@@ -0,0 +1,176 @@
+package libdeflate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestCompressGzipRsyncable(t *testing.T) {
+	in := rsyncTestData(3 << 20)
+
+	comp, err := CompressGzipRsyncable(in, DefaultCompressionLevel, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := memberSizes(comp, t)
+	if len(sizes) < 10 {
+		t.Errorf("only %d members", len(sizes))
+	}
+	total := 0
+	for i, size := range sizes {
+		if size > rsyncMaxSize || size < rsyncMinSize && i < len(sizes)-1 {
+			t.Errorf("member %d has %d bytes", i, size)
+		}
+		total += size
+	}
+	if total != len(in) {
+		t.Errorf("members hold %d of %d bytes", total, len(in))
+	}
+
+	// the output does not depend on the number of workers
+	single, _ := CompressGzipRsyncable(in, DefaultCompressionLevel, 1)
+	slicesEqual(comp, single, t)
+}
+
+func TestRsyncableWriter(t *testing.T) {
+	in := rsyncTestData(2<<20 + 12345)
+	expected, _ := CompressGzipRsyncable(in, MaxCompressionLevel, 0)
+
+	// the output does not depend on the sizes of the writes
+	for _, size := range []int{1000, 65536, 3 << 20} {
+		buf := &bytes.Buffer{}
+		zw, err := NewRsyncableWriter(buf, MaxCompressionLevel, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for pos := 0; pos < len(in); pos += size {
+			end := pos + size
+			if end > len(in) {
+				end = len(in)
+			}
+			if _, err := zw.Write(in[pos:end]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := zw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(expected, buf.Bytes(), t)
+	}
+}
+
+func TestRsyncableLocality(t *testing.T) {
+	in := rsyncTestData(4 << 20)
+	comp, _ := CompressGzipRsyncable(in, DefaultCompressionLevel, 0)
+
+	// inserting a byte near the start only changes the first members
+	edited := append([]byte{}, in[:1000]...)
+	edited = append(edited, '!')
+	edited = append(edited, in[1000:]...)
+	compEdited, _ := CompressGzipRsyncable(edited, DefaultCompressionLevel, 0)
+
+	common := 0
+	for common < len(comp) && common < len(compEdited) &&
+		comp[len(comp)-1-common] == compEdited[len(compEdited)-1-common] {
+		common++
+	}
+	if common < len(comp)*9/10 {
+		t.Errorf("only %d of %d compressed bytes are shared", common, len(comp))
+	}
+}
+
+func TestRsyncableLongRuns(t *testing.T) {
+	in := make([]byte, 2*rsyncMaxSize+10)
+	comp, err := CompressGzipRsyncable(in, DefaultCompressionLevel, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := memberSizes(comp, t)
+	if len(sizes) != 3 || sizes[0] != rsyncMaxSize || sizes[2] != 10 {
+		t.Errorf("unexpected member sizes %v", sizes)
+	}
+}
+
+func TestRsyncableErrors(t *testing.T) {
+	if _, err := CompressGzipRsyncable(nil, DefaultCompressionLevel, 0); err != errorNoInput {
+		t.Errorf("expected errorNoInput, got %v", err)
+	}
+	if _, err := CompressGzipRsyncable(shortString, 30, 0); err == nil {
+		t.Error("expected error for invalid level")
+	}
+
+	buf := &bytes.Buffer{}
+	zw, _ := NewRsyncableWriter(buf, DefaultCompressionLevel, 2)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(emptyGzipMember, buf.Bytes(), t)
+	if _, err := zw.Write(shortString); err != errorWriterClosed {
+		t.Errorf("expected errorWriterClosed, got %v", err)
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestRsyncableStdGzip(t *testing.T) {
+	in := rsyncTestData(1<<20 + 777)
+	comp, err := CompressGzipRsyncable(in, DefaultCompressionLevel, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := gzip.NewReader(bytes.NewReader(comp))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(in, out, t)
+}
+
+// memberSizes returns the decompressed sizes of the gzip members of comp.
+func memberSizes(comp []byte, t *testing.T) []int {
+	dc, err := NewDecompressorWithExtendedDecompression(MaxPossibleDecompressionFactor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dc.Close()
+
+	var sizes []int
+	it := dc.Members(comp, ModeGzip)
+	it.Strict(true)
+	for it.Next() {
+		sizes = append(sizes, len(it.Member().Data))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return sizes
+}
+
+// rsyncTestData returns compressible text of n bytes made of random words.
+func rsyncTestData(n int) []byte {
+	words := bytes.Fields([]byte("the quick brown fox jumps over lazy dog backup archive nightly member boundary rolling hash"))
+	rnd := rand.New(rand.NewSource(1))
+	buf := &bytes.Buffer{}
+	for buf.Len() < n {
+		buf.Write(words[rnd.Intn(len(words))])
+		if rnd.Intn(12) == 0 {
+			buf.WriteByte('\n')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	return buf.Bytes()[:n]
+}
@@ -0,0 +1,146 @@
+package libdeflate
+
+import "encoding/binary"
+
+// zlibTrailerSize is the size of the Adler32 checksum ending a zlib stream.
+const zlibTrailerSize = 4
+
+// defaultZlibHeader is the zlib header written by zlib at its default level.
+var defaultZlibHeader = []byte{0x78, 0x9c}
+
+// transcodeDecompressors back Transcode. As the decompressed data is only used to compute checksums, it may compress
+// extremely well, so the maximum decompression factor is used.
+var transcodeDecompressors = &DecompressorPool{newPool(defaultPoolOptions, newTranscodeDecompressorAny, closeDecompressorAny)}
+
+func newTranscodeDecompressorAny(int) (interface{}, error) {
+	return NewDecompressorWithExtendedDecompression(MaxPossibleDecompressionFactor)
+}
+
+// TranscodeOptions configures TranscodeWithOptions.
+type TranscodeOptions struct {
+	// RecompressLevel, if not 0, recompresses the data at this level instead of reusing the DEFLATE payload,
+	// unless the data is empty.
+	// This is much slower, but may yield a better compression ratio.
+	RecompressLevel int
+	// GzipHeader is the header of gzip output. If nil, the header of gzip input is kept,
+	// and other input gets a header without name and timestamp.
+	GzipHeader *GzipHeader
+}
+
+// Transcode converts the compressed stream in from one format to another, e.g. zlib to gzip, without recompressing:
+// The DEFLATE payload is reused as is, only the header and the trailer are replaced.
+//
+// The stream is decompressed once to locate the end of the payload and to verify the checksum of the source format
+// (Adler32 for zlib, CRC32 and ISIZE for gzip), and the checksum of the target format is computed from the
+// decompressed data. from may be ModeAuto to detect the format of in.
+//
+// This function takes a Decompressor from an internal DecompressorPool shared by all callers of Transcode.
+// Errors with ErrTrailingGarbage if in holds more than one stream, if an invalid mode was passed or if in is corrupt.
+//
+// See TranscodeWithOptions to recompress the data or to set the gzip header.
+func Transcode(in []byte, from, to Mode) ([]byte, error) {
+	return TranscodeWithOptions(in, from, to, TranscodeOptions{})
+}
+
+// TranscodeWithOptions converts the compressed stream in from one format to another like Transcode,
+// but configured by opts.
+func TranscodeWithOptions(in []byte, from, to Mode, opts TranscodeOptions) ([]byte, error) {
+	if from != ModeDEFLATE && from != ModeZlib && from != ModeGzip && from != ModeAuto {
+		return nil, errorInvalidModeDecompressor
+	}
+	if to != ModeDEFLATE && to != ModeZlib && to != ModeGzip {
+		return nil, errorInvalidModeCompressor
+	}
+	if from == ModeAuto {
+		var err error
+		if from, err = DetectMode(in); err != nil {
+			return nil, err
+		}
+	}
+
+	dc, err := transcodeDecompressors.Get()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		payload, data []byte // the raw DEFLATE data within in and the decompressed data
+		n             int
+		hdr           = GzipHeader{OS: GzipOSUnknown}
+		zlibHeader    = defaultZlibHeader
+	)
+	switch from {
+	case ModeGzip:
+		var start int
+		if hdr, start, err = ParseGzipHeader(in); err == nil {
+			if n, data, _, _, err = dc.DecompressGzipWithHeader(in, nil); err == nil {
+				payload = in[start : n-gzipTrailerSize]
+			}
+		}
+	case ModeZlib:
+		// the Adler32 checksum is verified by libdeflate
+		if n, data, err = dc.Decompress(in, nil, ModeZlib); err == nil {
+			payload = in[len(zlibHeader) : n-zlibTrailerSize]
+			zlibHeader = in[:len(zlibHeader)]
+		}
+	default:
+		n, data, err = dc.Decompress(in, nil, ModeDEFLATE)
+		payload = in[:n]
+	}
+	transcodeDecompressors.Put(dc)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(in) {
+		return nil, ErrTrailingGarbage
+	}
+	if opts.GzipHeader != nil {
+		hdr = *opts.GzipHeader
+	}
+
+	// libdeflate does not compress empty data, which would not get any smaller anyway
+	if opts.RecompressLevel != 0 && len(data) > 0 {
+		if opts.GzipHeader == nil {
+			// the extra flags of the input describe its compression level
+			hdr.ExtraFlags = 0
+		}
+		return recompress(data, to, opts.RecompressLevel, hdr)
+	}
+
+	switch to {
+	case ModeGzip:
+		out, err := hdr.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, payload...)
+		out = append(out, make([]byte, gzipTrailerSize)...)
+		binary.LittleEndian.PutUint32(out[len(out)-8:], Crc32(0, data))
+		binary.LittleEndian.PutUint32(out[len(out)-4:], uint32(len(data)))
+		return out, nil
+	case ModeZlib:
+		out := make([]byte, 0, len(zlibHeader)+len(payload)+zlibTrailerSize)
+		out = append(out, zlibHeader...)
+		out = append(out, payload...)
+		out = append(out, make([]byte, zlibTrailerSize)...)
+		binary.BigEndian.PutUint32(out[len(out)-zlibTrailerSize:], Adler32(1, data))
+		return out, nil
+	default:
+		return append([]byte{}, payload...), nil
+	}
+}
+
+// recompress compresses data in mode m at the given level, using hdr as gzip header.
+func recompress(data []byte, m Mode, level int, hdr GzipHeader) ([]byte, error) {
+	c, err := defaultCompressorPool.Get(level)
+	if err != nil {
+		return nil, err
+	}
+	defer defaultCompressorPool.Put(c)
+
+	if m == ModeGzip {
+		_, out, err := c.CompressGzipWithHeader(data, nil, hdr)
+		return out, err
+	}
+	_, out, err := c.Compress(data, make([]byte, c.WorstCaseCompressedSize(len(data), m)), m)
+	return out, err
+}
@@ -0,0 +1,189 @@
+package libdeflate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestTranscode(t *testing.T) {
+	modes := []Mode{ModeDEFLATE, ModeZlib, ModeGzip}
+	for _, in := range [][]byte{shortString, bytes.Repeat(shortString, 1000)} {
+		for _, from := range modes {
+			comp := compressLevel(in, from, MaxCompressionLevel, t)
+			for _, to := range modes {
+				out, err := Transcode(comp, from, to)
+				if err != nil {
+					t.Fatalf("%v to %v: %v", from, to, err)
+				}
+				_, data, err := DecompressWithOptions(out, nil, to, DecompressorOptions{MaxDecompressionFactor: MaxPossibleDecompressionFactor})
+				if err != nil {
+					t.Fatalf("%v to %v: %v", from, to, err)
+				}
+				slicesEqual(in, data, t)
+
+				// the DEFLATE payload is reused
+				if !bytes.Contains(out, payloadOf(comp, from, t)) {
+					t.Errorf("%v to %v: payload not reused", from, to)
+				}
+			}
+		}
+	}
+
+	// empty data is transcoded, but not recompressed
+	for _, opts := range []TranscodeOptions{{}, {RecompressLevel: MaxCompressionLevel}} {
+		out, err := TranscodeWithOptions(emptyGzipMember, ModeGzip, ModeZlib, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual([]byte{0x78, 0x9c, 0x03, 0x00, 0x00, 0x00, 0x00, 0x01}, out, t)
+	}
+}
+
+func TestTranscodeGzipHeader(t *testing.T) {
+	c, _ := NewCompressorLevel(DefaultCompressionLevel)
+	defer c.Close()
+	h := GzipHeader{Name: "data.txt", ModTime: time.Unix(1600000000, 0), OS: GzipOSUnix}
+	_, comp, _ := c.CompressGzipWithHeader(shortString, nil, h)
+
+	// gzip input keeps its header
+	zlibData, _ := Transcode(comp, ModeAuto, ModeZlib)
+	out, err := Transcode(comp, ModeGzip, ModeGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(comp, out, t)
+
+	out, err = TranscodeWithOptions(zlibData, ModeZlib, ModeGzip, TranscodeOptions{GzipHeader: &h})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, _, _ := ParseGzipHeader(out)
+	if parsed.Name != h.Name || !parsed.ModTime.Equal(h.ModTime) {
+		t.Errorf("unexpected header %+v", parsed)
+	}
+
+	out, _ = Transcode(zlibData, ModeZlib, ModeGzip)
+	if parsed, _, _ = ParseGzipHeader(out); parsed.Name != "" || !parsed.ModTime.IsZero() || parsed.OS != GzipOSUnknown {
+		t.Errorf("unexpected default header %+v", parsed)
+	}
+}
+
+func TestTranscodeRecompress(t *testing.T) {
+	in := rsyncTestData(100000)
+	comp := compressLevel(in, ModeZlib, 1, t)
+
+	out, err := TranscodeWithOptions(comp, ModeZlib, ModeGzip, TranscodeOptions{RecompressLevel: MaxCompressionLevel})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) >= len(comp) {
+		t.Errorf("recompressed to %d bytes from %d", len(out), len(comp))
+	}
+	if out[8] != 2 {
+		t.Errorf("expected XFL 2, got %d", out[8])
+	}
+	_, data, err := DecompressWithOptions(out, nil, ModeGzip, DecompressorOptions{MaxDecompressionFactor: MaxPossibleDecompressionFactor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(in, data, t)
+
+	if _, err := TranscodeWithOptions(comp, ModeZlib, ModeGzip, TranscodeOptions{RecompressLevel: 30}); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+func TestTranscodeErrors(t *testing.T) {
+	comp := compressLevel(shortString, ModeZlib, DefaultCompressionLevel, t)
+
+	if _, err := Transcode(comp, Mode(7), ModeGzip); err != errorInvalidModeDecompressor {
+		t.Errorf("expected errorInvalidModeDecompressor, got %v", err)
+	}
+	if _, err := Transcode(comp, ModeZlib, ModeAuto); err != errorInvalidModeCompressor {
+		t.Errorf("expected errorInvalidModeCompressor, got %v", err)
+	}
+	if _, err := Transcode(append(comp, 0), ModeZlib, ModeGzip); err != ErrTrailingGarbage {
+		t.Errorf("expected ErrTrailingGarbage, got %v", err)
+	}
+
+	// the checksum of the source is verified
+	corrupt := append([]byte{}, comp...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	if _, err := Transcode(corrupt, ModeZlib, ModeGzip); err == nil {
+		t.Error("expected error for zlib checksum mismatch")
+	}
+	gz := compressLevel(shortString, ModeGzip, DefaultCompressionLevel, t)
+	gz[len(gz)-5] ^= 0xff
+	if _, err := Transcode(gz, ModeGzip, ModeZlib); err == nil {
+		t.Error("expected error for gzip checksum mismatch")
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestTranscodeStdLib(t *testing.T) {
+	in := bytes.Repeat(shortString, 100)
+	buf := &bytes.Buffer{}
+	zw := zlib.NewWriter(buf)
+	zw.Write(in)
+	zw.Close()
+
+	out, err := Transcode(buf.Bytes(), ModeAuto, ModeGzip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := gzip.NewReader(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(in, data, t)
+
+	back, err := Transcode(out, ModeGzip, ModeZlib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(buf.Bytes(), back, t)
+}
+
+func compressLevel(in []byte, m Mode, level int, t *testing.T) []byte {
+	c, err := NewCompressorLevel(level)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, out, err := c.Compress(in, make([]byte, c.WorstCaseCompressedSize(len(in), m)), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+// payloadOf returns the raw DEFLATE data of comp, which has a minimal header.
+func payloadOf(comp []byte, m Mode, t *testing.T) []byte {
+	switch m {
+	case ModeZlib:
+		return comp[2 : len(comp)-4]
+	case ModeGzip:
+		_, n, err := ParseGzipHeader(comp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return comp[n : len(comp)-8]
+	default:
+		return comp
+	}
+}
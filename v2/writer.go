@@ -0,0 +1,202 @@
+package libdeflate
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// DefaultChunkSize is the amount of uncompressed data a Writer buffers before it compresses and emits it.
+const DefaultChunkSize = 1 << 20
+
+// frameHeaderSize is the size of the big-endian length prefix in front of every zlib/DEFLATE frame.
+const frameHeaderSize = 4
+
+// emptyGzipMember is a complete gzip member holding no data. It is emitted if a gzip Writer is closed
+// without any data being written, as libdeflate refuses to compress empty input.
+var emptyGzipMember = []byte{
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, // header
+	0x03, 0x00, // empty static block with BFINAL set
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // crc32, isize
+}
+
+// Writer is an io.WriteCloser that compresses everything written to it with a native Compressor,
+// while keeping memory usage bounded by its chunk size.
+//
+// Written data is buffered until a full chunk is available, which is then compressed on its own:
+// In ModeGzip every chunk becomes a separate gzip member, so the output is a regular multi-member gzip stream
+// that can be decoded by gzip -d or compress/gzip.
+// In ModeZlib and ModeDEFLATE every chunk becomes a zlib or DEFLATE frame prefixed by its compressed length
+// as a 4-byte big-endian integer. Such streams can be decoded by NewReader.
+//
+// A single Writer must not be used across multiple threads concurrently.
+// Always Close() the Writer to write pending data and free the c memory of the underlying Compressor.
+type Writer struct {
+	w       io.Writer
+	c       Compressor
+	m       Mode
+	lvl     int
+	buf     []byte
+	out     []byte
+	written bool
+	closed  bool
+	err     error
+}
+
+// NewWriter returns a new Writer compressing to w in the given mode at the given level with DefaultChunkSize.
+// Errors if out of memory, if an invalid mode or if an invalid compression level was passed.
+//
+// See NewWriterSize for custom chunk sizes.
+func NewWriter(w io.Writer, m Mode, level int) (*Writer, error) {
+	return NewWriterSize(w, m, level, DefaultChunkSize)
+}
+
+// NewWriterSize returns a new Writer compressing to w in the given mode at the given level.
+// chunkSize is the amount of uncompressed data that is compressed at once. Larger chunks
+// usually yield a better compression ratio at the expense of memory.
+// Errors if out of memory, if an invalid mode, an invalid compression level or a chunkSize <= 0 was passed.
+func NewWriterSize(w io.Writer, m Mode, level, chunkSize int) (*Writer, error) {
+	if m != ModeDEFLATE && m != ModeZlib && m != ModeGzip {
+		return nil, errorInvalidModeCompressor
+	}
+	if chunkSize <= 0 {
+		return nil, errorInvalidChunkSize
+	}
+
+	c, err := NewCompressorLevel(level)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Writer{
+		w:   w,
+		c:   c,
+		m:   m,
+		lvl: level,
+		buf: make([]byte, 0, chunkSize),
+		out: make([]byte, frameHeaderSize+c.WorstCaseCompressedSize(chunkSize, m)),
+	}, nil
+}
+
+// Write buffers p and compresses and writes every chunk that has been filled completely.
+// It returns the number of bytes consumed from p and the first error encountered.
+func (zw *Writer) Write(p []byte) (int, error) {
+	if zw.closed {
+		return 0, errorWriterClosed
+	}
+	if zw.err != nil {
+		return 0, zw.err
+	}
+
+	n := 0
+	chunkSize := cap(zw.buf)
+	for len(p) > 0 {
+		// compress straight from p if there is nothing pending, saving a copy
+		if len(zw.buf) == 0 && len(p) >= chunkSize {
+			if err := zw.emit(p[:chunkSize]); err != nil {
+				return n, err
+			}
+			n += chunkSize
+			p = p[chunkSize:]
+			continue
+		}
+
+		k := copy(zw.buf[len(zw.buf):chunkSize], p)
+		zw.buf = zw.buf[:len(zw.buf)+k]
+		n += k
+		p = p[k:]
+
+		if len(zw.buf) == chunkSize {
+			if err := zw.emit(zw.buf); err != nil {
+				return n, err
+			}
+			zw.buf = zw.buf[:0]
+		}
+	}
+	return n, nil
+}
+
+// Flush compresses and writes all pending data, even if it doesn't fill a whole chunk.
+// Calling Flush often worsens the compression ratio, as every flush ends the current member or frame.
+func (zw *Writer) Flush() error {
+	if zw.closed {
+		return errorWriterClosed
+	}
+	if zw.err != nil {
+		return zw.err
+	}
+	if len(zw.buf) == 0 {
+		return nil
+	}
+
+	err := zw.emit(zw.buf)
+	zw.buf = zw.buf[:0]
+	return err
+}
+
+// Close flushes all pending data and releases the underlying Compressor.
+// If nothing has been written in ModeGzip, an empty gzip member is emitted, so the output is always valid gzip.
+// Close does not close the underlying io.Writer.
+func (zw *Writer) Close() error {
+	if zw.closed {
+		return zw.err
+	}
+
+	err := zw.Flush()
+	if err == nil && !zw.written && zw.m == ModeGzip {
+		_, err = zw.w.Write(emptyGzipMember)
+		zw.written = true
+	}
+
+	zw.c.Close()
+	zw.closed = true
+	if zw.err == nil {
+		zw.err = err
+	}
+	return err
+}
+
+// Reset discards the Writer's state and makes it equivalent to the result of the original constructor,
+// but writing to w instead. Reset can also be used on a closed Writer.
+func (zw *Writer) Reset(w io.Writer) {
+	zw.w = w
+	zw.buf = zw.buf[:0]
+	zw.written = false
+	zw.err = nil
+
+	if zw.closed {
+		zw.c, zw.err = NewCompressorLevel(zw.lvl)
+		zw.closed = zw.err != nil
+	}
+}
+
+// emit compresses chunk into a single member or frame and writes it to the underlying io.Writer.
+func (zw *Writer) emit(chunk []byte) error {
+	n, err := compressFrame(zw.c, chunk, zw.out, zw.m)
+	if err != nil {
+		zw.err = err
+		return err
+	}
+
+	if _, err := zw.w.Write(zw.out[:n]); err != nil {
+		zw.err = err
+		return err
+	}
+	zw.written = true
+	return nil
+}
+
+// compressFrame compresses chunk into out as a gzip member or, in ModeZlib and ModeDEFLATE,
+// as a frame prefixed by its compressed length. Returns the number of bytes written to out.
+func compressFrame(c Compressor, chunk, out []byte, m Mode) (int, error) {
+	if m == ModeGzip {
+		n, _, err := c.Compress(chunk, out, m)
+		return n, err
+	}
+
+	n, _, err := c.Compress(chunk, out[frameHeaderSize:], m)
+	if err != nil {
+		return 0, err
+	}
+	binary.BigEndian.PutUint32(out, uint32(n))
+	return frameHeaderSize + n, nil
+}
@@ -0,0 +1,121 @@
+package libdeflate
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestNewWriter(t *testing.T) {
+	w, err := NewWriter(&bytes.Buffer{}, ModeGzip, DefaultCompressionLevel)
+	if err != nil {
+		t.Error(err)
+	}
+	defer w.Close()
+
+	if _, err := NewWriter(&bytes.Buffer{}, ModeGzip, 30); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if _, err := NewWriterSize(&bytes.Buffer{}, ModeGzip, DefaultCompressionLevel, 0); err == nil {
+		t.Error("expected error for invalid chunk size")
+	}
+	if _, err := NewWriter(&bytes.Buffer{}, Mode(42), DefaultCompressionLevel); err == nil {
+		t.Error("expected error for invalid mode")
+	}
+}
+
+func TestWriterGzipMultiMember(t *testing.T) {
+	in := bytes.Repeat(shortString, 100)
+	buf := &bytes.Buffer{}
+	w, _ := NewWriterSize(buf, ModeGzip, DefaultCompressionLevel, 1000)
+
+	if _, err := io.Copy(w, bytes.NewReader(in)); err != nil {
+		t.Error(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+
+	r, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	slicesEqual(in, out, t)
+}
+
+func TestWriterGzipEmpty(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, _ := NewWriter(buf, ModeGzip, DefaultCompressionLevel)
+	w.Close()
+
+	r, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil || len(out) != 0 {
+		t.Error(err)
+	}
+}
+
+func TestWriterZlibFrames(t *testing.T) {
+	in := bytes.Repeat(shortString, 100)
+	buf := &bytes.Buffer{}
+	w, _ := NewWriterSize(buf, ModeZlib, DefaultCompressionLevel, 1000)
+
+	w.Write(in[:500])
+	w.Write(in[500:])
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+	if _, err := w.Write(in); err != errorWriterClosed {
+		t.Error("expected error after close")
+	}
+
+	out := &bytes.Buffer{}
+	comp := buf.Bytes()
+	for len(comp) > 0 {
+		n := int(binary.BigEndian.Uint32(comp))
+		r, err := zlib.NewReader(bytes.NewReader(comp[frameHeaderSize : frameHeaderSize+n]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(out, r)
+		comp = comp[frameHeaderSize+n:]
+	}
+	slicesEqual(in, out.Bytes(), t)
+}
+
+func TestWriterReset(t *testing.T) {
+	w, _ := NewWriter(&bytes.Buffer{}, ModeGzip, DefaultCompressionLevel)
+	w.Write(shortString)
+	w.Close()
+
+	buf := &bytes.Buffer{}
+	w.Reset(buf)
+	w.Write(shortString)
+	if err := w.Close(); err != nil {
+		t.Error(err)
+	}
+
+	r, err := gzip.NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := ioutil.ReadAll(r)
+	slicesEqual(shortString, out, t)
+}
@@ -0,0 +1,114 @@
+package wsdeflate
+
+import (
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// DefaultMaxMessageSize is the maximum size of a decompressed message used if none is configured.
+const DefaultMaxMessageSize = 16 << 20
+
+// RSV1 is the bit of the first byte of a WebSocket frame that marks the message as compressed.
+// It is set on the first frame of a compressed message only.
+const RSV1 = 0x40
+
+// tail is appended to a received payload before decompressing it: the 00 00 ff ff removed by the sender,
+// which completes an empty stored block, followed by an empty final stored block, as libdeflate requires the data
+// to end with a final block. For senders finishing with a final block already, the appended blocks are ignored.
+var tail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// emptyMessage is the payload of a compressed empty message, see RFC 7692 section 7.2.3.6.
+var emptyMessage = []byte{0x00}
+
+// Codec compresses and decompresses the payload of WebSocket messages of connections that negotiated
+// permessage-deflate with Negotiate or AcceptResponse.
+//
+// As no context is kept between messages, a Codec holds no per-connection state: it may be shared by all connections
+// and used concurrently. Its Compressors and Decompressors are pooled.
+// Always Close() the Codec to free the c memory of the pooled Compressors and Decompressors.
+type Codec struct {
+	level int
+	cp    *libdeflate.CompressorPool
+	dp    *libdeflate.DecompressorPool
+}
+
+// NewCodec returns a new Codec compressing messages at the given level and decompressing messages up to
+// maxMessageSize bytes. If maxMessageSize <= 0, DefaultMaxMessageSize is used.
+// Errors if out of memory or if an invalid compression level was passed.
+func NewCodec(level int, maxMessageSize int64) (*Codec, error) {
+	if level < libdeflate.MinCompressionLevel || level > libdeflate.MaxCompressionLevel {
+		return nil, errorInvalidLevel
+	}
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
+	}
+
+	cp, err := libdeflate.NewCompressorPool(libdeflate.PoolOptions{})
+	if err != nil {
+		return nil, err
+	}
+	dp, err := libdeflate.NewDecompressorPool(libdeflate.PoolOptions{}, libdeflate.DecompressorOptions{
+		MaxDecompressionFactor: libdeflate.MaxPossibleDecompressionFactor,
+		MaxOutputBytes:         maxMessageSize,
+	})
+	if err != nil {
+		cp.Close()
+		return nil, err
+	}
+	return &Codec{level: level, cp: cp, dp: dp}, nil
+}
+
+// Compress compresses the message msg with raw DEFLATE and returns the payload to send with the RSV1 bit set.
+//
+// libdeflate terminates the data with a final block. Following RFC 7692 section 7.2.3.3, the header byte
+// of an empty stored block is appended, whose remaining 00 00 ff ff are removed as the RFC requires.
+func (c *Codec) Compress(msg []byte) ([]byte, error) {
+	if len(msg) == 0 {
+		return append([]byte{}, emptyMessage...), nil
+	}
+
+	comp, err := c.cp.Get(c.level)
+	if err != nil {
+		return nil, err
+	}
+	defer c.cp.Put(comp)
+
+	out := make([]byte, comp.WorstCaseCompressedSize(len(msg), libdeflate.ModeDEFLATE)+1)
+	n, _, err := comp.Compress(msg, out, libdeflate.ModeDEFLATE)
+	if err != nil {
+		return nil, err
+	}
+	out[n] = 0x00
+	return out[:n+1], nil
+}
+
+// Decompress decompresses the payload of a message received with the RSV1 bit set, after re-adding the
+// 00 00 ff ff removed by the sender. Fragmented messages must be reassembled before.
+// Errors with ErrMessageTooLarge if the message exceeds the maximum message size.
+func (c *Codec) Decompress(payload []byte) ([]byte, error) {
+	in := make([]byte, len(payload)+len(tail))
+	copy(in[copy(in, payload):], tail)
+
+	dc, err := c.dp.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer c.dp.Put(dc)
+
+	_, out, err := dc.Decompress(in, nil, libdeflate.ModeDEFLATE)
+	if err == libdeflate.ErrOutputLimitExceeded {
+		return nil, ErrMessageTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if out == nil {
+		out = []byte{}
+	}
+	return out, nil
+}
+
+// Close frees the pooled Compressors and Decompressors. The Codec must not be used afterwards.
+func (c *Codec) Close() {
+	c.cp.Close()
+	c.dp.Close()
+}
@@ -0,0 +1,13 @@
+package wsdeflate
+
+import "errors"
+
+// ErrMessageTooLarge is returned if a decompressed message exceeds the maximum message size of a Codec.
+var ErrMessageTooLarge = errors.New("libdeflate: wsdeflate: message exceeds size limit")
+
+var (
+	errorSyntax       = errors.New("libdeflate: wsdeflate: malformed Sec-WebSocket-Extensions header")
+	errorParams       = errors.New("libdeflate: wsdeflate: invalid permessage-deflate parameters")
+	errorNegotiation  = errors.New("libdeflate: wsdeflate: unacceptable permessage-deflate response")
+	errorInvalidLevel = errors.New("libdeflate: wsdeflate: invalid compression level")
+)
@@ -0,0 +1,304 @@
+// Package wsdeflate implements the permessage-deflate WebSocket extension of RFC 7692 with libdeflate.
+//
+// libdeflate compresses and decompresses whole buffers, so it can't keep the LZ77 window between messages.
+// Therefore, this package only negotiates the extension with no context takeover in both directions:
+// Every message is compressed and decompressed on its own, which suits gateways sending whole messages.
+//
+// The package does not implement the WebSocket protocol itself. During the opening handshake, a server calls Negotiate
+// and a client sends ClientOffer and checks the response with AcceptResponse. Afterwards, the payload of
+// messages with the RSV1 bit set is compressed and decompressed by a Codec.
+package wsdeflate
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ExtensionName is the name of the permessage-deflate extension in the Sec-WebSocket-Extensions header.
+const ExtensionName = "permessage-deflate"
+
+// ClientOffer is the value of the Sec-WebSocket-Extensions header a client sends to offer permessage-deflate
+// without context takeover.
+const ClientOffer = ExtensionName + "; server_no_context_takeover; client_no_context_takeover"
+
+// maxWindowBits is the base-2 logarithm of the LZ77 window size of libdeflate, which can't be reduced.
+const maxWindowBits = 15
+
+// Extension is an element of a Sec-WebSocket-Extensions header: an extension name with its parameters.
+// Parameters without a value map to "".
+type Extension struct {
+	Name   string
+	Params map[string]string
+}
+
+// Params are the parameters of the permessage-deflate extension, see RFC 7692 section 7.1.
+type Params struct {
+	ServerNoContextTakeover bool
+	ClientNoContextTakeover bool
+	// ServerMaxWindowBits and ClientMaxWindowBits are the base-2 logarithms of the LZ77 window sizes (8 to 15),
+	// or 0 if the parameter is absent. A client_max_window_bits parameter without a value is parsed as 15.
+	ServerMaxWindowBits int
+	ClientMaxWindowBits int
+}
+
+// String returns the Params as an element of a Sec-WebSocket-Extensions header.
+func (p Params) String() string {
+	s := ExtensionName
+	if p.ServerNoContextTakeover {
+		s += "; server_no_context_takeover"
+	}
+	if p.ClientNoContextTakeover {
+		s += "; client_no_context_takeover"
+	}
+	if p.ServerMaxWindowBits != 0 {
+		s += "; server_max_window_bits=" + strconv.Itoa(p.ServerMaxWindowBits)
+	}
+	if p.ClientMaxWindowBits != 0 {
+		s += "; client_max_window_bits=" + strconv.Itoa(p.ClientMaxWindowBits)
+	}
+	return s
+}
+
+// Negotiate selects the first permessage-deflate offer in the Sec-WebSocket-Extensions headers of a client's
+// opening handshake h that a Codec can serve, and returns the Params to respond with. The response always disables
+// context takeover in both directions, which RFC 7692 allows a server to do even if the client did not offer it.
+// Offers with invalid parameters or limiting the window of the server below 15 bits are declined.
+//
+// If ok is false, the extension is not used and must not appear in the response.
+// Otherwise, set the Sec-WebSocket-Extensions header of the response to params.String().
+func Negotiate(h http.Header) (params Params, ok bool) {
+	exts, err := ParseExtensions(h)
+	if err != nil {
+		return Params{}, false
+	}
+	for _, e := range exts {
+		if e.Name != ExtensionName {
+			continue
+		}
+		offer, err := parseParams(e, true)
+		if err != nil || (offer.ServerMaxWindowBits != 0 && offer.ServerMaxWindowBits < maxWindowBits) {
+			continue
+		}
+		return Params{
+			ServerNoContextTakeover: true,
+			ClientNoContextTakeover: true,
+			ServerMaxWindowBits:     offer.ServerMaxWindowBits,
+		}, true
+	}
+	return Params{}, false
+}
+
+// AcceptResponse checks the Sec-WebSocket-Extensions headers of the server's response h to a handshake
+// offering ClientOffer. If ok is false, the server did not accept the extension and messages must not be compressed.
+// Errors if the response is invalid or requires context takeover or a window smaller than 15 bits,
+// in which case the client must fail the WebSocket connection.
+func AcceptResponse(h http.Header) (params Params, ok bool, err error) {
+	exts, err := ParseExtensions(h)
+	if err != nil {
+		return Params{}, false, err
+	}
+	for _, e := range exts {
+		if e.Name != ExtensionName {
+			continue
+		}
+		if ok {
+			return Params{}, false, errorNegotiation
+		}
+		if params, err = parseParams(e, false); err != nil {
+			return Params{}, false, err
+		}
+		if !params.ServerNoContextTakeover || (params.ClientMaxWindowBits != 0 && params.ClientMaxWindowBits < maxWindowBits) {
+			return Params{}, false, errorNegotiation
+		}
+		ok = true
+	}
+	return params, ok, nil
+}
+
+// ParseExtensions parses all Sec-WebSocket-Extensions headers of h into their elements, in order.
+// Quoted parameter values are unquoted.
+func ParseExtensions(h http.Header) ([]Extension, error) {
+	var exts []Extension
+	for _, v := range h.Values("Sec-WebSocket-Extensions") {
+		p := parser{s: v}
+		for {
+			p.skipSpace()
+			if p.done() {
+				break
+			}
+			e, err := p.extension()
+			if err != nil {
+				return nil, err
+			}
+			exts = append(exts, e)
+
+			p.skipSpace()
+			if p.done() {
+				break
+			}
+			if !p.consume(',') {
+				return nil, errorSyntax
+			}
+		}
+	}
+	return exts, nil
+}
+
+// parseParams validates the parameters of a permessage-deflate element, see RFC 7692 section 7.1.
+// Only offers may contain client_max_window_bits without a value.
+func parseParams(e Extension, offer bool) (Params, error) {
+	var p Params
+	for name, value := range e.Params {
+		switch name {
+		case "server_no_context_takeover", "client_no_context_takeover":
+			if value != "" {
+				return Params{}, errorParams
+			}
+			if name == "server_no_context_takeover" {
+				p.ServerNoContextTakeover = true
+			} else {
+				p.ClientNoContextTakeover = true
+			}
+		case "server_max_window_bits":
+			bits, err := windowBits(value)
+			if err != nil {
+				return Params{}, err
+			}
+			p.ServerMaxWindowBits = bits
+		case "client_max_window_bits":
+			if value == "" && offer {
+				p.ClientMaxWindowBits = maxWindowBits
+				continue
+			}
+			bits, err := windowBits(value)
+			if err != nil {
+				return Params{}, err
+			}
+			p.ClientMaxWindowBits = bits
+		default:
+			return Params{}, errorParams
+		}
+	}
+	return p, nil
+}
+
+// windowBits parses the value of a *_max_window_bits parameter, which must be a decimal from 8 to 15.
+func windowBits(value string) (int, error) {
+	if len(value) == 0 || len(value) > 2 || value[0] == '0' {
+		return 0, errorParams
+	}
+	bits, err := strconv.Atoi(value)
+	if err != nil || bits < 8 || bits > maxWindowBits {
+		return 0, errorParams
+	}
+	return bits, nil
+}
+
+// parser parses a Sec-WebSocket-Extensions header following RFC 6455 section 9.1.
+type parser struct {
+	s   string
+	pos int
+}
+
+func (p *parser) done() bool {
+	return p.pos == len(p.s)
+}
+
+func (p *parser) skipSpace() {
+	for !p.done() && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) consume(c byte) bool {
+	if !p.done() && p.s[p.pos] == c {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// extension parses an extension name followed by its parameters.
+func (p *parser) extension() (Extension, error) {
+	name := p.token()
+	if name == "" {
+		return Extension{}, errorSyntax
+	}
+	e := Extension{Name: name, Params: map[string]string{}}
+	for {
+		p.skipSpace()
+		if !p.consume(';') {
+			return e, nil
+		}
+		p.skipSpace()
+		param := p.token()
+		if param == "" {
+			return Extension{}, errorSyntax
+		}
+		if _, dup := e.Params[param]; dup {
+			return Extension{}, errorParams
+		}
+
+		p.skipSpace()
+		value := ""
+		if p.consume('=') {
+			p.skipSpace()
+			var err error
+			if value, err = p.value(); err != nil {
+				return Extension{}, err
+			}
+		}
+		e.Params[param] = value
+	}
+}
+
+// value parses a token or a quoted string, which must hold a token according to RFC 6455.
+func (p *parser) value() (string, error) {
+	if !p.consume('"') {
+		if v := p.token(); v != "" {
+			return v, nil
+		}
+		return "", errorSyntax
+	}
+
+	var b strings.Builder
+	for !p.done() {
+		c := p.s[p.pos]
+		p.pos++
+		switch c {
+		case '"':
+			if b.Len() == 0 {
+				return "", errorSyntax
+			}
+			return b.String(), nil
+		case '\\':
+			if p.done() {
+				return "", errorSyntax
+			}
+			c = p.s[p.pos]
+			p.pos++
+		}
+		if !isTokenChar(c) {
+			return "", errorSyntax
+		}
+		b.WriteByte(c)
+	}
+	return "", errorSyntax
+}
+
+func (p *parser) token() string {
+	start := p.pos
+	for !p.done() && isTokenChar(p.s[p.pos]) {
+		p.pos++
+	}
+	return p.s[start:p.pos]
+}
+
+// isTokenChar reports whether c may be part of a token as defined by RFC 7230.
+func isTokenChar(c byte) bool {
+	if c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' {
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
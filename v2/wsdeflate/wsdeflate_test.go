@@ -0,0 +1,348 @@
+package wsdeflate
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestParseExtensions(t *testing.T) {
+	h := http.Header{}
+	h.Add("Sec-WebSocket-Extensions", `permessage-deflate; client_max_window_bits, x-webkit-deflate-frame`)
+	h.Add("Sec-WebSocket-Extensions", `permessage-deflate;server_max_window_bits="10" ; client_no_context_takeover`)
+
+	exts, err := ParseExtensions(h)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(exts) != 3 || exts[0].Name != ExtensionName || exts[1].Name != "x-webkit-deflate-frame" || exts[2].Name != ExtensionName {
+		t.Fatalf("parsed %v", exts)
+	}
+	if v, ok := exts[0].Params["client_max_window_bits"]; !ok || v != "" {
+		t.Error("parameter without value not parsed")
+	}
+	if exts[2].Params["server_max_window_bits"] != "10" {
+		t.Error("quoted value not parsed")
+	}
+
+	for _, invalid := range []string{"permessage-deflate;", "permessage-deflate; a=", `a; b="c`, "a b", ", ;", "a; b; b"} {
+		h := http.Header{"Sec-Websocket-Extensions": {invalid}}
+		if _, err := ParseExtensions(h); err == nil {
+			t.Errorf("expected error for %q", invalid)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		offer    string
+		ok       bool
+		response string
+	}{
+		{"permessage-deflate", true, "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{"permessage-deflate; client_max_window_bits", true, "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits=15", true, "permessage-deflate; server_no_context_takeover; client_no_context_takeover; server_max_window_bits=15"},
+		// the window of the server can't be limited, so the second offer is chosen
+		{"permessage-deflate; server_max_window_bits=10, permessage-deflate", true, "permessage-deflate; server_no_context_takeover; client_no_context_takeover"},
+		{"permessage-deflate; server_max_window_bits=10", false, ""},
+		{"permessage-deflate; unknown", false, ""},
+		{"permessage-deflate; client_max_window_bits=16", false, ""},
+		{"permessage-deflate; server_no_context_takeover=1", false, ""},
+		{"x-webkit-deflate-frame", false, ""},
+		{"", false, ""},
+	}
+	for _, c := range cases {
+		h := http.Header{"Sec-Websocket-Extensions": {c.offer}}
+		params, ok := Negotiate(h)
+		if ok != c.ok || (ok && params.String() != c.response) {
+			t.Errorf("%q: negotiated %v %q", c.offer, ok, params.String())
+		}
+	}
+}
+
+func TestAcceptResponse(t *testing.T) {
+	cases := []struct {
+		response string
+		ok       bool
+		err      bool
+	}{
+		{"permessage-deflate; server_no_context_takeover; client_no_context_takeover", true, false},
+		{"permessage-deflate; server_no_context_takeover; client_max_window_bits=15", true, false},
+		{"", false, false},
+		{"permessage-deflate", false, true},
+		{"permessage-deflate; server_no_context_takeover; client_max_window_bits=9", false, true},
+		{"permessage-deflate; server_no_context_takeover; client_max_window_bits", false, true},
+		{"permessage-deflate; server_no_context_takeover, permessage-deflate; server_no_context_takeover", false, true},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		if c.response != "" {
+			h.Set("Sec-WebSocket-Extensions", c.response)
+		}
+		_, ok, err := AcceptResponse(h)
+		if ok != c.ok || (err != nil) != c.err {
+			t.Errorf("%q: %v, %v", c.response, ok, err)
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	c, err := NewCodec(libdeflate.DefaultCompressionLevel, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, msg := range [][]byte{shortString, []byte("Hello"), {}, bytes.Repeat(shortString, 1000)} {
+		payload, err := c.Compress(msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := c.Decompress(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(msg, out, t)
+
+		// the payload is decodable like a browser does: appending 00 00 ff ff and inflating
+		fr := flate.NewReader(io.MultiReader(bytes.NewReader(payload), bytes.NewReader([]byte{0, 0, 0xff, 0xff})))
+		out = make([]byte, len(msg))
+		if _, err := io.ReadFull(fr, out); err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(msg, out, t)
+	}
+}
+
+func TestCodecDecompressSyncFlush(t *testing.T) {
+	c, _ := NewCodec(libdeflate.DefaultCompressionLevel, 1000)
+	defer c.Close()
+
+	// RFC 7692 section 7.2.3.1 and 7.2.3.2
+	for _, payload := range [][]byte{
+		{0xf2, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00},
+		{0x00, 0x05, 0x00, 0xfa, 0xff, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x00},
+		{0xf3, 0x48, 0xcd, 0xc9, 0xc9, 0x07, 0x00, 0x00},
+	} {
+		out, err := c.Decompress(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual([]byte("Hello"), out, t)
+	}
+
+	// messages compressed with sync flushes by compress/flate, like most WebSocket implementations do
+	buf := &bytes.Buffer{}
+	fw, _ := flate.NewWriter(buf, flate.DefaultCompression)
+	fw.Write(shortString)
+	fw.Flush()
+	payload := bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff})
+	out, err := c.Decompress(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slicesEqual(shortString, out, t)
+
+	// the message size is limited
+	big, _ := c.Compress(make([]byte, 1001))
+	if _, err := c.Decompress(big); err != ErrMessageTooLarge {
+		t.Errorf("expected ErrMessageTooLarge, got %v", err)
+	}
+
+	if _, err := NewCodec(13, 0); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+/*---------------------
+	INTEGRATION TESTS
+-----------------------*/
+
+func TestWebSocketUpgrade(t *testing.T) {
+	codec, _ := NewCodec(libdeflate.DefaultCompressionLevel, 0)
+	defer codec.Close()
+
+	// the server echoes a single message, compressing it if permessage-deflate was negotiated
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params, ok := Negotiate(r.Header)
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+		rw.WriteString("Sec-WebSocket-Accept: " + acceptKey(r.Header.Get("Sec-WebSocket-Key")) + "\r\n")
+		if ok {
+			rw.WriteString("Sec-WebSocket-Extensions: " + params.String() + "\r\n")
+		}
+		rw.WriteString("\r\n")
+		rw.Flush()
+
+		msg, err := readMessage(rw.Reader, codec)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := writeMessage(rw.Writer, codec, msg, ok, false); err != nil {
+			t.Error(err)
+		}
+		rw.Flush()
+	}))
+	defer srv.Close()
+
+	for _, compress := range []bool{true, false} {
+		conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, _ := http.NewRequest("GET", srv.URL, nil)
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if compress {
+			req.Header.Set("Sec-WebSocket-Extensions", ClientOffer)
+		}
+		req.Write(conn)
+
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Fatalf("unexpected handshake response %v", resp)
+		}
+		_, ok, err := AcceptResponse(resp.Header)
+		if err != nil || ok != compress {
+			t.Fatalf("negotiated %v, %v", ok, err)
+		}
+
+		bw := bufio.NewWriter(conn)
+		msg := bytes.Repeat(shortString, 100)
+		if err := writeMessage(bw, codec, msg, ok, true); err != nil {
+			t.Fatal(err)
+		}
+		bw.Flush()
+		echo, err := readMessage(br, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		slicesEqual(msg, echo, t)
+		conn.Close()
+	}
+}
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// writeMessage writes msg as a single binary frame, compressed if compress is set.
+func writeMessage(w io.Writer, codec *Codec, msg []byte, compress, mask bool) error {
+	b0 := byte(0x80 | 0x2) // FIN, binary
+	payload := msg
+	if compress {
+		var err error
+		if payload, err = codec.Compress(msg); err != nil {
+			return err
+		}
+		b0 |= RSV1
+	}
+
+	hdr := []byte{b0, 0}
+	switch n := len(payload); {
+	case n < 126:
+		hdr[1] = byte(n)
+	case n < 1<<16:
+		hdr[1] = 126
+		hdr = append(hdr, byte(n>>8), byte(n))
+	default:
+		hdr[1] = 127
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], uint64(n))
+		hdr = append(hdr, ext[:]...)
+	}
+	if mask {
+		key := []byte{1, 2, 3, 4}
+		hdr[1] |= 0x80
+		hdr = append(hdr, key...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ key[i%4]
+		}
+		payload = masked
+	}
+	if _, err := w.Write(hdr); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readMessage reads a single unfragmented frame and decompresses it if the RSV1 bit is set.
+func readMessage(r io.Reader, codec *Codec) ([]byte, error) {
+	var hdr [2]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	n := uint64(hdr[1] & 0x7f)
+	switch n {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		n = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		n = binary.BigEndian.Uint64(ext[:])
+	}
+	var key [4]byte
+	if hdr[1]&0x80 != 0 {
+		if _, err := io.ReadFull(r, key[:]); err != nil {
+			return nil, err
+		}
+	}
+
+	payload, err := ioutil.ReadAll(io.LimitReader(r, int64(n)))
+	if err != nil {
+		return nil, err
+	}
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+	if hdr[0]&RSV1 != 0 {
+		return codec.Decompress(payload)
+	}
+	return payload, nil
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,168 @@
+package zipcodec
+
+import (
+	"archive/zip"
+	"io"
+	"runtime"
+	"sync"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// ArchiveWriter writes a zip archive whose Deflate entries are compressed concurrently by libdeflate.
+// Entries are added as whole buffers and written to the archive in the order they were added.
+//
+// A single ArchiveWriter must not be used across multiple goroutines concurrently.
+// Always Close() the ArchiveWriter to write the pending entries and the central directory.
+type ArchiveWriter struct {
+	zw      *zip.Writer
+	level   int
+	sem     chan struct{} // limits the number of concurrent compressions
+	entries chan *entry   // entries in archive order, written by writeLoop
+	done    chan struct{}
+	pending []byte // compressed data of the entry being written, only accessed by writeLoop
+	mu      sync.Mutex
+	err     error
+	closed  bool
+}
+
+type entry struct {
+	fh    *zip.FileHeader
+	data  []byte
+	comp  []byte
+	err   error
+	ready chan struct{}
+}
+
+// NewArchiveWriter returns a new ArchiveWriter writing a zip archive to w, compressing entries at the given level
+// (libdeflate.MinCompressionLevel to libdeflate.MaxCompressionLevel) on the given number of workers.
+// If workers <= 0, GOMAXPROCS workers are used.
+// Errors if an invalid compression level was passed.
+func NewArchiveWriter(w io.Writer, level, workers int) (*ArchiveWriter, error) {
+	if level < libdeflate.MinCompressionLevel || level > libdeflate.MaxCompressionLevel {
+		return nil, errorInvalidLevel
+	}
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+
+	aw := &ArchiveWriter{
+		zw:      zip.NewWriter(w),
+		level:   level,
+		sem:     make(chan struct{}, workers),
+		entries: make(chan *entry, workers),
+		done:    make(chan struct{}),
+	}
+	// entries are compressed already, so the compressor only has to emit the compressed data
+	aw.zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+		return &precompressedWriter{w: w, comp: aw.pending}, nil
+	})
+
+	go aw.writeLoop()
+	return aw, nil
+}
+
+// Create adds an entry with the given name and content, compressed with the Deflate method.
+// See CreateHeader for further information.
+func (aw *ArchiveWriter) Create(name string, data []byte) error {
+	return aw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate}, data)
+}
+
+// CreateHeader adds an entry described by fh with the given content. Entries using the Deflate method are
+// compressed in the background, while entries using other methods are written by the underlying zip.Writer.
+// Neither fh nor data may be modified until the ArchiveWriter is closed.
+//
+// CreateHeader blocks while all workers are busy. Errors that occurred writing previous entries are returned.
+func (aw *ArchiveWriter) CreateHeader(fh *zip.FileHeader, data []byte) error {
+	if aw.closed {
+		return errorWriterClosed
+	}
+	if err := aw.failed(); err != nil {
+		return err
+	}
+
+	e := &entry{fh: fh, data: data, ready: make(chan struct{})}
+	if fh.Method == zip.Deflate {
+		aw.sem <- struct{}{}
+		go func() {
+			e.comp, e.err = deflate(e.data, aw.level)
+			close(e.ready)
+			<-aw.sem
+		}()
+	} else {
+		close(e.ready)
+	}
+
+	aw.entries <- e
+	return nil
+}
+
+// Close waits for all entries to be written and finishes the archive by writing the central directory.
+// It does not close the underlying io.Writer.
+func (aw *ArchiveWriter) Close() error {
+	if aw.closed {
+		return aw.failed()
+	}
+	aw.closed = true
+	close(aw.entries)
+	<-aw.done
+
+	if err := aw.failed(); err != nil {
+		return err
+	}
+	err := aw.zw.Close()
+	aw.fail(err)
+	return err
+}
+
+// writeLoop writes the entries to the archive in order as soon as they are compressed.
+func (aw *ArchiveWriter) writeLoop() {
+	defer close(aw.done)
+	for e := range aw.entries {
+		<-e.ready
+		if aw.failed() != nil {
+			continue
+		}
+
+		err := e.err
+		if err == nil {
+			aw.pending = e.comp
+			var w io.Writer
+			if w, err = aw.zw.CreateHeader(e.fh); err == nil {
+				// the zip.Writer computes the CRC32 and size from the uncompressed data
+				_, err = w.Write(e.data)
+			}
+			aw.pending = nil
+		}
+		aw.fail(err)
+	}
+}
+
+func (aw *ArchiveWriter) fail(err error) {
+	aw.mu.Lock()
+	if aw.err == nil {
+		aw.err = err
+	}
+	aw.mu.Unlock()
+}
+
+func (aw *ArchiveWriter) failed() error {
+	aw.mu.Lock()
+	defer aw.mu.Unlock()
+	return aw.err
+}
+
+// precompressedWriter discards the uncompressed data of an entry and writes its compressed data when closed.
+type precompressedWriter struct {
+	w    io.Writer
+	comp []byte
+}
+
+func (pw *precompressedWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (pw *precompressedWriter) Close() error {
+	_, err := pw.w.Write(pw.comp)
+	return err
+}
@@ -0,0 +1,195 @@
+// Package zipcodec plugs libdeflate into archive/zip as the codec for the Deflate method (8).
+//
+// archive/zip registers compress/flate for Deflate globally and panics on re-registration,
+// so the codecs are registered per zip.Writer and zip.Reader: NewWriter and NewReader return ones
+// using libdeflate, or register NewCompressor and Decompressor on your own:
+//
+//	zw := zip.NewWriter(w)
+//	c, err := zipcodec.NewCompressor(libdeflate.DefaultCompressionLevel)
+//	zw.RegisterCompressor(zip.Deflate, c)
+//
+// As libdeflate operates on whole buffers, every entry is buffered in memory while it is written or read.
+// For writing many entries, ArchiveWriter additionally compresses them concurrently.
+package zipcodec
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// emptyFinalBlock is a static Huffman block containing only the end-of-block symbol with BFINAL set,
+// which is the DEFLATE data of an empty entry, as libdeflate refuses to compress empty input.
+var emptyFinalBlock = []byte{0x03, 0x00}
+
+// decompressors are shared by all readers of this package.
+var decompressors, _ = libdeflate.NewDecompressorPool(libdeflate.PoolOptions{}, libdeflate.DecompressorOptions{
+	MaxDecompressionFactor: libdeflate.MaxPossibleDecompressionFactor,
+})
+
+// NewWriter returns a new zip.Writer writing to w, whose Deflate entries are compressed by libdeflate
+// at the given level (libdeflate.MinCompressionLevel to libdeflate.MaxCompressionLevel).
+// Errors if an invalid compression level was passed.
+func NewWriter(w io.Writer, level int) (*zip.Writer, error) {
+	c, err := NewCompressor(level)
+	if err != nil {
+		return nil, err
+	}
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, c)
+	return zw, nil
+}
+
+// NewReader returns a new zip.Reader reading from r, which is assumed to have the given size in bytes,
+// whose Deflate entries are decompressed by libdeflate.
+//
+// Unlike the plain Decompressor, the returned reader sizes the output of every entry by the UncompressedSize64
+// recorded in the central directory, so an entry is usually decompressed with a single exact allocation.
+// As archive/zip only passes the compressed data of an entry to its decompressor, as an *io.SectionReader,
+// the entry is identified by its compressed size. Entries sharing their compressed size are sized like with Decompressor.
+func NewReader(r io.ReaderAt, size int64) (*zip.Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := entrySizes(zr.File)
+	zr.RegisterDecompressor(zip.Deflate, func(r io.Reader) io.ReadCloser {
+		hint := 0
+		// if archive/zip stops passing an *io.SectionReader, entries are decompressed without a hint
+		if s, ok := r.(*io.SectionReader); ok {
+			hint = sizes[s.Size()]
+		}
+		return &entryReader{r: r, sizeHint: hint}
+	})
+	return zr, nil
+}
+
+// entrySizes maps the compressed sizes of the Deflate entries of files to their uncompressed sizes.
+// The sizes come from the untrusted central directory, so entries sharing their compressed size are mapped to 0
+// rather than to the uncompressed size of another entry.
+func entrySizes(files []*zip.File) map[int64]int {
+	sizes := make(map[int64]int)
+	for _, f := range files {
+		if f.Method != zip.Deflate {
+			continue
+		}
+		if _, ok := sizes[int64(f.CompressedSize64)]; ok {
+			sizes[int64(f.CompressedSize64)] = 0
+		} else {
+			sizes[int64(f.CompressedSize64)] = int(f.UncompressedSize64)
+		}
+	}
+	return sizes
+}
+
+// NewCompressor returns a zip.Compressor compressing entries with libdeflate at the given level
+// (libdeflate.MinCompressionLevel to libdeflate.MaxCompressionLevel).
+// Every entry is buffered until it is closed and then compressed at once.
+// Errors if an invalid compression level was passed.
+func NewCompressor(level int) (zip.Compressor, error) {
+	if level < libdeflate.MinCompressionLevel || level > libdeflate.MaxCompressionLevel {
+		return nil, errorInvalidLevel
+	}
+	return func(w io.Writer) (io.WriteCloser, error) {
+		return &entryWriter{w: w, level: level}, nil
+	}, nil
+}
+
+// Decompressor is a zip.Decompressor decompressing entries with libdeflate.
+// Every entry is read completely and decompressed at the first call to Read.
+// See NewReader for a zip.Reader that also knows the size of the decompressed entries.
+func Decompressor(r io.Reader) io.ReadCloser {
+	return &entryReader{r: r}
+}
+
+// entryWriter buffers an entry and writes it compressed when closed.
+type entryWriter struct {
+	w      io.Writer
+	level  int
+	buf    []byte
+	closed bool
+}
+
+func (ew *entryWriter) Write(p []byte) (int, error) {
+	if ew.closed {
+		return 0, errorWriterClosed
+	}
+	ew.buf = append(ew.buf, p...)
+	return len(p), nil
+}
+
+func (ew *entryWriter) Close() error {
+	if ew.closed {
+		return nil
+	}
+	ew.closed = true
+
+	comp, err := deflate(ew.buf, ew.level)
+	ew.buf = nil
+	if err != nil {
+		return err
+	}
+	_, err = ew.w.Write(comp)
+	return err
+}
+
+// entryReader reads and decompresses a whole entry at the first call to Read.
+type entryReader struct {
+	r        io.Reader
+	sizeHint int
+	out      *bytes.Reader
+	err      error
+}
+
+func (er *entryReader) Read(p []byte) (int, error) {
+	if er.out == nil && er.err == nil {
+		var out []byte
+		out, er.err = inflate(er.r, er.sizeHint)
+		er.out = bytes.NewReader(out)
+	}
+	if er.err != nil {
+		return 0, er.err
+	}
+	return er.out.Read(p)
+}
+
+func (er *entryReader) Close() error {
+	return nil
+}
+
+// deflate compresses data as a raw DEFLATE stream at the given level.
+func deflate(data []byte, level int) ([]byte, error) {
+	if len(data) == 0 {
+		return append([]byte{}, emptyFinalBlock...), nil
+	}
+	_, comp, err := libdeflate.CompressLevel(data, nil, libdeflate.ModeDEFLATE, level)
+	return comp, err
+}
+
+// inflate reads all data from r and decompresses it as a raw DEFLATE stream,
+// starting with an output buffer of sizeHint bytes, or 4*len(in) if sizeHint <= 0.
+func inflate(r io.Reader, sizeHint int) ([]byte, error) {
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(in) == 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if sizeHint <= 0 {
+		sizeHint = 4 * len(in)
+	}
+
+	dc, err := decompressors.Get()
+	if err != nil {
+		return nil, err
+	}
+	defer decompressors.Put(dc)
+
+	_, out, err := dc.DecompressSizeHint(in, sizeHint, libdeflate.ModeDEFLATE)
+	return out, err
+}
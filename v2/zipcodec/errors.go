@@ -0,0 +1,8 @@
+package zipcodec
+
+import "errors"
+
+var (
+	errorInvalidLevel = errors.New("libdeflate: zipcodec: invalid compression level")
+	errorWriterClosed = errors.New("libdeflate: zipcodec: writer already closed")
+)
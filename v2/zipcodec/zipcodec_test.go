@@ -0,0 +1,168 @@
+package zipcodec
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestWriterStdReader(t *testing.T) {
+	entries := testEntries()
+	buf := &bytes.Buffer{}
+	zw, err := NewWriter(buf, libdeflate.MaxCompressionLevel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(e.data)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(zr, entries, t)
+}
+
+func TestStdWriterReader(t *testing.T) {
+	entries := testEntries()
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, e := range entries {
+		w, _ := zw.Create(e.name)
+		w.Write(e.data)
+	}
+	zw.Close()
+
+	zr, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(zr, entries, t)
+
+	// the plain Decompressor works without knowing the entry sizes
+	zr, _ = zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	zr.RegisterDecompressor(zip.Deflate, Decompressor)
+	checkArchive(zr, entries, t)
+}
+
+func TestEntrySizes(t *testing.T) {
+	file := func(method uint16, comp, size uint64) *zip.File {
+		return &zip.File{FileHeader: zip.FileHeader{Method: method, CompressedSize64: comp, UncompressedSize64: size}}
+	}
+	sizes := entrySizes([]*zip.File{
+		file(zip.Deflate, 100, 1000),
+		file(zip.Deflate, 200, 10),
+		file(zip.Deflate, 200, 1<<40), // shares its compressed size, so neither entry gets a hint
+		file(zip.Store, 300, 300),
+	})
+	if sizes[100] != 1000 || sizes[200] != 0 || sizes[300] != 0 {
+		t.Errorf("unexpected sizes %v", sizes)
+	}
+}
+
+func TestInvalidLevel(t *testing.T) {
+	if _, err := NewCompressor(30); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if _, err := NewArchiveWriter(ioutil.Discard, -1, 0); err == nil {
+		t.Error("expected error for invalid level")
+	}
+}
+
+func TestArchiveWriter(t *testing.T) {
+	var entries []testEntry
+	for i := 0; i < 50; i++ {
+		entries = append(entries, testEntry{fmt.Sprintf("file%02d.txt", i), bytes.Repeat(shortString, i*10)})
+	}
+
+	buf := &bytes.Buffer{}
+	aw, err := NewArchiveWriter(buf, libdeflate.DefaultCompressionLevel, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range entries {
+		if i%10 == 0 {
+			err = aw.CreateHeader(&zip.FileHeader{Name: e.name, Method: zip.Store}, e.data)
+		} else {
+			err = aw.Create(e.name, e.data)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := aw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := aw.Create("late", nil); err == nil {
+		t.Error("expected error creating an entry after Close")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkArchive(zr, entries, t)
+	if zr.File[10].Method != zip.Store || zr.File[11].Method != zip.Deflate {
+		t.Error("entry methods were not preserved")
+	}
+}
+
+type testEntry struct {
+	name string
+	data []byte
+}
+
+func testEntries() []testEntry {
+	return []testEntry{
+		{"a.txt", bytes.Repeat(shortString, 100)},
+		{"empty.txt", nil},
+		{"dir/b.txt", shortString},
+		{"c.txt", bytes.Repeat(shortString, 200)},
+	}
+}
+
+func checkArchive(zr *zip.Reader, entries []testEntry, t *testing.T) {
+	if len(zr.File) != len(entries) {
+		t.Fatalf("%d entries, expected %d", len(zr.File), len(entries))
+	}
+	for i, f := range zr.File {
+		if f.Name != entries[i].name {
+			t.Errorf("entry %d is %q, expected %q", i, f.Name, entries[i].name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Errorf("%s: %v", f.Name, err)
+		}
+		slicesEqual(entries[i].data, out, t)
+	}
+}
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,15 @@
+package zlib
+
+import "errors"
+
+var (
+	// ErrChecksum is returned when reading ZLIB data that has an invalid checksum.
+	ErrChecksum = errors.New("libdeflate: zlib: invalid checksum")
+	// ErrDictionary is returned when reading ZLIB data that has a preset dictionary, which is not supported.
+	ErrDictionary = errors.New("libdeflate: zlib: preset dictionaries are not supported")
+	// ErrHeader is returned when reading ZLIB data that has an invalid header.
+	ErrHeader = errors.New("libdeflate: zlib: invalid header")
+
+	errorWriterClosed = errors.New("libdeflate: zlib: writer already closed")
+	errorReaderClosed = errors.New("libdeflate: zlib: reader already closed")
+)
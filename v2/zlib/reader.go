@@ -0,0 +1,111 @@
+package zlib
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+
+	"github.com/4kills/go-libdeflate/v2"
+)
+
+// zlibFDICT is the flag of the zlib header indicating a preset dictionary.
+const zlibFDICT = 0x20
+
+// Resetter resets a ReadCloser returned by NewReader to switch to a new underlying Reader.
+// This permits reusing a ReadCloser instead of allocating a new one.
+type Resetter interface {
+	// Reset discards any buffered data and resets the Resetter as if it was
+	// newly initialized with the given reader. Preset dictionaries are not supported, so dict must be empty.
+	Reset(r io.Reader, dict []byte) error
+}
+
+type reader struct {
+	out []byte
+	err error
+}
+
+// NewReader creates a new ReadCloser.
+// Reads from the returned ReadCloser read and decompress data from r.
+// The whole compressed stream is read from r and decompressed by NewReader.
+// It is the caller's responsibility to call Close on the ReadCloser when done.
+//
+// The ReadCloser returned by NewReader also implements Resetter.
+func NewReader(r io.Reader) (io.ReadCloser, error) {
+	z := new(reader)
+	if err := z.Reset(r, nil); err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+func (z *reader) Read(p []byte) (int, error) {
+	if z.err != nil {
+		return 0, z.err
+	}
+	if len(z.out) == 0 {
+		return 0, io.EOF
+	}
+
+	n := copy(p, z.out)
+	z.out = z.out[n:]
+	return n, nil
+}
+
+// Close does not close the wrapped io.Reader originally passed to NewReader.
+// In order for the ZLIB checksum to be verified, the reader must be fully consumed until the io.EOF.
+func (z *reader) Close() error {
+	if z.err != nil && z.err != io.EOF {
+		return z.err
+	}
+	z.err = errorReaderClosed
+	return nil
+}
+
+func (z *reader) Reset(r io.Reader, dict []byte) error {
+	*z = reader{}
+	if len(dict) > 0 {
+		z.err = ErrDictionary
+		return z.err
+	}
+	z.out, z.err = inflate(r)
+	return z.err
+}
+
+// inflate reads all data from r, decompresses it as a zlib stream and verifies its checksum.
+func inflate(r io.Reader) ([]byte, error) {
+	in, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(in) < 2 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	h := binary.BigEndian.Uint16(in)
+	if in[0]&0x0f != 8 || in[0]>>4 > 7 || h%31 != 0 {
+		return nil, ErrHeader
+	}
+	if in[1]&zlibFDICT != 0 {
+		return nil, ErrDictionary
+	}
+
+	dc, err := libdeflate.NewDecompressorWithExtendedDecompression(libdeflate.MaxPossibleDecompressionFactor)
+	if err != nil {
+		return nil, err
+	}
+	defer dc.Close()
+
+	c, out, err := dc.DecompressSizeHint(in[2:], 4*len(in), libdeflate.ModeDEFLATE)
+	if err != nil {
+		return nil, err
+	}
+
+	trailer := in[2+c:]
+	if len(trailer) < 4 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if binary.BigEndian.Uint32(trailer) != libdeflate.Adler32(1, out) {
+		return nil, ErrChecksum
+	}
+	return out, nil
+}
@@ -0,0 +1,159 @@
+// Package zlib mirrors the API of compress/zlib, but compresses and decompresses zlib data
+// with libdeflate. Migrating from compress/zlib only requires changing the import path.
+//
+// As libdeflate operates on whole buffers, a Writer buffers all data until it is closed
+// and a reader reads its whole source before returning any data.
+// Preset dictionaries are not supported.
+package zlib
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/4kills/go-libdeflate/v2"
+	"github.com/4kills/go-libdeflate/v2/flate"
+)
+
+// These constants are copied from the flate package, so code that imports this package does not also have to
+// import the flate package.
+const (
+	NoCompression      = flate.NoCompression
+	BestSpeed          = flate.BestSpeed
+	BestCompression    = flate.BestCompression
+	DefaultCompression = flate.DefaultCompression
+	HuffmanOnly        = flate.HuffmanOnly
+	MaxCompression     = flate.MaxCompression
+)
+
+// zlibDeflate is the compression method of every zlib stream: DEFLATE with a 32 KiB window.
+const zlibDeflate = 0x78
+
+// A Writer takes data written to it and writes the compressed form of that data to an underlying writer
+// (see NewWriter).
+type Writer struct {
+	w           io.Writer
+	level       int
+	fw          *flate.Writer
+	adler32     uint32
+	wroteHeader bool
+	closed      bool
+	err         error
+}
+
+// NewWriter creates a new Writer.
+// Writes to the returned Writer are compressed and written to w.
+//
+// It is the caller's responsibility to call Close on the Writer when done.
+// Writes may be buffered and not flushed until Close.
+func NewWriter(w io.Writer) *Writer {
+	z, _ := NewWriterLevel(w, DefaultCompression)
+	return z
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level instead of assuming DefaultCompression.
+//
+// The compression level can be DefaultCompression, NoCompression, HuffmanOnly or any integer value between
+// BestSpeed and BestCompression inclusive. Additionally, levels up to MaxCompression are accepted.
+// The error returned will be nil if the level is valid.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < HuffmanOnly || level > MaxCompression {
+		return nil, fmt.Errorf("libdeflate: zlib: invalid compression level: %d", level)
+	}
+	fw, err := flate.NewWriter(w, level)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, level: level, fw: fw, adler32: 1}, nil
+}
+
+// Reset clears the state of the Writer z such that it is equivalent to its initial state from NewWriterLevel,
+// but instead writing to w.
+func (z *Writer) Reset(w io.Writer) {
+	z.w = w
+	z.fw.Reset(w)
+	z.adler32 = 1
+	z.wroteHeader = false
+	z.closed = false
+	z.err = nil
+}
+
+// Write writes a compressed form of p to the underlying io.Writer.
+// The compressed bytes are not necessarily flushed until the Writer is closed or explicitly flushed.
+func (z *Writer) Write(p []byte) (int, error) {
+	if err := z.writeHeader(); err != nil {
+		return 0, err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	n, err := z.fw.Write(p)
+	if err != nil {
+		z.err = err
+		return n, err
+	}
+	z.adler32 = libdeflate.Adler32(z.adler32, p)
+	return n, nil
+}
+
+// Flush flushes the Writer to its underlying io.Writer. See flate.Writer.Flush.
+func (z *Writer) Flush() error {
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	z.err = z.fw.Flush()
+	return z.err
+}
+
+// Close closes the Writer, flushing any unwritten data to the underlying io.Writer,
+// but does not close the underlying io.Writer.
+func (z *Writer) Close() error {
+	if z.closed {
+		return z.err
+	}
+	if err := z.writeHeader(); err != nil {
+		return err
+	}
+	z.closed = true
+
+	if z.err = z.fw.Close(); z.err != nil {
+		return z.err
+	}
+
+	var trailer [4]byte
+	binary.BigEndian.PutUint32(trailer[:], z.adler32)
+	_, z.err = z.w.Write(trailer[:])
+	return z.err
+}
+
+// writeHeader writes the zlib header if it has not been written yet.
+func (z *Writer) writeHeader() error {
+	if z.err != nil {
+		return z.err
+	}
+	if z.closed {
+		return errorWriterClosed
+	}
+	if z.wroteHeader {
+		return nil
+	}
+	z.wroteHeader = true
+
+	var hdr [2]byte
+	hdr[0] = zlibDeflate
+	switch {
+	case z.level == HuffmanOnly, z.level == NoCompression, z.level == BestSpeed:
+		hdr[1] = 0 << 6
+	case z.level < 6 && z.level != DefaultCompression:
+		hdr[1] = 1 << 6
+	case z.level == 6, z.level == DefaultCompression:
+		hdr[1] = 2 << 6
+	default:
+		hdr[1] = 3 << 6
+	}
+	hdr[1] += uint8(31 - binary.BigEndian.Uint16(hdr[:])%31)
+
+	_, z.err = z.w.Write(hdr[:])
+	return z.err
+}
@@ -0,0 +1,99 @@
+package zlib
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"testing"
+)
+
+var shortString = []byte("hello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\nhello, world\n")
+
+/*---------------------
+		UNIT TESTS
+-----------------------*/
+
+func TestWriterStdReader(t *testing.T) {
+	in := bytes.Repeat(shortString, 1000)
+
+	for _, level := range []int{HuffmanOnly, NoCompression, BestSpeed, 4, DefaultCompression, BestCompression, MaxCompression} {
+		buf := &bytes.Buffer{}
+		w, err := NewWriterLevel(buf, level)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(in[:100])
+		w.Flush()
+		w.Write(in[100:])
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+
+		r, err := zlib.NewReader(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Error(err)
+		}
+		slicesEqual(in, out, t)
+	}
+}
+
+func TestReaderStdWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := zlib.NewWriter(buf)
+	w.Write(shortString)
+	w.Close()
+
+	r, err := NewReader(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Error(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
+	slicesEqual(shortString, out, t)
+}
+
+func TestReaderErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w := NewWriter(buf)
+	w.Write(shortString)
+	w.Close()
+	comp := buf.Bytes()
+
+	corrupted := append([]byte{}, comp...)
+	corrupted[len(corrupted)-1]++
+	if _, err := NewReader(bytes.NewReader(corrupted)); err != ErrChecksum {
+		t.Error(err)
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte{0x78, 0x00})); err != ErrHeader {
+		t.Error(err)
+	}
+
+	buf.Reset()
+	dw, _ := zlib.NewWriterLevelDict(buf, zlib.DefaultCompression, []byte("hello"))
+	dw.Write(shortString)
+	dw.Close()
+	if _, err := NewReader(buf); err != ErrDictionary {
+		t.Error(err)
+	}
+}
+
+/*---------------------
+		HELPER
+-----------------------*/
+
+func slicesEqual(expected, actual []byte, t *testing.T) {
+	if !bytes.Equal(expected, actual) {
+		t.Error("slices differ")
+		t.FailNow()
+	}
+}